@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// apiKeysHash is the Valkey hash of the API keys managed at runtime, key to
+// name. It is kept in valkey.api_keys_db rather than the index or staging
+// database so imports, which flush those, leave it alone.
+const apiKeysHash = "cpe-guesser:apikeys"
+
+// apiKeyCacheTTL is how long a valid key read from Valkey is trusted, so a
+// revoked key stops working within that time.
+const apiKeyCacheTTL = time.Minute
+
+// apiKeys checks the API keys of requests against the keys of the config
+// and, when enabled, those in Valkey.
+type apiKeys struct {
+	// static maps the names of the configured keys to the keys
+	static map[string]string
+	// rdb reads apiKeysHash; nil unless server.api_keys_valkey is set
+	rdb  redis.UniversalClient
+	addr string
+
+	mu    sync.Mutex
+	cache map[string]cachedAPIKey
+}
+
+type cachedAPIKey struct {
+	name    string
+	expires time.Time
+}
+
+// newAPIKeys returns the API keys of cfg, nil when requests need none. The
+// Valkey client of prev is reused when its address is unchanged.
+func newAPIKeys(cfg *config.Config, redisAddr string, prev *apiKeys) *apiKeys {
+	if len(cfg.Server.APIKeys) == 0 && !cfg.Server.APIKeysValkey {
+		return nil
+	}
+	k := &apiKeys{static: cfg.Server.APIKeys, cache: make(map[string]cachedAPIKey)}
+	if cfg.Server.APIKeysValkey {
+		k.addr = redisAddr
+		if prev != nil && prev.rdb != nil && prev.addr == redisAddr {
+			k.rdb = prev.rdb
+		} else {
+			k.rdb = newRedisClient(cfg, redisAddr, cfg.GetAPIKeysDB())
+		}
+	}
+	return k
+}
+
+// name returns the name of key, or an empty string when it is not a valid
+// key.
+func (k *apiKeys) name(ctx context.Context, key string) (string, error) {
+	found := ""
+	for name, valid := range k.static {
+		// Compare every key, in constant time, to leak nothing of them
+		if subtle.ConstantTimeCompare([]byte(key), []byte(valid)) == 1 {
+			found = name
+		}
+	}
+	if found != "" || k.rdb == nil {
+		return found, nil
+	}
+
+	k.mu.Lock()
+	c, ok := k.cache[key]
+	k.mu.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		return c.name, nil
+	}
+	name, err := k.rdb.HGet(ctx, apiKeysHash, key).Result()
+	if err == redis.Nil {
+		name, err = "", nil
+	}
+	if err != nil || name == "" {
+		// Invalid keys aren't cached, so they can't fill the cache
+		return "", err
+	}
+	k.mu.Lock()
+	k.cache[key] = cachedAPIKey{name: name, expires: time.Now().Add(apiKeyCacheTTL)}
+	k.mu.Unlock()
+	return name, nil
+}
+
+// close closes the Valkey client of k.
+func (k *apiKeys) close() {
+	if k != nil && k.rdb != nil {
+		k.rdb.Close()
+	}
+}
+
+// requestAPIKey returns the key of an X-API-Key or Authorization: Bearer
+// header.
+func requestAPIKey(h http.Header) string {
+	if key := h.Get("X-API-Key"); key != "" {
+		return key
+	}
+	key, _ := strings.CutPrefix(h.Get("Authorization"), "Bearer ")
+	return key
+}
+
+// requireAPIKey rejects the requests to next without a valid API key when
+// the server has keys, and records the name of the key in the request log.
+func requireAPIKey(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := state.Load().keys
+		if keys == nil || authorize(w, r, keys) {
+			next(w, r)
+		}
+	})
+}
+
+// requireAdminKey rejects the requests to next without a valid admin key,
+// and all of them when the server has no admin keys.
+func requireAdminKey(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := state.Load().adminKeys
+		if keys == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if authorize(w, r, keys) {
+			next(w, r)
+		}
+	})
+}
+
+// authorize checks the API key of r against keys, answering the request
+// when it is missing or invalid, and records the name of a valid key in the
+// request log.
+func authorize(w http.ResponseWriter, r *http.Request, keys *apiKeys) bool {
+	key := requestAPIKey(r.Header)
+	if key == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="cpe-guesser"`)
+		http.Error(w, "missing API key", http.StatusUnauthorized)
+		return false
+	}
+	name, err := keys.name(r.Context(), key)
+	if err != nil {
+		slog.Warn("Could not check the API key", "err", err)
+		http.Error(w, "could not check the API key", http.StatusServiceUnavailable)
+		return false
+	}
+	if name == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="cpe-guesser", error="invalid_token"`)
+		http.Error(w, "invalid API key", http.StatusUnauthorized)
+		return false
+	}
+	setAPIKeyName(r, name)
+	return true
+}
+
+// grpcAuthenticate checks the API key of the x-api-key or authorization
+// metadata of a gRPC call, like requireAPIKey. Health checks need no key.
+func grpcAuthenticate(ctx context.Context, method string) error {
+	keys := state.Load().keys
+	if keys == nil || strings.HasSuffix(method, "/Health") {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	h := make(http.Header)
+	for _, name := range []string{"x-api-key", "authorization"} {
+		if v := md.Get(name); len(v) > 0 {
+			h.Set(name, v[0])
+		}
+	}
+	key := requestAPIKey(h)
+	if key == "" {
+		return status.Error(codes.Unauthenticated, "missing API key")
+	}
+	name, err := keys.name(ctx, key)
+	if err != nil {
+		slog.Warn("Could not check the API key", "err", err)
+		return status.Error(codes.Unavailable, "could not check the API key")
+	}
+	if name == "" {
+		return status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	return nil
+}
+
+func grpcUnaryAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := grpcAuthenticate(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamAuth(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := grpcAuthenticate(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
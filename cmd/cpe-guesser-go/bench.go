@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+	"github.com/go-redis/redis/v8"
+)
+
+// runBench measures search throughput and latency against the live index by
+// running sample queries through the same search path as the server.
+func runBench(fs *flag.FlagSet) func() {
+	queriesPath := fs.String("queries", "", "File with one query per line, words separated by spaces (default: random indexed words)")
+	samples := fs.Int("samples", 100, "Number of random queries to generate when no query file is given")
+	concurrency := fs.Int("concurrency", 8, "Number of concurrent workers")
+	duration := fs.Duration("duration", 30*time.Second, "How long to run")
+	redisHost := fs.String("redis", "", "Redis host:port (overrides config)")
+	configPath := fs.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
+
+	return func() {
+		cfg = loadConfig(*configPath)
+
+		redisAddr := cfg.GetRedisAddr()
+		if *redisHost != "" {
+			redisAddr = *redisHost
+		}
+
+		ctx := context.Background()
+		rdb := newRedisClient(cfg, redisAddr, cfg.GetIndexDB())
+		defer rdb.Close()
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+
+		var (
+			queries [][]string
+			err     error
+		)
+		if *queriesPath != "" {
+			queries, err = readQueries(*queriesPath)
+		} else {
+			queries, err = randomQueries(ctx, rdb, *samples)
+		}
+		if err != nil {
+			log.Fatalf("Failed to load queries: %v", err)
+		}
+		if len(queries) == 0 {
+			log.Fatal("No queries to run")
+		}
+
+		fmt.Printf("Running %d queries with %d workers for %s...\n", len(queries), *concurrency, *duration)
+
+		g := guesser.New(rdb)
+		deadline := time.Now().Add(*duration)
+		var (
+			mu        sync.Mutex
+			latencies []time.Duration
+			errCount  int
+			wg        sync.WaitGroup
+		)
+		start := time.Now()
+		for i := 0; i < *concurrency; i++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				var local []time.Duration
+				failed := 0
+				for n := worker; time.Now().Before(deadline); n += *concurrency {
+					q := queries[n%len(queries)]
+					t := time.Now()
+					_, _, err := g.Search(ctx, q, guesser.SearchOptions{})
+					local = append(local, time.Since(t))
+					if err != nil {
+						failed++
+					}
+				}
+				mu.Lock()
+				latencies = append(latencies, local...)
+				errCount += failed
+				mu.Unlock()
+			}(i)
+		}
+		wg.Wait()
+		elapsed := time.Since(start)
+
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		total := len(latencies)
+		fmt.Printf("%d requests in %s: %.1f QPS\n", total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds())
+		fmt.Printf("Latency p50: %s, p95: %s, p99: %s\n",
+			percentile(latencies, 50), percentile(latencies, 95), percentile(latencies, 99))
+		fmt.Printf("Errors: %d (%.2f%%)\n", errCount, 100*float64(errCount)/float64(max(total, 1)))
+	}
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p + 99) / 100
+	if i > 0 {
+		i--
+	}
+	return sorted[i]
+}
+
+func readQueries(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var queries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if words := strings.Fields(scanner.Text()); len(words) > 0 {
+			queries = append(queries, words)
+		}
+	}
+	return queries, scanner.Err()
+}
+
+// randomQueries picks up to n random indexed words as single-word queries.
+func randomQueries(ctx context.Context, rdb redis.UniversalClient, n int) ([][]string, error) {
+	node, err := guesser.IndexNode(ctx, rdb)
+	if err != nil {
+		return nil, err
+	}
+	wordPrefix := guesser.KeyPrefix(rdb) + "w:"
+	var queries [][]string
+	for attempts := 0; len(queries) < n && attempts < n*100; attempts++ {
+		key, err := node.RandomKey(ctx).Result()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if word, ok := strings.CutPrefix(key, wordPrefix); ok {
+			queries = append(queries, []string{word})
+		}
+	}
+	rand.Shuffle(len(queries), func(i, j int) { queries[i], queries[j] = queries[j], queries[i] })
+	return queries, nil
+}
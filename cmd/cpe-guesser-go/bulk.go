@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// bulkName is a software name of a bulk query and its position in the input.
+type bulkName struct {
+	n    int
+	name string
+	cpe  string
+}
+
+// runBulkQuery maps each software name of path, one per line, to its best
+// CPE with query and writes the mappings to stdout in format, ndjson or csv,
+// in input order. Blank lines and lines starting with # are skipped. path -
+// reads stdin. The names are looked up by workers concurrent lookups.
+func runBulkQuery(query queryFunc, path, format string, workers int) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open names: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	jobs := make(chan bulkName, workers)
+	done := make(chan bulkName, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				_, cpe, err := query(strings.Fields(job.name), true, 0)
+				if err != nil {
+					slog.Warn("Lookup failed", "name", job.name, "err", err)
+				}
+				job.cpe = cpe
+				done <- job
+			}
+		}()
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(in)
+		n := 0
+		for scanner.Scan() {
+			name := strings.TrimSpace(scanner.Text())
+			if name == "" || strings.HasPrefix(name, "#") {
+				continue
+			}
+			jobs <- bulkName{n: n, name: name}
+			n++
+		}
+		if err := scanner.Err(); err != nil {
+			slog.Warn("Could not read names", "err", err)
+		}
+		close(jobs)
+		wg.Wait()
+		close(done)
+	}()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var cw *csv.Writer
+	if format == "csv" {
+		cw = csv.NewWriter(out)
+		defer cw.Flush()
+		cw.Write([]string{"name", "cpe"})
+	}
+	enc := json.NewEncoder(out)
+
+	// Lookups finish out of order, hold them until the earlier names are out
+	pending := make(map[int]bulkName)
+	next, mapped := 0, 0
+	for job := range done {
+		pending[job.n] = job
+		for {
+			job, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if job.cpe != "" {
+				mapped++
+			}
+			if cw != nil {
+				cw.Write([]string{job.name, job.cpe})
+				continue
+			}
+			// Names without a match are reported as null
+			rec := struct {
+				Name string  `json:"name"`
+				CPE  *string `json:"cpe"`
+			}{Name: job.name}
+			if job.cpe != "" {
+				rec.CPE = &job.cpe
+			}
+			enc.Encode(rec)
+		}
+	}
+	slog.Info("Mapped names", "mapped", mapped, "names", next)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+)
+
+// Fields of the import checkpoint besides the batches written, which are
+// recorded under their sequence number with the number of entries read so
+// far.
+const (
+	checkpointRun     = "run"
+	checkpointStarted = "started"
+)
+
+// importMode names the flags that select how an import writes the index.
+func importMode(replace, update, swap, incremental bool) string {
+	var mode []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{{"replace", replace}, {"update", update}, {"swap", swap}, {"incremental", incremental}} {
+		if f.set {
+			mode = append(mode, f.name)
+		}
+	}
+	if len(mode) == 0 {
+		return "new"
+	}
+	return strings.Join(mode, ",")
+}
+
+// describeRun describes an import for its checkpoint: the dictionary file it
+// reads and the settings deciding what it writes and in which batches. A
+// resumed import must match the interrupted one. A streamed import, which
+// can't be resumed, is described by its source.
+func describeRun(c *config.Config, mode string, stream bool, opts populateOptions) string {
+	if stream {
+		source, _ := c.GetCPESource(time.Now())
+		return fmt.Sprintf("%s (streamed) mode=%s rank-policy=%s only-part=%s batch-size=%d references=%t versions=%t titles=%t",
+			source, mode, opts.rankPolicy, opts.onlyParts, opts.batchSize, opts.references, opts.versions, opts.titles)
+	}
+	path := c.GetCPEPath()
+	fi, err := os.Stat(path)
+	if err != nil {
+		log.Fatalf("Failed to read the CPE file: %v", err)
+	}
+	return fmt.Sprintf("%s (%d bytes, modified %s) mode=%s rank-policy=%s only-part=%s batch-size=%d references=%t versions=%t titles=%t",
+		path, fi.Size(), fi.ModTime().UTC().Format(time.RFC3339), mode, opts.rankPolicy, opts.onlyParts,
+		opts.batchSize, opts.references, opts.versions, opts.titles)
+}
+
+// startCheckpoint replaces the checkpoint of store with the one of a new
+// import described by run.
+func startCheckpoint(ctx context.Context, store guesser.Store, run string, started time.Time) {
+	batch := store.NewBatch()
+	batch.ClearCheckpoint()
+	batch.SetCheckpoint(checkpointRun, run)
+	batch.SetCheckpoint(checkpointStarted, started.UTC().Format(time.RFC3339))
+	if err := batch.Exec(ctx); err != nil {
+		log.Fatalf("Failed to record the import checkpoint: %v", err)
+	}
+}
+
+// resumeCheckpoint returns the batches the interrupted import of store wrote
+// before it stopped, and the time it started. It exits when store holds no
+// interrupted import, or one other than run.
+func resumeCheckpoint(ctx context.Context, store guesser.Store, run string) (map[int]bool, time.Time) {
+	fields, err := store.Checkpoint(ctx)
+	if err != nil {
+		log.Fatalf("Failed to read the import checkpoint: %v", err)
+	}
+	if fields[checkpointRun] == "" {
+		log.Fatal("No interrupted import to resume")
+	}
+	if fields[checkpointRun] != run {
+		log.Fatalf("The interrupted import differs from this one; resume it with the same flags and CPE file, or start over without --resume\n  interrupted: %s\n  this one:    %s",
+			fields[checkpointRun], run)
+	}
+	started, err := time.Parse(time.RFC3339, fields[checkpointStarted])
+	if err != nil {
+		log.Fatalf("Invalid import checkpoint: %v", err)
+	}
+	done := make(map[int]bool)
+	for field := range fields {
+		if seq, err := strconv.Atoi(field); err == nil {
+			done[seq] = true
+		}
+	}
+	fmt.Fprintf(importOut, "Resuming the import started %s, %d batches of entries already written\n",
+		started.Format(time.RFC3339), len(done))
+	return done, started
+}
+
+// discardBatch drops its writes, standing in for the batches a resumed
+// import already wrote.
+type discardBatch struct{}
+
+func (discardBatch) AddWord(word, cpe string)                           {}
+func (discardBatch) AddProduct(vendor, cpe string)                      {}
+func (discardBatch) SetTitle(cpe, title string)                         {}
+func (discardBatch) AddReference(cpe, ref string)                       {}
+func (discardBatch) AddVersion(cpe, version string)                     {}
+func (discardBatch) SetDeprecated(cpe, replacement string)              {}
+func (discardBatch) ClearDeprecated(cpe string)                         {}
+func (discardBatch) SetLastImport(t time.Time)                          {}
+func (discardBatch) SetCheckpoint(field, value string)                  {}
+func (discardBatch) ClearCheckpoint()                                   {}
+func (discardBatch) IncrRank(words []string, cpe string, delta float64) {}
+func (discardBatch) SetRank(words []string, cpe string, rank float64)   {}
+func (discardBatch) Exec(ctx context.Context) error                     { return nil }
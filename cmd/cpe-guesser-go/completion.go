@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// progName is the binary name the completion scripts are registered for.
+const progName = "cpe-guesser-go"
+
+// completionShells are the shells runCompletion writes scripts for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// runCompletion prints a completion script for the shell given as argument,
+// covering every subcommand and its flags.
+func runCompletion(fs *flag.FlagSet) func() {
+	return func() {
+		switch fs.Arg(0) {
+		case "bash":
+			writeBashCompletion(os.Stdout)
+		case "zsh":
+			writeZshCompletion(os.Stdout)
+		case "fish":
+			writeFishCompletion(os.Stdout)
+		default:
+			log.Fatalf("Usage: completion <%s>", strings.Join(completionShells, "|"))
+		}
+	}
+}
+
+// commandFlag is a flag of a subcommand as listed in completions.
+type commandFlag struct {
+	name  string
+	usage string
+}
+
+// commandFlags lists the flags cmd defines, without running it.
+func commandFlags(cmd command) []commandFlag {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	logFlags(fs)
+	cmd.setup(fs)
+	var flags []commandFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, commandFlag{name: f.Name, usage: f.Usage})
+	})
+	return flags
+}
+
+// completionWords returns the words completing the arguments of cmd: its
+// positional words and its flags.
+func completionWords(cmd command) []string {
+	words := append([]string(nil), cmd.args...)
+	for _, f := range commandFlags(cmd) {
+		words = append(words, "-"+f.name)
+	}
+	return words
+}
+
+func writeBashCompletion(w io.Writer) {
+	var names []string
+	for _, cmd := range commands() {
+		names = append(names, cmd.name)
+	}
+	fmt.Fprintf(w, "# bash completion for %s\n", progName)
+	fmt.Fprintf(w, "_cpe_guesser_go() {\n")
+	fmt.Fprintf(w, "\tlocal cur=${COMP_WORDS[COMP_CWORD]}\n")
+	fmt.Fprintf(w, "\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "\t\treturn\n\tfi\n")
+	fmt.Fprintf(w, "\tcase ${COMP_WORDS[1]} in\n")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", cmd.name, strings.Join(completionWords(cmd), " "))
+	}
+	fmt.Fprintf(w, "\tesac\n}\n")
+	fmt.Fprintf(w, "complete -o default -F _cpe_guesser_go %s\n", progName)
+}
+
+func writeZshCompletion(w io.Writer) {
+	fmt.Fprintf(w, "#compdef %s\n\n", progName)
+	fmt.Fprintf(w, "_cpe_guesser_go() {\n")
+	fmt.Fprintf(w, "\tlocal -a subcommands\n\tsubcommands=(\n")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "\t\t%s\n", zshQuote(cmd.name+":"+cmd.summary))
+	}
+	fmt.Fprintf(w, "\t)\n")
+	fmt.Fprintf(w, "\tif (( CURRENT == 2 )); then\n\t\t_describe command subcommands\n\t\treturn\n\tfi\n")
+	fmt.Fprintf(w, "\tcase $words[2] in\n")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "\t%s) compadd -- %s ;;\n", cmd.name, strings.Join(completionWords(cmd), " "))
+	}
+	fmt.Fprintf(w, "\tesac\n\t_files\n}\n\n")
+	fmt.Fprintf(w, "compdef _cpe_guesser_go %s\n", progName)
+}
+
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprintf(w, "# fish completion for %s\n", progName)
+	fmt.Fprintf(w, "complete -c %s -f -n __fish_use_subcommand\n", progName)
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "complete -c %s -f -n __fish_use_subcommand -a %s -d %s\n", progName, cmd.name, fishQuote(cmd.summary))
+		seen := fmt.Sprintf("'__fish_seen_subcommand_from %s'", cmd.name)
+		if len(cmd.args) > 0 {
+			fmt.Fprintf(w, "complete -c %s -f -n %s -a %s\n", progName, seen, fishQuote(strings.Join(cmd.args, " ")))
+		}
+		for _, f := range commandFlags(cmd) {
+			fmt.Fprintf(w, "complete -c %s -n %s -o %s -d %s\n", progName, seen, f.name, fishQuote(f.usage))
+		}
+	}
+}
+
+// zshQuote single-quotes s for zsh.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote single-quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
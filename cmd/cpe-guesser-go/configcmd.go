@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// runConfig dispatches the config subcommands. Only "check" exists: it loads
+// and validates a config file and prints the effective settings without
+// connecting to anything.
+func runConfig(fs *flag.FlagSet) func() {
+	configPath := fs.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
+
+	return func() {
+		if fs.Arg(0) != "check" {
+			log.Fatal("Usage: config check [-config path]")
+		}
+
+		c, err := config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+			os.Exit(1)
+		}
+		if err := c.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid config:\n%v\n", err)
+			os.Exit(1)
+		}
+
+		// Keep the API keys out of terminals and CI logs
+		shown := *c
+		if shown.NVD.APIKey != "" {
+			shown.NVD.APIKey = "<redacted>"
+		}
+		if shown.Valkey.Password != "" {
+			shown.Valkey.Password = "<redacted>"
+		}
+		if len(c.Server.APIKeys) > 0 {
+			shown.Server.APIKeys = make(map[string]string, len(c.Server.APIKeys))
+			for name := range c.Server.APIKeys {
+				shown.Server.APIKeys[name] = "<redacted>"
+			}
+		}
+		out, err := yaml.Marshal(&shown)
+		if err != nil {
+			log.Fatalf("Failed to print config: %v", err)
+		}
+		source, _ := c.GetCPESource(time.Now())
+		overall, connect, tls := c.GetDownloadTimeouts()
+
+		fmt.Println("# Effective configuration")
+		fmt.Print(string(out))
+		fmt.Println("# Resolved values")
+		fmt.Printf("redis_addr: %s\n", c.GetRedisAddr())
+		if readAddr := c.GetReadRedisAddr(); readAddr != "" {
+			fmt.Printf("redis_read_addr: %s\n", readAddr)
+		}
+		fmt.Printf("index_db: %d\n", c.GetIndexDB())
+		fmt.Printf("staging_db: %d\n", c.GetStagingDB())
+		fmt.Printf("api_keys_db: %d\n", c.GetAPIKeysDB())
+		fmt.Printf("cpe_path: %s\n", c.GetCPEPath())
+		fmt.Printf("cpe_source: %s\n", source)
+		if meta, _ := c.GetCPEMetaSource(source); meta != "" {
+			fmt.Printf("cpe_meta_source: %s\n", meta)
+		}
+		fmt.Printf("download_timeouts: %s overall, %s connect, %s tls\n", overall, connect, tls)
+		fmt.Printf("read_buffer: %d\n", c.GetReadBuffer())
+		fmt.Printf("import_workers: %d\n", c.GetImportWorkers())
+		fmt.Printf("batch_size: %d\n", c.GetBatchSize())
+		fmt.Printf("max_partial_words: %d\n", c.GetMaxPartialWords())
+		fmt.Printf("token_separators: %q\n", c.GetTokenSeparators())
+		if c.NVD.Enabled {
+			fmt.Printf("nvd_url: %s\n", c.GetNVDURL())
+			fmt.Printf("nvd_results_per_page: %d\n", c.GetNVDResultsPerPage())
+			fmt.Printf("nvd_request_interval: %s\n", c.GetNVDRequestInterval())
+		}
+		if c.CVE.Enabled {
+			fmt.Printf("cve_api: %s\n", c.GetCVEAPI())
+			fmt.Printf("cve_url: %s\n", c.GetCVEURL())
+			fmt.Printf("cve_timeout: %s\n", c.GetCVETimeout())
+			fmt.Printf("cve_latest: %d\n", c.GetCVELatest())
+			fmt.Printf("cve_cache_ttl: %s\n", c.GetCVECacheTTL())
+		}
+		fmt.Println("Config OK")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// corsHeaders are the request headers browsers may send cross-origin.
+const corsHeaders = "Accept, Authorization, Content-Type, X-API-Key"
+
+// corsExposed are the response headers browsers let cross-origin scripts
+// read.
+const corsExposed = "X-Total-Count, X-Partial-Results, X-Partial-Skipped, X-Version-Unknown"
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight answer.
+const corsMaxAge = 600
+
+// withCORS adds the CORS headers of server.cors to the responses of next for
+// requests from an allowed origin, and answers their preflight requests.
+// Preflights are answered before next, so they need no API key.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := state.Load().cfg
+		origin := r.Header.Get("Origin")
+		allowed := cfg.Server.CORS.AllowedOrigins
+		if origin == "" || len(allowed) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !slices.Contains(allowed, "*") && !slices.Contains(allowed, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", strings.Join(cfg.GetCORSMethods(), ", "))
+			h.Set("Access-Control-Allow-Headers", corsHeaders)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposed)
+		next.ServeHTTP(w, r)
+	})
+}
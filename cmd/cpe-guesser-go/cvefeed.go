@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+)
+
+// cveMatch is a CPE match of a CVE configuration. Feeds 2.0 name the CPE
+// criteria, feeds 1.1 cpe23Uri.
+type cveMatch struct {
+	Vulnerable bool   `json:"vulnerable"`
+	Criteria   string `json:"criteria"`
+	CPE23URI   string `json:"cpe23Uri"`
+}
+
+// cveNode is a node of a CVE configuration. Feeds 1.1 nest nodes in
+// children.
+type cveNode struct {
+	CPEMatch  []cveMatch `json:"cpeMatch"`
+	CPEMatch1 []cveMatch `json:"cpe_match"`
+	Children  []cveNode  `json:"children"`
+}
+
+// cveItem is a CVE of a feed 2.0 vulnerabilities array or a feed 1.1
+// CVE_Items array.
+type cveItem struct {
+	CVE struct {
+		ID             string `json:"id"`
+		Configurations []struct {
+			Nodes []cveNode `json:"nodes"`
+		} `json:"configurations"`
+		Meta struct {
+			ID string `json:"ID"`
+		} `json:"CVE_data_meta"`
+	} `json:"cve"`
+	Configurations struct {
+		Nodes []cveNode `json:"nodes"`
+	} `json:"configurations"`
+}
+
+// lines adds the CPE lines the CVE is reported for to lines.
+func (it *cveItem) lines(lines map[string]bool) {
+	var walk func(nodes []cveNode)
+	walk = func(nodes []cveNode) {
+		for _, n := range nodes {
+			for _, m := range append(n.CPEMatch, n.CPEMatch1...) {
+				name := m.Criteria
+				if name == "" {
+					name = m.CPE23URI
+				}
+				if _, vendor, product, _, line := extract(name); m.Vulnerable && vendor != "" && product != "" {
+					lines[line] = true
+				}
+			}
+			walk(n.Children)
+		}
+	}
+	for _, c := range it.CVE.Configurations {
+		walk(c.Nodes)
+	}
+	walk(it.Configurations.Nodes)
+}
+
+// loadCVEFeeds returns the CVE counts of the feeds of c, exiting on error.
+func loadCVEFeeds(ctx context.Context, c *config.Config) map[string]int {
+	counts, err := loadCVECounts(ctx, c)
+	if err != nil {
+		log.Fatalf("Failed to read CVE feeds: %v", err)
+	}
+	return counts
+}
+
+// loadCVECounts reads the NVD CVE feeds of c and returns the number of
+// distinct CVEs reporting each CPE line as vulnerable.
+func loadCVECounts(ctx context.Context, c *config.Config) (map[string]int, error) {
+	counts := make(map[string]int)
+	seen := make(map[string]bool)
+	timeout, _, _ := c.GetDownloadTimeouts()
+	for _, feed := range c.CPE.CVEFeeds {
+		fmt.Fprintf(importOut, "Reading CVEs from %s ...\n", feed)
+		n, err := readCVEFeed(ctx, feed, timeout, counts, seen)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", feed, err)
+		}
+		fmt.Fprintf(importOut, "Read %d CVEs from %s\n", n, feed)
+	}
+	return counts, nil
+}
+
+// readCVEFeed adds the CPE lines of the CVEs in feed, a JSON 2.0 or 1.1 feed
+// file or URL, gzip-compressed or not, to counts. A download is bounded by
+// timeout. CVEs in seen, read from an earlier feed, are skipped. It returns
+// the number of CVEs read.
+func readCVEFeed(ctx context.Context, feed string, timeout time.Duration, counts map[string]int, seen map[string]bool) (int, error) {
+	var body io.ReadCloser
+	var err error
+	if strings.Contains(feed, "://") {
+		dctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		body, err = openSource(dctx, feed)
+	} else {
+		body, err = os.Open(feed)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	var r io.Reader = bufio.NewReader(body)
+	head, _ := r.(*bufio.Reader).Peek(len(gzipMagic))
+	if bytes.Equal(head, gzipMagic) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	dec := json.NewDecoder(r)
+	if err := seekCVEItems(dec); err != nil {
+		return 0, err
+	}
+	read := 0
+	for dec.More() {
+		var it cveItem
+		if err := dec.Decode(&it); err != nil {
+			return read, fmt.Errorf("JSON decode error: %w", err)
+		}
+		read++
+		id := it.CVE.ID
+		if id == "" {
+			id = it.CVE.Meta.ID
+		}
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		lines := make(map[string]bool)
+		it.lines(lines)
+		for line := range lines {
+			counts[line]++
+		}
+	}
+	return read, nil
+}
+
+// seekCVEItems moves dec into the top-level array of CVEs of a feed,
+// vulnerabilities in feeds 2.0 and CVE_Items in feeds 1.1.
+func seekCVEItems(dec *json.Decoder) error {
+	if tok, err := dec.Token(); err != nil {
+		return fmt.Errorf("JSON parse error: %w", err)
+	} else if tok != json.Delim('{') {
+		return errors.New("CVE feed is not an object")
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("JSON parse error: %w", err)
+		}
+		if key == "vulnerabilities" || key == "CVE_Items" {
+			if tok, err := dec.Token(); err != nil {
+				return fmt.Errorf("JSON parse error: %w", err)
+			} else if tok != json.Delim('[') {
+				return fmt.Errorf("CVE feed %s is not an array", key)
+			}
+			return nil
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return fmt.Errorf("JSON parse error: %w", err)
+		}
+	}
+	return errors.New("CVE feed has no vulnerabilities or CVE_Items array")
+}
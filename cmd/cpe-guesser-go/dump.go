@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+)
+
+// dumpFormat identifies index dumps, and dumpVersion the layout of their
+// entries.
+const (
+	dumpFormat  = "cpe-guesser-index"
+	dumpVersion = 1
+)
+
+// dumpChunk is the number of words or CPEs looked up at a time by export.
+const dumpChunk = 1000
+
+// An index dump is a gzip-compressed stream of JSON lines: a dumpHeader, then
+// a dumpEntry per indexed CPE line, sorted.
+type dumpHeader struct {
+	Format   string    `json:"format"`
+	Version  int       `json:"version"`
+	Exported time.Time `json:"exported"`
+	// LastImport is the start of the import which built the index, which
+	// the next incremental import starts from
+	LastImport time.Time `json:"last_import"`
+	CPEs       int       `json:"cpes"`
+	Words      int       `json:"words"`
+}
+
+// dumpEntry is an indexed CPE line with its rank, the words it is indexed
+// under and what is stored about it.
+type dumpEntry struct {
+	CPE        string   `json:"cpe"`
+	Rank       float64  `json:"rank"`
+	Words      []string `json:"words"`
+	Title      string   `json:"title,omitempty"`
+	References []string `json:"refs,omitempty"`
+	Versions   []string `json:"versions,omitempty"`
+	// Deprecated marks deprecated lines, and Replacement names the CPE
+	// replacing one, when there is one
+	Deprecated  bool   `json:"deprecated,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// runExport writes the index of the configured storage backend to a dump
+// file, which restore loads into another index without the dictionary.
+func runExport(fs *flag.FlagSet) func() {
+	out := fs.String("out", "", "File to write the index dump to (required)")
+	redisHost := fs.String("redis", "", "Redis host:port (overrides config)")
+	configPath := fs.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
+
+	return func() {
+		if *out == "" {
+			log.Fatal("Please specify the dump file with -out")
+		}
+
+		cfg = loadConfig(*configPath)
+		store, closeStore := openIndex(cfg, *redisHost)
+		defer closeStore()
+
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("File create error: %v", err)
+		}
+		h, err := exportIndex(ctx, store, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(*out)
+			log.Fatalf("Failed to export the index: %v", err)
+		}
+
+		var size int64
+		if info, err := os.Stat(*out); err == nil {
+			size = info.Size()
+		}
+		fmt.Printf("Exported %d CPE lines under %d distinct words to %s (%s)\n", h.CPEs, h.Words, *out, formatBytes(size))
+	}
+}
+
+// openIndex returns the index of the storage backend of c and the function
+// releasing it. The memory backend builds its index from the dictionary.
+func openIndex(c *config.Config, redisOverride string) (guesser.Store, func()) {
+	switch c.Storage.Backend {
+	case config.BackendMemory:
+		return loadMemoryIndex(c), func() {}
+	case config.BackendBolt, config.BackendSQLite:
+		store := openFileIndex(c)
+		return store, func() { store.Close() }
+	}
+	redisAddr := c.GetRedisAddr()
+	if redisOverride != "" {
+		redisAddr = redisOverride
+	}
+	rdb := newRedisClient(c, redisAddr, c.GetIndexDB())
+	return guesser.NewRedisStore(rdb), func() { rdb.Close() }
+}
+
+// exportIndex writes the dump of store to w. The word sets are inverted in
+// memory to list the words of each CPE line.
+func exportIndex(ctx context.Context, store guesser.Store, w io.Writer) (*dumpHeader, error) {
+	var words []string
+	err := store.Words(ctx, func(chunk []string) error {
+		words = append(words, chunk...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing the words: %w", err)
+	}
+	// Words are added in order, so the words of every line are sorted
+	sort.Strings(words)
+	cpeWords := make(map[string][]string)
+	for rest := words; len(rest) > 0; {
+		chunk := rest[:min(len(rest), dumpChunk)]
+		rest = rest[len(chunk):]
+		sets, err := store.Members(ctx, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("reading the word sets: %w", err)
+		}
+		for i, cpes := range sets {
+			for _, cpe := range cpes {
+				cpeWords[cpe] = append(cpeWords[cpe], chunk[i])
+			}
+		}
+	}
+	cpes := make([]string, 0, len(cpeWords))
+	for cpe := range cpeWords {
+		cpes = append(cpes, cpe)
+	}
+	sort.Strings(cpes)
+
+	imported, err := store.LastImport(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading the last import time: %w", err)
+	}
+	h := &dumpHeader{
+		Format:     dumpFormat,
+		Version:    dumpVersion,
+		Exported:   time.Now().UTC(),
+		LastImport: imported.UTC(),
+		CPEs:       len(cpes),
+		Words:      len(words),
+	}
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(h); err != nil {
+		return nil, err
+	}
+	for rest := cpes; len(rest) > 0; {
+		chunk := rest[:min(len(rest), dumpChunk)]
+		rest = rest[len(chunk):]
+		entries, err := dumpEntries(ctx, store, chunk, cpeWords)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return h, zw.Close()
+}
+
+// dumpEntries returns the dump entries of cpes, whose words are in cpeWords.
+func dumpEntries(ctx context.Context, store guesser.Store, cpes []string, cpeWords map[string][]string) ([]dumpEntry, error) {
+	ranks, err := store.Ranks(ctx, cpes)
+	if err != nil {
+		return nil, fmt.Errorf("reading the ranks: %w", err)
+	}
+	titles, err := store.Titles(ctx, cpes)
+	if err != nil {
+		return nil, fmt.Errorf("reading the titles: %w", err)
+	}
+	refs, err := store.References(ctx, cpes)
+	if err != nil {
+		return nil, fmt.Errorf("reading the references: %w", err)
+	}
+	deprecated, err := store.Deprecated(ctx, cpes)
+	if err != nil {
+		return nil, fmt.Errorf("reading the deprecated lines: %w", err)
+	}
+	entries := make([]dumpEntry, len(cpes))
+	for i, cpe := range cpes {
+		versions, err := store.Versions(ctx, cpe)
+		if err != nil {
+			return nil, fmt.Errorf("reading the versions of %s: %w", cpe, err)
+		}
+		sort.Strings(refs[i])
+		sort.Strings(versions)
+		repl, dep := deprecated[cpe]
+		entries[i] = dumpEntry{
+			CPE:         cpe,
+			Rank:        ranks[i],
+			Words:       cpeWords[cpe],
+			Title:       titles[i],
+			References:  refs[i],
+			Versions:    versions,
+			Deprecated:  dep,
+			Replacement: repl,
+		}
+	}
+	return entries, nil
+}
+
+// dumpReader reads the entries of an index dump whose header it checked.
+type dumpReader struct {
+	header dumpHeader
+	dec    *json.Decoder
+}
+
+// openDump reads the header of the index dump in r.
+func openDump(r io.Reader) (*dumpReader, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not an index dump: %w", err)
+	}
+	d := &dumpReader{dec: json.NewDecoder(zr)}
+	if err := d.dec.Decode(&d.header); err != nil || d.header.Format != dumpFormat {
+		return nil, errors.New("not an index dump")
+	}
+	if d.header.Version != dumpVersion {
+		return nil, fmt.Errorf("index dump version %d, this binary reads version %d", d.header.Version, dumpVersion)
+	}
+	return d, nil
+}
+
+// restore writes the entries of the dump to batches made by newBatch, like
+// populate, every batchSize lines to one of workers goroutines. The words and
+// ranks are written as exported, whatever the tokenization and rank settings.
+func (d *dumpReader) restore(ctx context.Context, newBatch func() guesser.Batch, workers, batchSize int, prog *progressReporter) (*importStats, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pool := newBatchPool(ctx, newBatch, workers)
+	defer pool.wait()
+	stats := &importStats{}
+	start := time.Now()
+
+	batch, err := pool.get()
+	for err == nil {
+		var e dumpEntry
+		if err = d.dec.Decode(&e); err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", stats.items+1, err)
+		}
+		_, vendor, product, _, cpeline := extract(e.CPE)
+		if vendor == "" || product == "" || cpeline != e.CPE || len(e.Words) == 0 {
+			return nil, fmt.Errorf("entry %d: invalid CPE line %q", stats.items+1, e.CPE)
+		}
+		stats.items++
+		for _, w := range e.Words {
+			batch.AddWord(w, e.CPE)
+			stats.words++
+		}
+		batch.AddProduct(vendor, e.CPE)
+		batch.SetRank(e.Words, e.CPE, e.Rank)
+		if e.Title != "" {
+			batch.SetTitle(e.CPE, e.Title)
+		}
+		for _, ref := range e.References {
+			batch.AddReference(e.CPE, ref)
+		}
+		for _, v := range e.Versions {
+			batch.AddVersion(e.CPE, v)
+		}
+		if e.Deprecated {
+			batch.SetDeprecated(e.CPE, e.Replacement)
+			stats.deprecated++
+		}
+		if stats.items%batchSize == 0 {
+			pool.put(batch)
+			batch, err = pool.get()
+			prog.report(stats.items, stats.words)
+		}
+	}
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("pipeline execution error: %w", err)
+	}
+	// A truncated dump fails its gzip checksum, or ends early
+	if stats.items != d.header.CPEs {
+		return nil, fmt.Errorf("the dump ends after %d of its %d CPE lines", stats.items, d.header.CPEs)
+	}
+
+	pool.put(batch)
+	if err := pool.wait(); err != nil {
+		return nil, fmt.Errorf("final pipeline execution error: %w", err)
+	}
+	stats.lines = stats.items
+	stats.elapsed = time.Since(start)
+	return stats, nil
+}
+
+// lastImport returns the time the restored index records as its last
+// import: that of the exported index, or now when it recorded none.
+func (d *dumpReader) lastImport() time.Time {
+	if d.header.LastImport.IsZero() {
+		return time.Now()
+	}
+	return d.header.LastImport
+}
+
+// fetchIndexDump opens the index dump published at cpe.index_snapshot of c
+// and reads its header. The dump is then restored as it arrives, within the
+// download timeout. The returned function releases the download.
+func fetchIndexDump(ctx context.Context, c *config.Config) (*dumpReader, progressSource, func()) {
+	source := c.CPE.IndexSnapshot
+	fmt.Fprintf(importOut, "Fetching the index dump %s ...\n", source)
+	timeout, _, _ := c.GetDownloadTimeouts()
+	dctx, cancel := context.WithTimeout(ctx, timeout)
+	body, err := openSource(dctx, source)
+	if err != nil {
+		cancel()
+		if isTimeout(err) {
+			log.Fatalf("Download timed out after %s: %v", timeout, err)
+		}
+		log.Fatalf("Download error: %v", err)
+	}
+	release := func() {
+		body.Close()
+		cancel()
+	}
+
+	input := &inputProgress{}
+	input.total.Store(downloadSize(body))
+	d, err := openDump(bufio.NewReaderSize(countingReader{r: body, p: input}, c.GetReadBuffer()))
+	if err != nil {
+		release()
+		log.Fatalf("Failed to read the index dump %s: %v", source, err)
+	}
+	return d, input, release
+}
+
+// restoreOptions control how restoreIndex loads a dump.
+type restoreOptions struct {
+	// replace empties the index first, and swap builds a new one and
+	// swaps it in once complete
+	replace, swap bool
+	// progress receives the progress lines, nil prints none, and input
+	// tells how much of the dump was read
+	progress io.Writer
+	input    progressSource
+	asJSON   bool
+}
+
+// restoreIndex loads the index dump d into the Valkey index or the index
+// file of c, as an import would with the same options.
+func restoreIndex(ctx context.Context, c *config.Config, redisOverride string, d *dumpReader, opts restoreOptions) {
+	fmt.Fprintf(importOut, "Restoring the index exported %s: %d CPE lines under %d distinct words\n",
+		d.header.Exported.Format(time.RFC3339), d.header.CPEs, d.header.Words)
+	start := time.Now()
+	prog := &progressReporter{w: opts.progress, src: opts.input, start: start, last: start}
+
+	if c.Storage.Backend == config.BackendBolt || c.Storage.Backend == config.BackendSQLite {
+		restoreFile(ctx, c, d, prog, opts)
+		return
+	}
+
+	indexDB, stagingDB := c.GetIndexDB(), c.GetStagingDB()
+	if opts.swap && c.Valkey.Cluster {
+		log.Fatal("--swap needs SWAPDB, which a Valkey cluster does not support")
+	}
+	if opts.swap && stagingDB == indexDB {
+		log.Fatalf("Staging DB must differ from the index DB %d", indexDB)
+	}
+	redisAddr := c.GetRedisAddr()
+	if redisOverride != "" {
+		redisAddr = redisOverride
+	}
+	rdb := newRedisClient(c, redisAddr, indexDB)
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	dbSize, err := indexSize(ctx, rdb)
+	if err != nil {
+		log.Fatalf("Redis DBSize error: %v", err)
+	}
+	if dbSize > 0 && !opts.replace && !opts.swap {
+		log.Fatalf("Warning: Redis contains %d keys. Use --replace or --swap.", dbSize)
+	}
+
+	serving := rdb
+	if opts.swap {
+		rdb = newRedisClient(c, redisAddr, stagingDB)
+		fmt.Fprintf(importOut, "Building index in staging DB %d...\n", stagingDB)
+		if err := rdb.FlushDB(ctx).Err(); err != nil {
+			log.Fatalf("Failed to flush staging database: %v", err)
+		}
+	} else if dbSize > 0 {
+		fmt.Fprintf(importOut, "Flushing %d keys...\n", dbSize)
+		if err := flushIndex(ctx, rdb); err != nil {
+			log.Fatalf("Failed to flush database: %v", err)
+		}
+	}
+
+	store := guesser.NewRedisStore(rdb)
+	stats, err := d.restore(ctx, store.NewBatch, c.GetImportWorkers(), c.GetBatchSize(), prog)
+	if err != nil {
+		log.Fatalf("Restore failed: %v", err)
+	}
+	recordImport(ctx, store, d.lastImport())
+	// Every word got its trigrams
+	if err := rdb.Set(ctx, guesser.KeyPrefix(rdb)+guesser.TrigramsKey, "1", 0).Err(); err != nil {
+		slog.Warn("Could not enable the trigram index", "err", err)
+	}
+	finalSize, err := indexSize(ctx, rdb)
+	if err != nil {
+		slog.Warn("Could not get final DB size", "err", err)
+	}
+
+	if opts.swap {
+		if err := serving.Do(ctx, "SWAPDB", indexDB, stagingDB).Err(); err != nil {
+			log.Fatalf("Failed to swap staging DB %d into DB %d: %v", stagingDB, indexDB, err)
+		}
+		fmt.Fprintf(importOut, "Swapped staging DB %d into DB %d\n", stagingDB, indexDB)
+		if err := rdb.FlushDB(ctx).Err(); err != nil {
+			slog.Warn("Could not flush old index from staging DB", "db", stagingDB, "err", err)
+		}
+	}
+
+	fmt.Fprintf(importOut, "Done! %d CPE lines, %d words in %s. DB size: %d\n", stats.items, stats.words, stats.elapsed, finalSize)
+	if opts.asJSON {
+		summary := newImportSummary(stats, "")
+		summary.Keys = finalSize
+		summary.print()
+	}
+}
+
+// restoreFile loads the index dump d into the index file of c, or with swap
+// into a new file renamed over it once complete.
+func restoreFile(ctx context.Context, c *config.Config, d *dumpReader, prog *progressReporter, opts restoreOptions) {
+	path := c.GetStoragePath()
+	target := path
+	if opts.swap {
+		target = path + ".tmp"
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Failed to remove stale %s: %v", target, err)
+		}
+		fmt.Fprintf(importOut, "Building index in %s...\n", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		log.Fatalf("Failed to create index directory: %v", err)
+	}
+	store, err := openFileStore(c, target, false)
+	if err != nil {
+		log.Fatalf("Failed to open index file %s: %v", target, err)
+	}
+	size, err := store.Len()
+	if err != nil {
+		log.Fatalf("Failed to read index file %s: %v", target, err)
+	}
+	if size > 0 && !opts.replace && !opts.swap {
+		log.Fatalf("Warning: %s contains %d CPEs. Use --replace or --swap.", target, size)
+	}
+	if size > 0 && !opts.swap {
+		fmt.Fprintf(importOut, "Emptying %s...\n", target)
+		if err := store.Reset(); err != nil {
+			log.Fatalf("Failed to empty index file: %v", err)
+		}
+	}
+
+	// Index files take one writer at a time
+	stats, err := d.restore(ctx, store.NewBatch, 1, c.GetBatchSize(), prog)
+	if err != nil {
+		log.Fatalf("Restore failed: %v", err)
+	}
+	recordImport(ctx, store, d.lastImport())
+	if err := store.Close(); err != nil {
+		log.Fatalf("Failed to close index file: %v", err)
+	}
+	if opts.swap {
+		if err := os.Rename(target, path); err != nil {
+			log.Fatalf("Failed to move %s to %s: %v", target, path, err)
+		}
+		fmt.Fprintf(importOut, "Moved the new index to %s, a running server switches to it within %s\n", path, indexCheckInterval)
+	}
+
+	var fileSize int64
+	if info, err := os.Stat(path); err == nil {
+		fileSize = info.Size()
+	}
+	fmt.Fprintf(importOut, "Done! %d CPE lines, %d words in %s. Index file: %s (%s)\n",
+		stats.items, stats.words, stats.elapsed, path, formatBytes(fileSize))
+	if opts.asJSON {
+		summary := newImportSummary(stats, "")
+		summary.IndexFile, summary.IndexBytes = path, fileSize
+		summary.print()
+	}
+}
+
+// runRestore loads an index dump written by export into the index of the
+// configured storage backend.
+func runRestore(fs *flag.FlagSet) func() {
+	in := fs.String("in", "", "Index dump to restore (required)")
+	replace := fs.Bool("replace", false, "Empty the index before restoring the dump")
+	swap := fs.Bool("swap", false, "Restore into the staging DB or a new index file and swap it in when complete")
+	quiet := fs.Bool("quiet", false, "Don't print the progress of the restore")
+	asJSON := fs.Bool("json", false, "Print a JSON summary on stdout, and the other messages on stderr")
+	redisHost := fs.String("redis", "", "Redis host:port (overrides config)")
+	configPath := fs.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
+
+	return func() {
+		if *in == "" {
+			log.Fatal("Please specify the dump file with -in")
+		}
+		if *asJSON {
+			importOut = os.Stderr
+		}
+
+		cfg = loadConfig(*configPath)
+		if cfg.Storage.Backend == config.BackendMemory {
+			log.Fatal("The memory backend builds its index at startup and has nothing to restore into")
+		}
+
+		f, err := os.Open(*in)
+		if err != nil {
+			log.Fatalf("Failed to open the index dump: %v", err)
+		}
+		defer f.Close()
+		var input inputProgress
+		if info, err := f.Stat(); err == nil {
+			input.total.Store(info.Size())
+		}
+		d, err := openDump(&countingFile{File: f, p: &input})
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", *in, err)
+		}
+
+		opts := restoreOptions{replace: *replace, swap: *swap, input: &input, asJSON: *asJSON}
+		if !*quiet {
+			opts.progress = importOut
+		}
+		restoreIndex(ctx, cfg, *redisHost, d, opts)
+	}
+}
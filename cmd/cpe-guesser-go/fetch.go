@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// fetcher opens the object a CPE source URL points to for reading.
+type fetcher func(ctx context.Context, u *url.URL) (io.ReadCloser, error)
+
+// fetchers maps the source URL schemes to the fetcher downloading them.
+var fetchers = map[string]fetcher{
+	"http":  fetchHTTP,
+	"https": fetchHTTP,
+	"file":  fetchFile,
+	"s3":    fetchS3,
+	"gs":    fetchGCS,
+}
+
+// openSource opens the CPE dictionary at source with the fetcher of its
+// scheme. Credentials for object storage come from the environment.
+func openSource(ctx context.Context, source string) (io.ReadCloser, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CPE source %q: %w", source, err)
+	}
+	fetch, ok := fetchers[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported CPE source scheme %q", u.Scheme)
+	}
+	return fetch(ctx, u)
+}
+
+// isTimeout reports whether err is a download timeout.
+func isTimeout(err error) bool {
+	return os.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func fetchHTTP(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	return httpGet(ctx, newDownloadClient(), u.String())
+}
+
+// httpGet fetches rawURL with client, failing on any status but 200 OK.
+func httpGet(ctx context.Context, client *http.Client, rawURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return &sizedBody{ReadCloser: resp.Body, size: resp.ContentLength}, nil
+}
+
+// sizedBody is a download whose size is known, -1 when it isn't.
+type sizedBody struct {
+	io.ReadCloser
+	size int64
+}
+
+// downloadSize returns the size of the download body, -1 when unknown.
+func downloadSize(body io.ReadCloser) int64 {
+	switch b := body.(type) {
+	case *sizedBody:
+		return b.size
+	case *os.File:
+		if fi, err := b.Stat(); err == nil {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+func fetchFile(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	return os.Open(u.Path)
+}
+
+// fetchS3 downloads s3://bucket/key using the AWS credentials, region and
+// profile from the environment.
+func fetchS3(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	out, err := s3.NewFromConfig(awsCfg).GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	size := int64(-1)
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	return &sizedBody{ReadCloser: out.Body, size: size}, nil
+}
+
+// fetchGCS downloads gs://bucket/object through the Cloud Storage JSON API
+// using the application default credentials.
+func fetchGCS(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	// Token requests go through the download client too
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, newDownloadClient())
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_only")
+	if err != nil {
+		return nil, fmt.Errorf("loading Google credentials: %w", err)
+	}
+	object := strings.TrimPrefix(u.Path, "/")
+	return httpGet(ctx, client, fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media",
+		url.PathEscape(u.Host), url.PathEscape(object)))
+}
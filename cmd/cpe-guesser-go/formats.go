@@ -0,0 +1,250 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// errUnknownFormat is returned by detectSource for input that is none of the
+// dictionary formats.
+var errUnknownFormat = errors.New("unknown dictionary format")
+
+// openDictionary returns the entry source of the dictionary file f. The
+// format is detected from the first bytes: the official XML dictionary, an
+// NVD JSON feed holding products as returned by the Products API, a zip or
+// tar archive of such files, or any of them gzip-compressed.
+func openDictionary(f *countingFile, readBuffer int) (entrySource, error) {
+	br := bufio.NewReaderSize(f, readBuffer)
+	head, err := br.Peek(len(zipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(head, zipMagic) {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		return newZipSource(zr, readBuffer), nil
+	}
+	src, err := detectSource(br, readBuffer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	return src, nil
+}
+
+// detectSource returns the entry source of the stream br, which may be
+// gzip-compressed or a tar archive.
+func detectSource(br *bufio.Reader, readBuffer int) (entrySource, error) {
+	// A tar header is 512 bytes, with its magic at 257
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.HasPrefix(head, gzipMagic) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return detectSource(bufio.NewReaderSize(gr, readBuffer), readBuffer)
+	}
+	if len(head) >= 262 && string(head[257:262]) == "ustar" {
+		return newTarSource(tar.NewReader(br), readBuffer), nil
+	}
+
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	switch {
+	case bytes.HasPrefix(head, []byte("<")):
+		return newXMLSource(br, readBuffer), nil
+	case bytes.HasPrefix(head, []byte("{")):
+		return newJSONSource(br), nil
+	}
+	return nil, errUnknownFormat
+}
+
+// jsonSource reads the products of an NVD JSON feed, which has the shape of a
+// Products API response. The products array is decoded one entry at a time.
+type jsonSource struct {
+	dec     *json.Decoder
+	started bool
+	done    bool
+	read    int
+}
+
+func newJSONSource(r io.Reader) *jsonSource {
+	return &jsonSource{dec: json.NewDecoder(r)}
+}
+
+func (s *jsonSource) next() (*cpeEntry, error) {
+	if !s.started {
+		s.started = true
+		if err := s.seekProducts(); err != nil {
+			return nil, err
+		}
+	}
+	if s.done || !s.dec.More() {
+		s.done = true
+		return nil, io.EOF
+	}
+	var product struct {
+		CPE nvdCPE `json:"cpe"`
+	}
+	s.read++
+	if err := s.dec.Decode(&product); err != nil {
+		// The decoder skips a value of the wrong type but can't resume
+		// after a syntax error
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, &entryError{err: err}
+		}
+		return nil, fmt.Errorf("JSON decode error: %w", err)
+	}
+	if product.CPE.CPEName == "" {
+		return nil, &entryError{err: errors.New("product without cpeName")}
+	}
+	return product.CPE.entry(), nil
+}
+
+// seekProducts moves the decoder into the top-level products array. A feed
+// without one has no entries.
+func (s *jsonSource) seekProducts() error {
+	if tok, err := s.dec.Token(); err != nil {
+		return fmt.Errorf("JSON parse error: %w", err)
+	} else if tok != json.Delim('{') {
+		return errors.New("JSON feed is not an object")
+	}
+	for s.dec.More() {
+		key, err := s.dec.Token()
+		if err != nil {
+			return fmt.Errorf("JSON parse error: %w", err)
+		}
+		if key == "products" {
+			if tok, err := s.dec.Token(); err != nil {
+				return fmt.Errorf("JSON parse error: %w", err)
+			} else if tok != json.Delim('[') {
+				return errors.New("JSON feed products is not an array")
+			}
+			return nil
+		}
+		var skip json.RawMessage
+		if err := s.dec.Decode(&skip); err != nil {
+			return fmt.Errorf("JSON parse error: %w", err)
+		}
+	}
+	s.done = true
+	return nil
+}
+
+func (s *jsonSource) pos() string {
+	return fmt.Sprintf("product %d", s.read)
+}
+
+// archiveSource reads the dictionary files of an archive one after the
+// other. Members in no dictionary format are skipped with a warning.
+type archiveSource struct {
+	// member opens the next dictionary file, returning io.EOF after the
+	// last one
+	member     func() (name string, r io.ReadCloser, err error)
+	readBuffer int
+
+	name string
+	cur  entrySource
+	body io.ReadCloser
+}
+
+func (s *archiveSource) next() (*cpeEntry, error) {
+	for {
+		if s.cur == nil {
+			name, body, err := s.member()
+			if err != nil {
+				return nil, err
+			}
+			src, err := detectSource(bufio.NewReaderSize(body, s.readBuffer), s.readBuffer)
+			if errors.Is(err, errUnknownFormat) {
+				slog.Warn("Skipping entry", "name", name, "err", err)
+				body.Close()
+				continue
+			}
+			if err != nil {
+				body.Close()
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			s.name, s.cur, s.body = name, src, body
+		}
+		e, err := s.cur.next()
+		if err == io.EOF {
+			s.body.Close()
+			s.cur = nil
+			continue
+		}
+		return e, err
+	}
+}
+
+func (s *archiveSource) pos() string {
+	if s.cur == nil {
+		return s.name
+	}
+	return s.name + " " + s.cur.pos()
+}
+
+// newZipSource reads the files of zr in name order, which keeps the chunks
+// of a split feed in sequence.
+func newZipSource(zr *zip.Reader, readBuffer int) *archiveSource {
+	var files []*zip.File
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return &archiveSource{
+		readBuffer: readBuffer,
+		member: func() (string, io.ReadCloser, error) {
+			if len(files) == 0 {
+				return "", nil, io.EOF
+			}
+			f := files[0]
+			files = files[1:]
+			r, err := f.Open()
+			return f.Name, r, err
+		},
+	}
+}
+
+// newTarSource reads the regular files of tr in archive order.
+func newTarSource(tr *tar.Reader, readBuffer int) *archiveSource {
+	return &archiveSource{
+		readBuffer: readBuffer,
+		member: func() (string, io.ReadCloser, error) {
+			for {
+				hdr, err := tr.Next()
+				if err != nil {
+					return "", nil, err
+				}
+				if hdr.Typeflag == tar.TypeReg {
+					return hdr.Name, io.NopCloser(tr), nil
+				}
+			}
+		},
+	}
+}
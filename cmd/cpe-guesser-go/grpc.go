@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+	"github.com/aringo/cpe-guesser-go/pkg/guesserpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer serves the gRPC API from the current server state, with the
+// same configuration defaults as the HTTP endpoints.
+type grpcServer struct {
+	guesserpb.UnimplementedGuesserServer
+}
+
+func (grpcServer) Search(ctx context.Context, req *guesserpb.SearchRequest) (*guesserpb.SearchResponse, error) {
+	st := state.Load()
+
+	strategy, err := guesser.ParseStrategy(req.Strategy)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	binding, err := guesser.ParseBinding(req.Binding)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.Part != "" && req.Part != "a" && req.Part != "o" && req.Part != "h" {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown CPE part %q", req.Part)
+	}
+	st.recordQuery(req.Query)
+
+	fuzzy := 0
+	if st.cfg.Server.Fuzzy {
+		fuzzy = st.cfg.GetFuzzyDistance()
+	}
+
+	start := time.Now()
+	res, path, err := st.gs.Search(ctx, req.Query, guesser.SearchOptions{
+		Strategy:       strategy,
+		Anchored:       req.Anchored,
+		DisablePartial: st.cfg.Server.DisablePartial,
+		Fuzzy:          fuzzy,
+		Budget:         st.cfg.Server.TimeBudget,
+		Part:           req.Part,
+	})
+	partialResults := errors.Is(err, guesser.ErrPartialResults)
+	if partialResults {
+		err = nil
+	}
+	if errors.Is(err, guesser.ErrTooManyWords) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	partialSkipped := st.cfg.Server.DisablePartial && strategy != guesser.ExactOnly && len(res) == 0
+
+	minRank := st.cfg.Server.MinRank
+	if req.MinRank != nil {
+		minRank = *req.MinRank
+	}
+	res = guesser.FilterMinRank(res, minRank)
+	if st.cfg.Server.ExcludeDeprecated {
+		if res, err = st.gs.ExcludeDeprecated(ctx, res); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	switch st.cfg.Server.Scoring {
+	case "coverage":
+		guesser.ScoreByCoverage(res, st.cfg.Server.CoverageWeight)
+	case "idf":
+		if err := st.gs.ScoreByIDF(ctx, res, req.Query, nil); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	guesser.SortByPartPriority(res, st.cfg.Server.PartPriority)
+	if req.Titles || st.cfg.CPE.IndexTitles {
+		if err := st.gs.Titles(ctx, res); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if req.References {
+		if err := st.gs.References(ctx, res); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if err := guesser.RebindResults(res, binding); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	st.logSlowQuery(start, "grpc Search", req.Query, path, len(res))
+
+	out := &guesserpb.SearchResponse{
+		Results:        make([]*guesserpb.Result, len(res)),
+		PartialResults: partialResults,
+		PartialSkipped: partialSkipped,
+	}
+	for i, r := range res {
+		out.Results[i] = &guesserpb.Result{Rank: r.Rank, Cpe: r.CPE, Title: r.Title, References: r.References}
+	}
+	return out, nil
+}
+
+func (grpcServer) Unique(ctx context.Context, req *guesserpb.UniqueRequest) (*guesserpb.UniqueResponse, error) {
+	return uniqueGRPC(ctx, state.Load(), req)
+}
+
+func (grpcServer) UniqueStream(stream guesserpb.Guesser_UniqueStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res, err := uniqueGRPC(stream.Context(), state.Load(), req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+func (grpcServer) Health(ctx context.Context, req *guesserpb.HealthRequest) (*guesserpb.HealthResponse, error) {
+	if err := state.Load().ping(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &guesserpb.HealthResponse{
+		Status:    "healthy",
+		Version:   version,
+		Commit:    commit,
+		BuildDate: date,
+		GoVersion: runtime.Version(),
+		Uptime:    time.Since(startTime).Round(time.Second).String(),
+	}, nil
+}
+
+// uniqueGRPC looks up the best CPE of one Unique request, an empty one when
+// nothing matches.
+func uniqueGRPC(ctx context.Context, st *serverState, req *guesserpb.UniqueRequest) (*guesserpb.UniqueResponse, error) {
+	st.recordQuery(req.Query)
+
+	start := time.Now()
+	cpe, err := st.gs.Unique(ctx, req.Query)
+	count := 0
+	if cpe != "" {
+		count = 1
+	}
+	st.logSlowQuery(start, "grpc Unique", req.Query, "exact_then_partial", count)
+	if errors.Is(err, guesser.ErrTooManyWords) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &guesserpb.UniqueResponse{Cpe: cpe}, nil
+}
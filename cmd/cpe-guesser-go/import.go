@@ -1,206 +1,1357 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aringo/cpe-guesser-go/internal/config"
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
 	"github.com/go-redis/redis/v8"
 )
 
-// XMLEntry maps only the cpe23-item element's name attribute
+// partSet is the set of CPE parts given with repeated -only-part flags.
+type partSet map[string]bool
+
+func (p partSet) String() string {
+	parts := make([]string, 0, len(p))
+	for part := range p {
+		parts = append(parts, part)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (p partSet) Set(val string) error {
+	switch val {
+	case "a", "o", "h":
+		p[val] = true
+		return nil
+	}
+	return fmt.Errorf("unknown CPE part %q, use a, o or h", val)
+}
+
+// maxErrorSamples is the number of entry errors quoted in the import summary.
+const maxErrorSamples = 10
+
+// entryErrors aggregates errors in individual dictionary entries so that one
+// bad entry doesn't abort the whole import.
+type entryErrors struct {
+	count   int
+	samples []string
+}
+
+func (e *entryErrors) add(format string, args ...interface{}) {
+	e.count++
+	if len(e.samples) < maxErrorSamples {
+		e.samples = append(e.samples, fmt.Sprintf(format, args...))
+	}
+}
+
+// XMLEntry maps the parts of a cpe-item element the import uses
 type XMLEntry struct {
-	Name string `xml:"name,attr"`
+	Deprecated bool       `xml:"deprecated,attr"`
+	Titles     []XMLTitle `xml:"title"`
+	References []struct {
+		Href string `xml:"href,attr"`
+	} `xml:"references>reference"`
+	Item struct {
+		Name         string `xml:"name,attr"`
+		DeprecatedBy []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"deprecation>deprecated-by"`
+	} `xml:"cpe23-item"`
+	Meta struct {
+		Modified string `xml:"modification-date,attr"`
+	} `xml:"item-metadata"`
+}
+
+// XMLTitle is a human-readable title of a cpe-item in one language
+type XMLTitle struct {
+	Lang string `xml:"lang,attr"`
+	Text string `xml:",chardata"`
+}
+
+// entry returns the parts of e the import uses.
+func (e *XMLEntry) entry() *cpeEntry {
+	ce := &cpeEntry{
+		name:       e.Item.Name,
+		title:      englishTitle(e.Titles),
+		modified:   parseNVDTime(e.Meta.Modified),
+		deprecated: e.Deprecated,
+	}
+	for _, ref := range e.References {
+		ce.refs = append(ce.refs, ref.Href)
+	}
+	for _, by := range e.Item.DeprecatedBy {
+		ce.deprecatedBy = append(ce.deprecatedBy, by.Name)
+	}
+	return ce
+}
+
+// englishTitle returns the English title among titles, preferring en-US.
+func englishTitle(titles []XMLTitle) string {
+	best := ""
+	for _, t := range titles {
+		switch {
+		case t.Lang == "en-US":
+			return strings.TrimSpace(t.Text)
+		case best == "" && strings.HasPrefix(t.Lang, "en"):
+			best = strings.TrimSpace(t.Text)
+		}
+	}
+	if best == "" && len(titles) > 0 {
+		best = strings.TrimSpace(titles[0].Text)
+	}
+	return best
 }
 
+// Rank policies for CPE lines produced by several dictionary entries.
 const (
-	batchSize = 5000
+	// rankEntries ranks a CPE line by the number of entries it came from.
+	rankEntries = "entries"
+	// rankOnce counts every CPE line once, however many entries produced it.
+	rankOnce = "once"
 )
 
-func runImport() {
+func runImport(fs *flag.FlagSet) func() {
 	// Define command line flags
-	down := flag.Bool("download", false, "Download CPE data even if file exists")
-	replace := flag.Bool("replace", false, "Flush and repopulate the CPE database")
-	update := flag.Bool("update", false, "Update the CPE database without flushing")
-	redisHost := flag.String("redis", "", "Redis host:port (overrides config)")
-	configPath := flag.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
-
-	// Parse flags
-	flag.Parse()
-
-	// Load config based on flag
-	var err error
-	cfg, err = config.Load(*configPath)
+	down := fs.Bool("download", false, "Download CPE data even if file exists")
+	replace := fs.Bool("replace", false, "Flush and repopulate the CPE database")
+	update := fs.Bool("update", false, "Update the CPE database without flushing")
+	rankPolicy := fs.String("rank-policy", rankEntries, "How duplicate CPE lines are ranked: entries (count each dictionary entry) or once")
+	bufferSize := fs.Int("buffer-size", 0, "Read buffer size in bytes for parsing the CPE file (overrides config)")
+	workers := fs.Int("workers", 0, "Number of batches written to Redis concurrently (overrides config)")
+	batchSize := fs.Int("batch-size", 0, "Number of entries written per batch (overrides config)")
+	onlyParts := partSet{}
+	fs.Var(onlyParts, "only-part", "Only index CPEs of this part: a, o or h (repeatable)")
+	strict := fs.Bool("strict", false, "Abort on the first invalid dictionary entry")
+	incremental := fs.Bool("incremental", false, "Only apply the entries modified since the last import")
+	swap := fs.Bool("swap", false, "Build the CPE database in the staging DB and swap it in when complete")
+	resume := fs.Bool("resume", false, "Continue an interrupted import where it stopped (repeat its other flags)")
+	stream := fs.Bool("stream", false, "Import the dictionary download as it arrives, without storing it first")
+	tee := fs.Bool("tee", false, "With --stream, also write the downloaded dictionary to the CPE file")
+	quiet := fs.Bool("quiet", false, "Don't print the progress of the import")
+	asJSON := fs.Bool("json", false, "Print a JSON summary on stdout, and the other messages on stderr")
+	redisHost := fs.String("redis", "", "Redis host:port (overrides config)")
+	configPath := fs.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
+
+	return func() {
+		if *rankPolicy != rankEntries && *rankPolicy != rankOnce {
+			log.Fatalf("Unknown rank policy %q, use %s or %s", *rankPolicy, rankEntries, rankOnce)
+		}
+		if *swap && *update {
+			log.Fatal("--swap builds a fresh index and cannot be combined with --update")
+		}
+		if *incremental && (*swap || *replace) {
+			log.Fatal("--incremental updates the existing index and cannot be combined with --swap or --replace")
+		}
+
+		if *resume && (*down || *stream) {
+			log.Fatal("--resume continues with the CPE file of the interrupted import and cannot be combined with --download or --stream")
+		}
+		if *tee && !*stream {
+			log.Fatal("--tee only applies to --stream")
+		}
+
+		if *asJSON {
+			importOut = os.Stderr
+		}
+
+		// Load config based on flag
+		cfg = loadConfig(*configPath)
+		if *resume && cfg.NVD.Enabled {
+			log.Fatal("--resume needs the CPE file; the NVD API may answer differently on every run")
+		}
+		if *stream && cfg.NVD.Enabled {
+			log.Fatal("--stream reads the dictionary download and cannot be combined with nvd.enabled")
+		}
+		// A published index dump takes the place of the dictionary
+		if cfg.CPE.IndexSnapshot != "" {
+			if *update || *incremental || *resume || *stream {
+				log.Fatal("cpe.index_snapshot loads a whole index and cannot be combined with --update, --incremental, --resume or --stream")
+			}
+			ctx := context.Background()
+			d, input, release := fetchIndexDump(ctx, cfg)
+			defer release()
+			ropts := restoreOptions{replace: *replace, swap: *swap, input: input, asJSON: *asJSON}
+			if !*quiet {
+				ropts.progress = importOut
+			}
+			restoreIndex(ctx, cfg, *redisHost, d, ropts)
+			return
+		}
+		readBuffer := cfg.GetReadBuffer()
+		if *bufferSize > 0 {
+			readBuffer = *bufferSize
+		}
+		opts := populateOptions{
+			rankPolicy: *rankPolicy,
+			onlyParts:  onlyParts,
+			strict:     *strict,
+			references: cfg.CPE.IndexReferences,
+			versions:   cfg.CPE.IndexVersions,
+			titles:     cfg.CPE.IndexTitles,
+			tokenizer:  configTokenizer(cfg),
+			stopwords:  configStopwords(cfg),
+			cveWeight:  cfg.GetCVEWeight(),
+			batchSize:  cfg.GetBatchSize(),
+			workers:    cfg.GetImportWorkers(),
+		}
+		if !*quiet {
+			opts.progress = importOut
+		}
+		if *batchSize > 0 {
+			opts.batchSize = *batchSize
+		}
+		if *workers > 0 {
+			opts.workers = *workers
+		}
+		ctx := context.Background()
+		if len(cfg.CPE.CVEFeeds) > 0 {
+			opts.cveCounts = loadCVEFeeds(ctx, cfg)
+		}
+		if cfg.Storage.Backend == config.BackendBolt || cfg.Storage.Backend == config.BackendSQLite {
+			// Index files take one writer at a time
+			opts.workers = 1
+			opts.update = *update || *incremental
+			importFile(ctx, cfg, opts, importOptions{
+				readBuffer:  readBuffer,
+				download:    *down,
+				stream:      *stream,
+				tee:         *tee,
+				replace:     *replace,
+				update:      opts.update,
+				swap:        *swap,
+				incremental: *incremental,
+				resume:      *resume,
+				asJSON:      *asJSON,
+			})
+			return
+		}
+
+		indexDB, stagingDB := cfg.GetIndexDB(), cfg.GetStagingDB()
+		if *swap && cfg.Valkey.Cluster {
+			log.Fatal("--swap needs SWAPDB, which a Valkey cluster does not support")
+		}
+		if *swap && stagingDB == indexDB {
+			log.Fatalf("Staging DB must differ from the index DB %d", indexDB)
+		}
+
+		// Use command line flags if provided, otherwise use config
+		redisAddr := cfg.GetRedisAddr()
+		if *redisHost != "" {
+			redisAddr = *redisHost
+		}
+
+		// Initialize Redis client
+		rdb := newRedisClient(cfg, redisAddr, indexDB)
+
+		// Verify Redis connection
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+
+		// Check existing keys
+		dbSize, err := indexSize(ctx, rdb)
+		if err != nil {
+			log.Fatalf("Redis DBSize error: %v", err)
+		}
+		if dbSize > 0 && !*replace && !*update && !*swap && !*incremental && !*resume {
+			log.Fatalf("Warning: Redis contains %d keys. Use --replace or --update.", dbSize)
+		}
+		if *incremental {
+			store := guesser.NewRedisStore(rdb)
+			opts.since, opts.ranks = lastImport(ctx, store), store.Ranks
+		}
+		opts.update = *update || *incremental
+
+		started := time.Now()
+		src, closeSrc := openEntries(ctx, cfg, *down, *stream, *tee, readBuffer, opts.since)
+		defer closeSrc()
+
+		// Populate the staging DB instead, leaving the served index untouched
+		serving := rdb
+		if *swap {
+			rdb = newRedisClient(cfg, redisAddr, stagingDB)
+			fmt.Fprintf(importOut, "Building index in staging DB %d...\n", stagingDB)
+			if !*resume {
+				if err := rdb.FlushDB(ctx).Err(); err != nil {
+					log.Fatalf("Failed to flush staging database: %v", err)
+				}
+			}
+		}
+
+		// Flush if replace
+		if dbSize > 0 && *replace && !*swap && !*resume {
+			fmt.Fprintf(importOut, "Flushing %d keys...\n", dbSize)
+			if err := flushIndex(ctx, rdb); err != nil {
+				log.Fatalf("Failed to flush database: %v", err)
+			}
+		}
+
+		memBefore, err := usedMemory(ctx, rdb)
+		if err != nil {
+			slog.Warn("Could not read Redis memory usage", "err", err)
+		}
+
+		// Record the progress, or pick up that of the interrupted import
+		store := guesser.NewRedisStore(rdb)
+		run := describeRun(cfg, importMode(*replace, *update, *swap, *incremental), *stream, opts)
+		if *resume {
+			opts.done, started = resumeCheckpoint(ctx, store, run)
+		} else {
+			startCheckpoint(ctx, store, run, started)
+		}
+		opts.checkpoint = true
+
+		// Parse and populate
+		fmt.Fprintf(importOut, "Populating the database with %d workers (this may take a while)...\n", opts.workers)
+		stats, err := populate(ctx, src, store.NewBatch, opts)
+		if err != nil {
+			importFailed(err, *stream)
+		}
+		recordImport(ctx, store, started)
+		// Every word got its trigrams unless only changed entries were read
+		if !*incremental {
+			if err := rdb.Set(ctx, guesser.KeyPrefix(rdb)+guesser.TrigramsKey, "1", 0).Err(); err != nil {
+				slog.Warn("Could not enable the trigram index", "err", err)
+			}
+		}
+		itemCount, wordCount := stats.items, stats.words
+
+		elapsed := stats.elapsed
+		finalSize, err := indexSize(ctx, rdb)
+		if err != nil {
+			slog.Warn("Could not get final DB size", "err", err)
+			finalSize = 0
+		}
+
+		// Measure the new index before a swap moves it
+		memAfter, memErr := usedMemory(ctx, rdb)
+		if memErr != nil {
+			slog.Warn("Could not read Redis memory usage", "err", memErr)
+		}
+		estimate, sampled, estErr := estimateIndexMemory(ctx, rdb, finalSize, memorySamples)
+		if estErr != nil {
+			slog.Warn("Could not estimate index memory", "err", estErr)
+		}
+
+		// Swap the new index in and drop the old one, now in the staging DB
+		if *swap {
+			if err := serving.Do(ctx, "SWAPDB", indexDB, stagingDB).Err(); err != nil {
+				log.Fatalf("Failed to swap staging DB %d into DB %d: %v", stagingDB, indexDB, err)
+			}
+			fmt.Fprintf(importOut, "Swapped staging DB %d into DB %d\n", stagingDB, indexDB)
+			if err := rdb.FlushDB(ctx).Err(); err != nil {
+				slog.Warn("Could not flush old index from staging DB", "db", stagingDB, "err", err)
+			}
+		}
+
+		fmt.Fprintf(importOut, "Done! %d items, %d words in %s. DB size: %d\n", itemCount, wordCount, elapsed, finalSize)
+		if estErr == nil && sampled > 0 {
+			fmt.Fprintf(importOut, "Estimated index memory: %s (%d of %d keys sampled)\n", formatBytes(estimate), sampled, finalSize)
+		}
+		if memErr == nil && memBefore > 0 {
+			fmt.Fprintf(importOut, "Redis memory grew by %s during the import (%s used)\n", formatBytes(memAfter-memBefore), formatBytes(memAfter))
+		}
+		printImportStats(stats, *rankPolicy)
+		if *asJSON {
+			summary := newImportSummary(stats, *rankPolicy)
+			summary.Keys = finalSize
+			if estErr == nil && sampled > 0 {
+				summary.MemoryEstimateBytes = estimate
+			}
+			summary.print()
+		}
+	}
+}
+
+// importOptions are the import flags importFile applies.
+type importOptions struct {
+	// readBuffer is the read buffer size of the CPE file
+	readBuffer int
+	// download, stream and tee select how the dictionary is read, see
+	// openEntries
+	download, stream, tee bool
+	// replace empties the index first, update adds to it and swap builds a
+	// new one to rename over it
+	replace, update, swap bool
+	// incremental only applies the entries modified since the last import
+	incremental bool
+	// resume continues an interrupted import from its checkpoint
+	resume bool
+	// asJSON also prints the summary as JSON
+	asJSON bool
+}
+
+// importFile populates the index file of the bolt or sqlite storage backend
+// as flags say. With swap it builds a new file next to it and renames it over the old one,
+// so a running server keeps its open copy until restarted.
+func importFile(ctx context.Context, cfg *config.Config, opts populateOptions, flags importOptions) {
+	path := cfg.GetStoragePath()
+	target := path
+	if flags.swap && flags.resume {
+		target = path + ".tmp"
+		fmt.Fprintf(importOut, "Building index in %s...\n", target)
+	} else if flags.swap {
+		target = path + ".tmp"
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Failed to remove stale %s: %v", target, err)
+		}
+		fmt.Fprintf(importOut, "Building index in %s...\n", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		log.Fatalf("Failed to create index directory: %v", err)
+	}
+	store, err := openFileStore(cfg, target, false)
+	if err != nil {
+		log.Fatalf("Failed to open index file %s: %v", target, err)
+	}
+
+	size, err := store.Len()
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		log.Fatalf("Failed to read index file %s: %v", target, err)
 	}
+	if size > 0 && !flags.replace && !flags.update && !flags.swap && !flags.resume {
+		log.Fatalf("Warning: %s contains %d CPEs. Use --replace or --update.", target, size)
+	}
+	if flags.incremental {
+		opts.since, opts.ranks = lastImport(ctx, store), store.Ranks
+	}
+
+	// Fetch the dictionary before emptying the index, which a failed
+	// download then leaves as it was
+	started := time.Now()
+	src, closeSrc := openEntries(ctx, cfg, flags.download, flags.stream, flags.tee, flags.readBuffer, opts.since)
+	defer closeSrc()
 
-	// Use command line flags if provided, otherwise use config
-	redisAddr := cfg.GetRedisAddr()
-	if *redisHost != "" {
-		redisAddr = *redisHost
+	if size > 0 && flags.replace && !flags.swap && !flags.resume {
+		fmt.Fprintf(importOut, "Emptying %s...\n", target)
+		if err := store.Reset(); err != nil {
+			log.Fatalf("Failed to empty index file: %v", err)
+		}
+	}
+	run := describeRun(cfg, importMode(flags.replace, flags.update, flags.swap, flags.incremental), flags.stream, opts)
+	if flags.resume {
+		opts.done, started = resumeCheckpoint(ctx, store, run)
+	} else {
+		startCheckpoint(ctx, store, run, started)
+	}
+	opts.checkpoint = true
+	fmt.Fprintln(importOut, "Populating the index file (this may take a while)...")
+	stats, err := populate(ctx, src, store.NewBatch, opts)
+	if err != nil {
+		importFailed(err, flags.stream)
+	}
+	recordImport(ctx, store, started)
+	if err := store.Close(); err != nil {
+		log.Fatalf("Failed to close index file: %v", err)
+	}
+	if flags.swap {
+		if err := os.Rename(target, path); err != nil {
+			log.Fatalf("Failed to move %s to %s: %v", target, path, err)
+		}
+		fmt.Fprintf(importOut, "Moved the new index to %s, a running server switches to it within %s\n", path, indexCheckInterval)
 	}
 
-	// Initialize Redis client
-	ctx := context.Background()
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		DB:       8,
-		PoolSize: 20,
-	})
+	var fileSize int64
+	if info, err := os.Stat(path); err == nil {
+		fileSize = info.Size()
+	}
+	fmt.Fprintf(importOut, "Done! %d items, %d words in %s. Index file: %s (%s)\n",
+		stats.items, stats.words, stats.elapsed, path, formatBytes(fileSize))
+	printImportStats(stats, opts.rankPolicy)
+	if flags.asJSON {
+		summary := newImportSummary(stats, opts.rankPolicy)
+		summary.IndexFile, summary.IndexBytes = path, fileSize
+		summary.print()
+	}
+}
 
-	// Verify Redis connection
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+// importFailed exits on the error of a failed import. Only an import of the
+// CPE file can be resumed.
+func importFailed(err error, stream bool) {
+	if stream {
+		log.Fatalf("Import failed: %v", err)
 	}
+	log.Fatalf("Import failed: %v\nRun the import again with --resume to continue where it stopped", err)
+}
 
-	// Check existing keys
-	dbSize, err := rdb.DBSize(ctx).Result()
+// lastImport returns the start time of the last import into store, for an
+// incremental import.
+func lastImport(ctx context.Context, store guesser.Store) time.Time {
+	since, err := store.LastImport(ctx)
 	if err != nil {
-		log.Fatalf("Redis DBSize error: %v", err)
+		log.Fatalf("Failed to read the last import time: %v", err)
 	}
-	if dbSize > 0 && !*replace && !*update {
-		log.Fatalf("Warning: Redis contains %d keys. Use --replace or --update.", dbSize)
+	if since.IsZero() {
+		log.Fatal("No previous import recorded in the index; run a full import first")
 	}
+	fmt.Fprintf(importOut, "Applying the entries modified since %s\n", since.Format(time.RFC3339))
+	return since
+}
 
-	// Download if requested or missing
-	cpePath := cfg.GetCPEPath()
-	if *down || !fileExists(cpePath) {
-		fmt.Printf("Downloading CPE data from %s ...\n", cfg.CPE.Source)
-		eresp, err := http.Get(cfg.CPE.Source)
+// recordImport stores the start time of a completed import, which the next
+// incremental import starts from, and drops its checkpoint.
+func recordImport(ctx context.Context, store guesser.Store, started time.Time) {
+	batch := store.NewBatch()
+	batch.SetLastImport(started)
+	batch.ClearCheckpoint()
+	if err := batch.Exec(ctx); err != nil {
+		slog.Warn("Could not record the import time", "err", err)
+	}
+}
+
+// printImportStats prints the part of the import summary shared by all
+// storage backends.
+func printImportStats(stats *importStats, rankPolicy string) {
+	if stats.resumed > 0 {
+		fmt.Fprintf(importOut, "Skipped %d batches written before the interruption\n", stats.resumed)
+	}
+	if stats.skippedParts > 0 {
+		fmt.Fprintf(importOut, "Skipped %d entries not matching -only-part\n", stats.skippedParts)
+	}
+	if stats.deprecated > 0 {
+		fmt.Fprintf(importOut, "Marked %d CPE lines deprecated, all of their entries being deprecated\n", stats.deprecated)
+	}
+	if stats.cveLines > 0 {
+		fmt.Fprintf(importOut, "Raised the rank of %d CPE lines by their CVEs\n", stats.cveLines)
+	}
+	if stats.unchanged > 0 {
+		fmt.Fprintf(importOut, "Skipped %d entries unchanged since the last import\n", stats.unchanged)
+	}
+	if stats.errs.count > 0 {
+		fmt.Fprintf(importOut, "Skipped %d invalid entries, including:\n", stats.errs.count)
+		for _, sample := range stats.errs.samples {
+			fmt.Fprintf(importOut, "  %s\n", sample)
+		}
+	}
+	if stats.items > 0 {
+		fmt.Fprintf(importOut, "%d distinct CPE lines, %d duplicate entries (%.1f entries per line, rank policy: %s)\n",
+			stats.lines, stats.dups, float64(stats.items)/float64(stats.lines), rankPolicy)
+	}
+}
+
+// ensureDictionary downloads the CPE dictionary of c when download is set or
+// no copy exists yet, and returns the path of the local copy. A gzip download
+// is uncompressed; anything else, such as a zip archive of JSON feed chunks,
+// is kept as is for openDictionary.
+func ensureDictionary(ctx context.Context, c *config.Config, download bool) string {
+	cpePath := c.GetCPEPath()
+	if download || !fileExists(cpePath) {
+		source, err := c.GetCPESource(time.Now())
 		if err != nil {
-			log.Fatalf("HTTP error: %v", err)
+			log.Fatalf("Failed to resolve CPE source: %v", err)
 		}
-		defer eresp.Body.Close()
+		fmt.Fprintf(importOut, "Downloading CPE data from %s ...\n", source)
+		timeout, _, _ := c.GetDownloadTimeouts()
+		dctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		body, err := openSource(dctx, source)
+		if err != nil {
+			if isTimeout(err) {
+				log.Fatalf("Download timed out after %s: %v", timeout, err)
+			}
+			log.Fatalf("Download error: %v", err)
+		}
+		defer body.Close()
 
-		// stream to .gz file
-		gzPath := cpePath + ".gz"
-		out, err := os.Create(gzPath)
+		// Gzip downloads are uncompressed as they arrive, so only the
+		// uncompressed copy takes disk space. The previous copy is only
+		// replaced once the download checks out
+		if err := os.MkdirAll(filepath.Dir(cpePath), 0o755); err != nil {
+			log.Fatalf("Failed to create CPE directory: %v", err)
+		}
+		newPath := cpePath + ".new"
+		out, err := os.Create(newPath)
 		if err != nil {
 			log.Fatalf("File create error: %v", err)
 		}
-		if _, err := io.Copy(out, eresp.Body); err != nil {
-			out.Close()
-			log.Fatalf("Failed to download file: %v", err)
+		r, err := uncompressed(bufio.NewReader(body))
+		if err == nil {
+			_, err = io.Copy(out, r)
 		}
 		out.Close()
-
-		// decompress
-		fmt.Printf("Uncompressing %s ...\n", gzPath)
-		if err := gunzip(gzPath, cpePath); err != nil {
-			log.Fatalf("gunzip error: %v", err)
+		if err != nil {
+			os.Remove(newPath)
+			if isTimeout(err) {
+				log.Fatalf("Download timed out after %s: %v", timeout, err)
+			}
+			log.Fatalf("Failed to download file, the download is truncated or corrupt: %v", err)
+		}
+		if err := verifyDictionary(ctx, c, newPath, source); err != nil {
+			os.Remove(newPath)
+			log.Fatalf("Refusing to import the download of %s: %v", source, err)
+		}
+		if err := os.Rename(newPath, cpePath); err != nil {
+			log.Fatalf("Failed to move download to %s: %v", cpePath, err)
 		}
-		os.Remove(gzPath)
 	} else {
-		fmt.Printf("Using existing file %s\n", cpePath)
+		fmt.Fprintf(importOut, "Using existing file %s\n", cpePath)
 	}
+	return cpePath
+}
+
+// cpeEntry is a dictionary entry as read from any source. The times are
+// zero when the source doesn't give them.
+type cpeEntry struct {
+	name              string
+	title             string
+	refs              []string
+	created, modified time.Time
+	deprecated        bool
+	// deprecatedBy are the CPE names replacing a deprecated entry
+	deprecatedBy []string
+}
 
-	// Flush if replace
-	if dbSize > 0 && *replace {
-		fmt.Printf("Flushing %d keys...\n", dbSize)
-		if err := rdb.FlushDB(ctx).Err(); err != nil {
-			log.Fatalf("Failed to flush database: %v", err)
+// replacement returns the CPE line replacing line, the line of a deprecated
+// entry, empty when the entry names no replacement. It returns false when a
+// replacement is in line itself, such as a corrected version, so the line
+// lives on.
+func (e *cpeEntry) replacement(line string) (string, bool) {
+	repl := ""
+	for _, name := range e.deprecatedBy {
+		_, _, _, _, byLine := extract(name)
+		if byLine == line {
+			return "", false
 		}
+		if repl == "" {
+			repl = byLine
+		}
+	}
+	return repl, true
+}
+
+// parseNVDTime parses the timestamps of the dictionary and the NVD API,
+// which leaves the zone out for UTC. It returns the zero time for anything
+// else.
+func parseNVDTime(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05", s); err == nil {
+		return t
 	}
+	return time.Time{}
+}
+
+// entrySource reads dictionary entries one at a time.
+type entrySource interface {
+	// next returns the next entry, or io.EOF after the last one. An
+	// *entryError reports an entry that could not be read, after which
+	// reading can go on.
+	next() (*cpeEntry, error)
+	// pos describes the position of the last entry read, for error
+	// messages.
+	pos() string
+}
+
+// entryError is an error in a single entry of the source.
+type entryError struct {
+	err error
+}
 
-	// Parse and populate
-	fmt.Println("Populating the database (this may take a while)...")
-	f, err := os.Open(cpePath)
+func (e *entryError) Error() string { return e.err.Error() }
+func (e *entryError) Unwrap() error { return e.err }
+
+// openEntries opens the configured source of dictionary entries: the NVD
+// Products API when nvd.enabled is set, and otherwise the dictionary file,
+// downloaded first when download is set or no copy exists yet. With stream
+// the download is read as it arrives instead, and only written to the
+// dictionary file with tee. A non-zero since only requests the entries
+// modified after it from the API. The returned function releases the source.
+func openEntries(ctx context.Context, c *config.Config, download, stream, tee bool, readBuffer int, since time.Time) (entrySource, func()) {
+	if c.NVD.Enabled {
+		src := newNVDSource(ctx, c, since)
+		fmt.Fprintf(importOut, "Fetching CPEs from %s (%s between requests)...\n", src.url, src.interval)
+		return src, func() {}
+	}
+	if stream {
+		return streamDictionary(ctx, c, tee, readBuffer)
+	}
+	f, err := os.Open(ensureDictionary(ctx, c, download))
 	if err != nil {
 		log.Fatalf("Open CPE file: %v", err)
 	}
-	defer f.Close()
+	src := &fileSource{}
+	src.total.Store(-1)
+	if fi, err := f.Stat(); err == nil {
+		src.total.Store(fi.Size())
+	}
+	if src.entrySource, err = openDictionary(&countingFile{File: f, p: &src.inputProgress}, readBuffer); err != nil {
+		f.Close()
+		log.Fatalf("Open CPE file: %v", err)
+	}
+	return src, func() { f.Close() }
+}
 
-	decoder := xml.NewDecoder(f)
-	itemCount := 0
-	wordCount := 0
-	start := time.Now()
-	pipe := rdb.Pipeline()
+// xmlSource reads the cpe-item elements of the official XML dictionary.
+type xmlSource struct {
+	decoder *xml.Decoder
+}
+
+func newXMLSource(r io.Reader, readBuffer int) *xmlSource {
+	return &xmlSource{decoder: xml.NewDecoder(bufio.NewReaderSize(r, readBuffer))}
+}
 
+func (s *xmlSource) next() (*cpeEntry, error) {
 	for {
-		tok, err := decoder.Token()
+		tok, err := s.decoder.Token()
 		if err == io.EOF {
-			break
+			return nil, io.EOF
 		}
 		if err != nil {
-			log.Fatalf("XML parse error: %v", err)
+			return nil, fmt.Errorf("XML parse error: %w", err)
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "cpe-item" {
+			continue
+		}
+		var xe XMLEntry
+		if err := s.decoder.DecodeElement(&xe, &se); err != nil {
+			// The decoder can't resume after malformed XML
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return nil, fmt.Errorf("XML decode error: %w", err)
+			}
+			return nil, &entryError{err: err}
+		}
+		if xe.Item.Name == "" {
+			continue
+		}
+		return xe.entry(), nil
+	}
+}
+
+func (s *xmlSource) pos() string {
+	return fmt.Sprintf("line %d", line(s.decoder))
+}
+
+// populateOptions control how populate indexes the dictionary.
+type populateOptions struct {
+	rankPolicy string
+	// onlyParts limits the import to these CPE parts when not empty
+	onlyParts  partSet
+	strict     bool
+	references bool
+	versions   bool
+	// titles indexes lines under the words of their title too
+	titles bool
+	// tokenizer splits vendors and products into words, of which the
+	// stopwords are left out
+	tokenizer guesser.Tokenizer
+	stopwords guesser.Stopwords
+	// since skips the entries not modified after it, when not zero
+	since time.Time
+	// ranks reads the ranks of lines already indexed, for an incremental
+	// import to count the entries without a creation date only in the
+	// lines it adds
+	ranks func(ctx context.Context, cpes []string) ([]float64, error)
+	// update is set when the index holds earlier imports, whose deprecation
+	// marks may have to be lifted
+	update bool
+	// cveCounts holds the number of CVEs of CPE lines, each raising the
+	// rank of its line by cveWeight
+	cveCounts map[string]int
+	cveWeight float64
+	// batchSize is the number of entries written per batch, and workers
+	// the number of batches executed concurrently
+	batchSize int
+	workers   int
+	// checkpoint records every batch of entries written in the checkpoint
+	// of the index, and done are the batches a resumed import skips
+	checkpoint bool
+	done       map[int]bool
+	// progress receives the progress lines; nil prints none
+	progress io.Writer
+}
+
+// canonize returns the words the vendor or product val is indexed under.
+func (o populateOptions) canonize(val string) []string {
+	return o.stopwords.Filter(o.tokenizer.Split(val))
+}
+
+// titleWords returns the words of title to index its line under besides
+// words, those of the vendor and product. The words of version, which the
+// title of an entry usually ends with, and the stopwords are left out.
+func (o populateOptions) titleWords(title, version string, words []string) []string {
+	skip := make(map[string]bool)
+	for _, w := range words {
+		skip[w] = true
+	}
+	for _, w := range o.tokenizer.Split(version) {
+		skip[w] = true
+	}
+	var out []string
+	for _, w := range o.tokenizer.SplitText(title) {
+		if !skip[w] && !o.stopwords[w] {
+			skip[w] = true
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// cveBonus returns what the CVEs of cpeline add to its rank.
+func (o populateOptions) cveBonus(cpeline string) float64 {
+	return float64(o.cveCounts[cpeline]) * o.cveWeight
+}
+
+// importStats describes what populate indexed.
+type importStats struct {
+	items, words, dups, skippedParts, unchanged, deprecated int
+	// cveLines is the number of CPE lines whose CVEs raised their rank
+	cveLines int
+	// lines is the number of distinct CPE lines
+	lines int
+	// resumed is the number of batches written before an interruption
+	resumed int
+	errs    entryErrors
+	elapsed time.Duration
+}
+
+// decodedEntry is an entry read from the dictionary with the parts of its
+// name, or the error reading it. pos is set for errors and invalid names.
+type decodedEntry struct {
+	*cpeEntry
+	part, vendor, product, version, cpeline string
+	err                                     error
+	pos                                     string
+}
+
+// decodeBuffer is the number of decoded entries queued for populate.
+const decodeBuffer = 1024
+
+// decodeEntries reads the entries of src on a goroutine until the end of
+// src, an error other than an *entryError, or ctx is done.
+func decodeEntries(ctx context.Context, src entrySource) <-chan decodedEntry {
+	out := make(chan decodedEntry, decodeBuffer)
+	go func() {
+		defer close(out)
+		for {
+			e, err := src.next()
+			if err == io.EOF {
+				return
+			}
+			d := decodedEntry{cpeEntry: e, err: err}
+			if err != nil {
+				d.pos = src.pos()
+			} else if d.part, d.vendor, d.product, d.version, d.cpeline = extract(e.name); d.vendor == "" || d.product == "" {
+				d.pos = src.pos()
+			}
+			select {
+			case out <- d:
+			case <-ctx.Done():
+				return
+			}
+			var entryErr *entryError
+			if err != nil && !errors.As(err, &entryErr) {
+				return
+			}
 		}
+	}()
+	return out
+}
+
+// batchPool executes filled batches on worker goroutines, with a spare
+// batch to fill meanwhile.
+type batchPool struct {
+	free chan guesser.Batch
+	full chan guesser.Batch
+	wg   sync.WaitGroup
+	once sync.Once
+
+	mu  sync.Mutex
+	err error
+}
 
-		switch se := tok.(type) {
-		case xml.StartElement:
-			if se.Name.Local == "cpe23-item" {
-				var xe XMLEntry
-				if err := decoder.DecodeElement(&xe, &se); err != nil {
-					log.Fatalf("XML decode error: %v", err)
+// newBatchPool starts workers executing the batches handed to the pool,
+// which are made by newBatch.
+func newBatchPool(ctx context.Context, newBatch func() guesser.Batch, workers int) *batchPool {
+	p := &batchPool{
+		free: make(chan guesser.Batch, workers+1),
+		full: make(chan guesser.Batch),
+	}
+	for i := 0; i <= workers; i++ {
+		p.free <- newBatch()
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for batch := range p.full {
+				if err := batch.Exec(ctx); err != nil {
+					p.mu.Lock()
+					if p.err == nil {
+						p.err = err
+					}
+					p.mu.Unlock()
 				}
-				vendor, product, cpeline := extract(xe.Name)
+				p.free <- batch
+			}
+		}()
+	}
+	return p
+}
+
+func (p *batchPool) error() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// get returns an empty batch, waiting for one to be executed when all are
+// in use. It fails once the execution of a batch failed.
+func (p *batchPool) get() (guesser.Batch, error) {
+	batch := <-p.free
+	return batch, p.error()
+}
+
+// put hands batch over to a worker.
+func (p *batchPool) put(batch guesser.Batch) {
+	p.full <- batch
+}
+
+// wait stops the workers once they executed the batches handed over, and
+// returns the first error of their execution.
+func (p *batchPool) wait() error {
+	p.once.Do(func() {
+		close(p.full)
+		p.wg.Wait()
+	})
+	return p.error()
+}
+
+// populate reads the entries of src and writes them to batches made by
+// newBatch. A goroutine decodes the entries while populate indexes them,
+// and every opts.batchSize entries the batch is handed over to one of
+// opts.workers goroutines executing batches concurrently. The writes of an
+// import don't depend on each other's order, so batches may complete in any
+// order.
+func populate(ctx context.Context, src entrySource, newBatch func() guesser.Batch, opts populateOptions) (*importStats, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	batchSize := opts.batchSize
+	pool := newBatchPool(ctx, newBatch, opts.workers)
+	defer pool.wait()
+	stats := &importStats{}
+
+	// Batches of entries are numbered in the checkpoint, and those a
+	// resumed import already wrote are discarded
+	var (
+		batch      guesser.Batch
+		seq        int
+		checkpoint = opts.checkpoint
+		done       = opts.done
+	)
+	next := func() error {
+		if done[seq] {
+			batch = discardBatch{}
+			stats.resumed++
+			return nil
+		}
+		var err error
+		if batch, err = pool.get(); err != nil {
+			return fmt.Errorf("pipeline execution error: %w", err)
+		}
+		return nil
+	}
+	// Entries of an incremental import without a creation date are only
+	// counted in lines the index doesn't hold yet, so those of a batch are
+	// looked up before it is handed over. indexed keeps the lines looked
+	// up, as the batches of this import add ranks to them.
+	type pendingRank struct {
+		words []string
+		line  string
+	}
+	var pending []pendingRank
+	indexed := make(map[string]bool)
+	countNew := func() error {
+		var lookup []string
+		for _, p := range pending {
+			if _, ok := indexed[p.line]; !ok {
+				indexed[p.line] = false
+				lookup = append(lookup, p.line)
+			}
+		}
+		if len(lookup) > 0 {
+			ranks, err := opts.ranks(ctx, lookup)
+			if err != nil {
+				return fmt.Errorf("failed to read ranks: %w", err)
+			}
+			for i, line := range lookup {
+				indexed[line] = ranks[i] > 0
+			}
+		}
+		for _, p := range pending {
+			if !indexed[p.line] {
+				batch.IncrRank(p.words, p.line, 1)
+			}
+		}
+		return nil
+	}
+	// flush hands the filled batch over and takes the next one
+	flush := func() error {
+		if _, discard := batch.(discardBatch); !discard {
+			if err := countNew(); err != nil {
+				return err
+			}
+			if checkpoint {
+				batch.SetCheckpoint(strconv.Itoa(seq), strconv.Itoa(stats.items))
+			}
+			pool.put(batch)
+		}
+		pending = pending[:0]
+		seq++
+		return next()
+	}
+	if err := next(); err != nil {
+		return nil, err
+	}
+
+	// CPE lines already indexed in this run; extract truncates to
+	// vendor:product so many entries collapse into one line
+	seen := make(map[string]struct{})
+	// Lines with an entry in force, and the replacements named by the
+	// deprecated entries of lines
+	active := make(map[string]bool)
+	replacements := make(map[string]string)
+	start := time.Now()
+	ps, _ := src.(progressSource)
+	prog := &progressReporter{w: opts.progress, src: ps, start: start, last: start}
+
+	for e := range decodeEntries(ctx, src) {
+		var entryErr *entryError
+		if errors.As(e.err, &entryErr) && !opts.strict {
+			stats.errs.add("%s: %v", e.pos, e.err)
+			continue
+		}
+		if e.err != nil {
+			return nil, fmt.Errorf("%s: %w", e.pos, e.err)
+		}
+		part, vendor, product, version, cpeline := e.part, e.vendor, e.product, e.version, e.cpeline
+		if vendor == "" || product == "" {
+			if opts.strict {
+				return nil, fmt.Errorf("invalid CPE name %q", e.name)
+			}
+			stats.errs.add("%s: invalid CPE name %q", e.pos, e.name)
+			continue
+		}
+		if len(opts.onlyParts) > 0 && !opts.onlyParts[part] {
+			stats.skippedParts++
+			continue
+		}
+		if !opts.since.IsZero() && !e.modified.IsZero() && !e.modified.After(opts.since) {
+			stats.unchanged++
+			continue
+		}
+
+		// Increment counter first to start with 1
+		stats.items++
+
+		_, dup := seen[cpeline]
+		if dup {
+			stats.dups++
+		} else {
+			seen[cpeline] = struct{}{}
+		}
+		words := append(opts.canonize(vendor), opts.canonize(product)...)
 
-				// index words - use SAdd for intersection (like Python)
-				for _, w := range canonize(vendor) {
-					pipe.SAdd(ctx, "w:"+w, cpeline)                             // Set membership for intersection
-					pipe.ZIncrBy(ctx, "s:"+w, 1, cpeline) // Keep for compatibility
-					wordCount++
+		switch {
+		case !dup:
+			// index words - use sets for intersection (like Python)
+			for _, w := range words {
+				batch.AddWord(w, cpeline)
+				stats.words++
+			}
+			batch.AddProduct(vendor, cpeline) // Product listing per vendor
+			if e.title != "" {
+				batch.SetTitle(cpeline, e.title) // Title of the first entry
+			}
+			// The words of that title find the line too, without
+			// weighing in its rank
+			if opts.titles && e.title != "" {
+				for _, w := range opts.titleWords(e.title, version, words) {
+					batch.AddWord(w, cpeline)
+					stats.words++
 				}
-				for _, w := range canonize(product) {
-					pipe.SAdd(ctx, "w:"+w, cpeline)                             // Set membership for intersection
-					pipe.ZIncrBy(ctx, "s:"+w, 1, cpeline) // Keep for compatibility
-					wordCount++
+			}
+			bonus := opts.cveBonus(cpeline)
+			if bonus > 0 {
+				stats.cveLines++
+			}
+			if opts.rankPolicy == rankOnce {
+				batch.SetRank(words, cpeline, 1+bonus)
+				break
+			}
+			// An incremental import only sees changed entries of lines
+			// whose CVEs were counted when created
+			if bonus > 0 && opts.since.IsZero() {
+				batch.IncrRank(words, cpeline, bonus)
+			}
+			fallthrough
+		case opts.rankPolicy == rankEntries:
+			// Higher rank = better match. Entries an incremental import
+			// sees again after a change were counted when created, or,
+			// without a creation date, when their line was indexed
+			switch {
+			case opts.since.IsZero() || e.created.After(opts.since):
+				batch.IncrRank(words, cpeline, 1)
+			case e.created.IsZero():
+				pending = append(pending, pendingRank{words, cpeline})
+			}
+		}
+
+		// References are a set so repeated entries and updates
+		// don't duplicate links
+		if opts.references {
+			for _, ref := range e.refs {
+				if ref != "" {
+					batch.AddReference(cpeline, ref)
 				}
+			}
+		}
 
-				// Increment counter first to start with 1
-				itemCount++
+		if opts.versions && version != "" && version != "*" && version != "-" {
+			batch.AddVersion(cpeline, version)
+		}
 
-				// Add to rank:cpe with increasing rank (higher rank = better match)
-				pipe.ZIncrBy(ctx, "rank:cpe", 1, cpeline)
+		if repl, ok := e.replacement(cpeline); e.deprecated && ok {
+			if replacements[cpeline] == "" {
+				replacements[cpeline] = repl
+			}
+		} else {
+			active[cpeline] = true
+		}
 
-				if itemCount%batchSize == 0 {
-					if _, err := pipe.Exec(ctx); err != nil {
-						log.Fatalf("Pipeline execution error: %v", err)
-					}
-					pipe = rdb.Pipeline() // Create new pipeline
-					fmt.Printf("... %d items (%d words) in %s\n", itemCount, wordCount, time.Since(start))
+		if stats.items%batchSize == 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			prog.report(stats.items, stats.words)
+		}
+	}
+
+	// The deprecation pass depends on every entry, so it is written in
+	// full and not checkpointed
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	checkpoint, done = false, nil
+
+	// A line is deprecated once all of its entries are
+	ops := 0
+	for line, repl := range replacements {
+		if active[line] {
+			continue
+		}
+		batch.SetDeprecated(line, repl)
+		stats.deprecated++
+		ops++
+		if ops%batchSize == 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.update {
+		for line := range active {
+			batch.ClearDeprecated(line)
+			ops++
+			if ops%batchSize == 0 {
+				if err := flush(); err != nil {
+					return nil, err
 				}
 			}
 		}
 	}
 
-	// flush final pipeline
-	if _, err := pipe.Exec(ctx); err != nil {
-		log.Fatalf("Final pipeline execution error: %v", err)
+	// flush final pipeline and wait for the workers
+	pool.put(batch)
+	if err := pool.wait(); err != nil {
+		return nil, fmt.Errorf("final pipeline execution error: %w", err)
 	}
+	stats.lines = len(seen)
+	stats.elapsed = time.Since(start)
+	return stats, nil
+}
+
+// memorySamples is the number of keys sampled to estimate index memory.
+const memorySamples = 500
 
-	elapsed := time.Since(start)
-	finalSize, err := rdb.DBSize(ctx).Result()
+// indexSize returns the number of keys of the index in rdb: the size of its
+// database, or in a cluster the number of keys with the index hash tag.
+func indexSize(ctx context.Context, rdb redis.UniversalClient) (int64, error) {
+	prefix := guesser.KeyPrefix(rdb)
+	if prefix == "" {
+		return rdb.DBSize(ctx).Result()
+	}
+	node, err := guesser.IndexNode(ctx, rdb)
 	if err != nil {
-		log.Printf("Warning: Could not get final DB size: %v", err)
-		finalSize = 0
+		return 0, err
+	}
+	var n int64
+	iter := node.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		n++
+	}
+	return n, iter.Err()
+}
+
+// flushIndex deletes the index in rdb: its whole database, or in a cluster
+// the keys with the index hash tag, leaving the other keys of the shard.
+func flushIndex(ctx context.Context, rdb redis.UniversalClient) error {
+	prefix := guesser.KeyPrefix(rdb)
+	if prefix == "" {
+		return rdb.FlushDB(ctx).Err()
+	}
+	node, err := guesser.IndexNode(ctx, rdb)
+	if err != nil {
+		return err
+	}
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, prefix+"*", 1000).Result()
+		if err != nil {
+			return err
+		}
+		// The keys share a slot, so one UNLINK takes them all
+		if len(keys) > 0 {
+			if err := node.Unlink(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
 	}
-	fmt.Printf("Done! %d items, %d words in %s. DB size: %d\n", itemCount, wordCount, elapsed, finalSize)
 }
 
-func extract(cpe string) (vendor, product, cpeline string) {
-	parts := strings.Split(cpe, ":")
+// usedMemory returns the memory used by the Redis server holding the index,
+// from INFO memory.
+func usedMemory(ctx context.Context, rdb redis.UniversalClient) (int64, error) {
+	node, err := guesser.IndexNode(ctx, rdb)
+	if err != nil {
+		return 0, err
+	}
+	info, err := node.Info(ctx, "memory").Result()
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range strings.Split(info, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(l), "used_memory:"); ok {
+			return strconv.ParseInt(v, 10, 64)
+		}
+	}
+	return 0, errors.New("used_memory missing from INFO memory")
+}
+
+// estimateIndexMemory extrapolates the memory of the keys keys of the index
+// from MEMORY USAGE of up to samples random keys, always including rank:cpe.
+// It returns the estimate and the number of keys sampled.
+func estimateIndexMemory(ctx context.Context, rdb redis.UniversalClient, keys int64, samples int) (int64, int, error) {
+	if keys == 0 {
+		return 0, 0, nil
+	}
+	prefix := guesser.KeyPrefix(rdb)
+	node, err := guesser.IndexNode(ctx, rdb)
+	if err != nil {
+		return 0, 0, err
+	}
+	rankSize, err := node.MemoryUsage(ctx, prefix+"rank:cpe").Result()
+	if err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+
+	var total int64
+	sampled := 0
+	for i := 0; i < samples; i++ {
+		key, err := node.RandomKey(ctx).Result()
+		if err != nil {
+			return 0, 0, err
+		}
+		// Skip rank:cpe and, in a cluster, the keys of the shard that
+		// aren't part of the index
+		if key == prefix+"rank:cpe" || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		size, err := node.MemoryUsage(ctx, key).Result()
+		if err != nil {
+			return 0, 0, err
+		}
+		total += size
+		sampled++
+	}
+	if sampled == 0 {
+		return rankSize, 1, nil
+	}
+	return rankSize + total*(keys-1)/int64(sampled), sampled + 1, nil
+}
+
+// formatBytes renders a byte count in binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit || m <= -unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// line returns the line of the input the decoder has reached.
+func line(d *xml.Decoder) int {
+	l, _ := d.InputPos()
+	return l
+}
+
+// newDownloadClient returns the HTTP client used to fetch the CPE dictionary,
+// with the configured timeouts and proxy settings from the environment.
+func newDownloadClient() *http.Client {
+	overall, connect, tlsTimeout := cfg.GetDownloadTimeouts()
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: connect}).DialContext,
+		TLSHandshakeTimeout: tlsTimeout,
+	}
+	return &http.Client{Timeout: overall, Transport: transport}
+}
+
+func extract(cpe string) (part, vendor, product, version, cpeline string) {
+	parts := guesser.SplitCPE(cpe)
 	if len(parts) < 5 {
-		return "", "", cpe
+		return "", "", "", "", cpe
 	}
+	part = parts[2]
 	vendor = parts[3]
 	product = parts[4]
+	if len(parts) > 5 {
+		version = parts[5]
+	}
 	cpeline = strings.Join(parts[:5], ":")
-	return vendor, product, cpeline
-}
-
-func canonize(val string) []string {
-	val = strings.ToLower(val)
-	return strings.Split(val, "_")
+	return part, vendor, product, version, cpeline
 }
 
 func fileExists(path string) bool {
@@ -208,22 +1359,15 @@ func fileExists(path string) bool {
 	return err == nil
 }
 
-func gunzip(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
+// uncompressed returns the content of br, uncompressing it when it is gzip
+// data. A gzip stream fails its CRC check at the end when corrupt.
+func uncompressed(br *bufio.Reader) (io.Reader, error) {
+	head, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
 	}
-	defer in.Close()
-	gr, err := gzip.NewReader(in)
-	if err != nil {
-		return err
-	}
-	defer gr.Close()
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
+	if !bytes.Equal(head, gzipMagic) {
+		return br, nil
 	}
-	defer out.Close()
-	_, err = io.Copy(out, gr)
-	return err
+	return gzip.NewReader(br)
 }
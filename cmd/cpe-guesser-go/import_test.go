@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+)
+
+const testDictionary = `<?xml version='1.0' encoding='UTF-8'?>
+<cpe-list xmlns="http://cpe.mitre.org/dictionary/2.0" xmlns:cpe-23="http://scap.nist.gov/schema/cpe-extension/2.3">
+  <cpe-item name="cpe:/a:apache:tomcat:9.0.0">
+    <title xml:lang="en-US">Apache Tomcat 9.0.0</title>
+    <cpe-23:cpe23-item name="cpe:2.3:a:apache:tomcat:9.0.0:*:*:*:*:*:*:*"/>
+  </cpe-item>
+  <cpe-item name="cpe:/a:apache:tomcat:10.0.0">
+    <title xml:lang="en-US">Apache Tomcat 10.0.0</title>
+    <cpe-23:cpe23-item name="cpe:2.3:a:apache:tomcat:10.0.0:*:*:*:*:*:*:*"/>
+  </cpe-item>
+  <cpe-item name="cpe:/a:mozilla:firefox:3.6">
+    <title xml:lang="en-US">Mozilla Firefox 3.6</title>
+    <cpe-23:cpe23-item name="cpe:2.3:a:mozilla:firefox:3.6:*:*:*:*:*:*:*"/>
+  </cpe-item>
+</cpe-list>
+`
+
+// testEntry is a dictionary entry of a line testDictionary doesn't have.
+const testEntry = `  <cpe-item name="cpe:/a:nginx:nginx:1.25.0">
+    <title xml:lang="en-US">Nginx 1.25.0</title>
+    <cpe-23:cpe23-item name="cpe:2.3:a:nginx:nginx:1.25.0:*:*:*:*:*:*:*"/>
+  </cpe-item>
+`
+
+// importDictionary populates store from the XML dictionary, only applying
+// the entries modified since since when it isn't zero.
+func importDictionary(t *testing.T, store *guesser.MemoryStore, dict string, since time.Time) {
+	t.Helper()
+	opts := populateOptions{
+		rankPolicy: rankEntries,
+		tokenizer:  guesser.DefaultTokenizer,
+		stopwords:  guesser.NewStopwords(true, nil),
+		since:      since,
+		update:     !since.IsZero(),
+		batchSize:  2,
+		workers:    1,
+	}
+	if !since.IsZero() {
+		opts.ranks = store.Ranks
+	}
+	src := newXMLSource(strings.NewReader(dict), 4096)
+	if _, err := populate(context.Background(), src, store.NewBatch, opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalImportRanks(t *testing.T) {
+	ctx := context.Background()
+	lines := []string{"cpe:2.3:a:apache:tomcat", "cpe:2.3:a:mozilla:firefox", "cpe:2.3:a:nginx:nginx"}
+	store := guesser.NewMemoryStore()
+	importDictionary(t, store, testDictionary, time.Time{})
+	want, err := store.Ranks(ctx, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, []float64{2, 1, 0}) {
+		t.Fatalf("full import ranks %v", want)
+	}
+
+	// The XML dictionary has no dates, so every entry is applied again
+	since := time.Now()
+	for i := 0; i < 2; i++ {
+		importDictionary(t, store, testDictionary, since)
+		got, err := store.Ranks(ctx, lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("incremental import %d: ranks %v, want %v", i+1, got, want)
+		}
+	}
+
+	// Entries of a new line still count, twice when it has two
+	dict := strings.Replace(testDictionary, "</cpe-list>", testEntry+testEntry+"</cpe-list>", 1)
+	importDictionary(t, store, dict, since)
+	got, err := store.Ranks(ctx, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{2, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incremental import with a new line: ranks %v, want %v", got, want)
+	}
+}
+
+// sampleDictionary returns an XML dictionary of n entries shaped like those
+// of the NVD one, with a title and a reference each.
+func sampleDictionary(n int) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version='1.0' encoding='UTF-8'?>
+<cpe-list xmlns="http://cpe.mitre.org/dictionary/2.0" xmlns:cpe-23="http://scap.nist.gov/schema/cpe-extension/2.3">
+`)
+	for i := 0; i < n; i++ {
+		vendor, product, version := fmt.Sprintf("vendor%d", i%500), fmt.Sprintf("product%d", i%4000), fmt.Sprintf("%d.%d.%d", i%7, i%13, i)
+		fmt.Fprintf(&b, `  <cpe-item name="cpe:/a:%[1]s:%[2]s:%[3]s">
+    <title xml:lang="en-US">%[1]s %[2]s %[3]s</title>
+    <references><reference href="https://example.com/%[1]s/%[2]s/releases">Version</reference></references>
+    <cpe-23:cpe23-item name="cpe:2.3:a:%[1]s:%[2]s:%[3]s:*:*:*:*:*:*:*"/>
+  </cpe-item>
+`, vendor, product, version)
+	}
+	b.WriteString("</cpe-list>\n")
+	return b.String()
+}
+
+// BenchmarkXMLSource decodes a dictionary file with read buffers of several
+// sizes, the default of cpe.read_buffer being picked from its results.
+func BenchmarkXMLSource(b *testing.B) {
+	dict := sampleDictionary(20000)
+	path := filepath.Join(b.TempDir(), "dictionary.xml")
+	if err := os.WriteFile(path, []byte(dict), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	for _, bench := range []struct {
+		name string
+		size int
+	}{{"4K", 4 << 10}, {"64K", 64 << 10}, {"256K", 256 << 10}, {"1M", 1 << 20}} {
+		size := bench.size
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(len(dict)))
+			for i := 0; i < b.N; i++ {
+				f, err := os.Open(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				src := newXMLSource(f, size)
+				for {
+					if _, err := src.next(); err == io.EOF {
+						break
+					} else if err != nil {
+						b.Fatal(err)
+					}
+				}
+				f.Close()
+			}
+		})
+	}
+}
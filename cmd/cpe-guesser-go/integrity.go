@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+)
+
+// dictionaryMeta is the NVD metadata file of a feed, describing the
+// uncompressed feed.
+type dictionaryMeta struct {
+	size   int64
+	sha256 string
+}
+
+// fetchMeta reads the metadata file at source, made of key:value lines.
+func fetchMeta(ctx context.Context, source string) (*dictionaryMeta, error) {
+	body, err := openSource(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	meta := &dictionaryMeta{size: -1}
+	sc := bufio.NewScanner(io.LimitReader(body, 64*1024))
+	for sc.Scan() {
+		key, val, ok := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "size":
+			if meta.size, err = strconv.ParseInt(val, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid size %q", val)
+			}
+		case "sha256":
+			meta.sha256 = val
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if meta.size < 0 || meta.sha256 == "" {
+		return nil, errors.New("no size or sha256 in the metadata file")
+	}
+	return meta, nil
+}
+
+// patternCounter counts the occurrences of pat in a stream written in
+// chunks, keeping the end of the last chunk for occurrences across two.
+type patternCounter struct {
+	pat  []byte
+	tail []byte
+	n    int
+}
+
+func (c *patternCounter) feed(p []byte) {
+	keep := len(c.pat) - 1
+	// Occurrences across the chunks start in the tail and end in p
+	joint := append(c.tail, p[:min(len(p), keep)]...)
+	c.n += bytes.Count(joint, c.pat) + bytes.Count(p, c.pat)
+	c.tail = keepLast(c.tail, c.tail, p, keep)
+}
+
+// keepLast returns the last n bytes of prev followed by p, in dst.
+func keepLast(dst, prev, p []byte, n int) []byte {
+	if len(p) >= n {
+		return append(dst[:0], p[len(p)-n:]...)
+	}
+	prev = prev[len(prev)-min(len(prev), n-len(p)):]
+	return append(append(dst[:0], prev...), p...)
+}
+
+// dictionaryScan is what a read of a dictionary file found: its size and
+// SHA-256, and for the XML dictionary the cpe-item elements opened and
+// closed and the last bytes, which close the cpe-list of a complete file.
+type dictionaryScan struct {
+	size           int64
+	sha256         string
+	xml            bool
+	opened, closed int
+	end            []byte
+}
+
+// scanDictionary reads the file at path once for a dictionaryScan.
+func scanDictionary(path string) (*dictionaryScan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(512)
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	scan := &dictionaryScan{xml: bytes.HasPrefix(head, []byte("<"))}
+	opened := &patternCounter{pat: []byte("<cpe-item")}
+	closed := &patternCounter{pat: []byte("</cpe-item>")}
+
+	h := sha256.New()
+	buf := make([]byte, 256*1024)
+	for {
+		n, err := br.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			h.Write(chunk)
+			scan.size += int64(n)
+			if scan.xml {
+				opened.feed(chunk)
+				closed.feed(chunk)
+				scan.end = keepLast(scan.end, scan.end, chunk, 64)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	scan.sha256 = hex.EncodeToString(h.Sum(nil))
+	scan.opened, scan.closed = opened.n, closed.n
+	return scan, nil
+}
+
+// verifyDictionary checks the dictionary downloaded from source to path
+// before it is imported: an XML dictionary must hold entries, close every
+// one of them and end with its closing cpe-list tag, and the size and
+// SHA-256 must match the NVD metadata file of the feed when there is one.
+// Gzip downloads were checked against their CRC when uncompressed.
+func verifyDictionary(ctx context.Context, c *config.Config, path, source string) error {
+	scan, err := scanDictionary(path)
+	if err != nil {
+		return err
+	}
+	if scan.xml {
+		switch {
+		case scan.opened == 0:
+			return errors.New("no cpe-item elements")
+		case scan.opened != scan.closed:
+			return fmt.Errorf("%d cpe-item elements opened but %d closed, the file is truncated or corrupt", scan.opened, scan.closed)
+		case !bytes.HasSuffix(bytes.TrimRight(scan.end, " \t\r\n"), []byte("</cpe-list>")):
+			return errors.New("the file does not end with </cpe-list>, it is truncated")
+		}
+	}
+
+	meta, metaSource, err := sourceMeta(ctx, c, source)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		fmt.Fprintf(importOut, "Checked %s: %d bytes, %d entries\n", path, scan.size, scan.opened)
+		return nil
+	}
+	if err := meta.check(scan.size, scan.sha256, metaSource); err != nil {
+		return err
+	}
+	fmt.Fprintf(importOut, "Verified %s against %s: %d bytes, %d entries, SHA-256 %s\n",
+		path, metaSource, scan.size, scan.opened, scan.sha256)
+	return nil
+}
+
+// sourceMeta fetches the NVD metadata file of the dictionary at source and
+// returns it with its URL. The metadata is nil when there is none to compare
+// against: none is configured, or the derived one can't be fetched.
+func sourceMeta(ctx context.Context, c *config.Config, source string) (*dictionaryMeta, string, error) {
+	metaSource, configured := c.GetCPEMetaSource(source)
+	if metaSource == "" {
+		return nil, "", nil
+	}
+	timeout, _, _ := c.GetDownloadTimeouts()
+	mctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	meta, err := fetchMeta(mctx, metaSource)
+	if err != nil {
+		if configured {
+			return nil, "", fmt.Errorf("fetching the metadata file %s: %w", metaSource, err)
+		}
+		slog.Warn("Could not fetch the dictionary metadata file, only the file structure was checked",
+			"meta_source", metaSource, "err", err)
+		return nil, "", nil
+	}
+	return meta, metaSource, nil
+}
+
+// check compares the size and SHA-256 of a dictionary with those of m, read
+// from metaSource.
+func (m *dictionaryMeta) check(size int64, sum, metaSource string) error {
+	if size != m.size {
+		return fmt.Errorf("size %d differs from %d in %s", size, m.size, metaSource)
+	}
+	if !strings.EqualFold(sum, m.sha256) {
+		return fmt.Errorf("SHA-256 %s differs from %s in %s", sum, m.sha256, metaSource)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// logFlags defines the logging flags every command takes on fs and returns
+// the function installing the logger they select once fs is parsed.
+func logFlags(fs *flag.FlagSet) func() {
+	level := fs.String("log-level", "info", "Log level: debug, info, warn or error")
+	format := fs.String("log-format", "text", "Log format: text or json")
+	return func() {
+		var lvl slog.Level
+		if err := lvl.UnmarshalText([]byte(*level)); err != nil {
+			log.Fatalf("Unknown log level %q, use debug, info, warn or error", *level)
+		}
+		opts := &slog.HandlerOptions{Level: lvl}
+		var h slog.Handler
+		switch *format {
+		case "text":
+			h = slog.NewTextHandler(os.Stderr, opts)
+		case "json":
+			h = slog.NewJSONHandler(os.Stderr, opts)
+		default:
+			log.Fatalf("Unknown log format %q, use text or json", *format)
+		}
+		slog.SetDefault(slog.New(h))
+	}
+}
+
+// requestLog collects what the handlers report about a request for its log
+// record.
+type requestLog struct {
+	results int
+	apiKey  string
+}
+
+type requestLogKey struct{}
+
+// setResultCount records the number of results a request returned in its
+// log record.
+func setResultCount(r *http.Request, n int) {
+	if rl, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		rl.results = n
+	}
+}
+
+// setAPIKeyName records the name of the API key of a request in its log
+// record.
+func setAPIKeyName(r *http.Request, name string) {
+	if rl, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		rl.apiKey = name
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs the method, path, status, duration and, for the handlers
+// reporting them, the result count and API key name of every request served
+// by next.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		rl := &requestLog{results: -1}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, rl)))
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+		}
+		if rl.results >= 0 {
+			attrs = append(attrs, "results", rl.results)
+		}
+		if rl.apiKey != "" {
+			attrs = append(attrs, "api_key", rl.apiKey)
+		}
+		slog.Info("request", attrs...)
+	})
+}
@@ -1,272 +1,1627 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"reflect"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/aringo/cpe-guesser-go/internal/api"
 	"github.com/aringo/cpe-guesser-go/internal/config"
+	"github.com/aringo/cpe-guesser-go/internal/cve"
+	"github.com/aringo/cpe-guesser-go/internal/tracing"
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+	"github.com/aringo/cpe-guesser-go/pkg/guesserpb"
 	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
+// maxRelated caps the suggestions returned with "related": true.
+const maxRelated = 10
+
+// maxBatchQueries caps the number of queries accepted by /unique/batch and
+// of package URLs accepted by /purl.
+const maxBatchQueries = 1000
+
+// defaultPURLResults is the number of candidates /purl returns per package
+// unless the request sets a limit.
+const defaultPURLResults = 5
+
+// Build information, set at build time with -ldflags "-X main.version=...".
 var (
-	ctx = context.Background()
-	rdb *redis.Client
-	cfg *config.Config
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
 )
 
-func exactSearch(words []string) ([][2]interface{}, error) {
-	if len(words) == 0 {
-		return nil, nil
-	}
+var (
+	// ctx is the context of the commands and of the server's startup;
+	// requests use their own
+	ctx       = context.Background()
+	cfg       *config.Config
+	startTime = time.Now()
+
+	// state is what the server's handlers work with; stateMu serializes
+	// its replacements
+	state   atomic.Pointer[serverState]
+	stateMu sync.Mutex
 
-	// Create keys for each word
-	keys := make([]string, len(words))
-	for i, w := range words {
-		keys[i] = "w:" + strings.ToLower(w)
+	slowQueries = expvar.NewInt("slow_queries")
+)
+
+// serverState holds the configuration and clients the HTTP handlers use. It is
+// replaced as a whole when the configuration is reloaded, so a request sees
+// one consistent version.
+type serverState struct {
+	cfg *config.Config
+	// store is the local index with the memory, bolt or sqlite storage
+	// backend, in which case there are no Redis clients
+	store guesser.Store
+	// redisAddr and readAddr are the addresses rdb and rdbRead connect to
+	redisAddr string
+	readAddr  string
+	rdb       redis.UniversalClient
+	// rdbRead serves searches; it is rdb unless a read replica is configured
+	rdbRead redis.UniversalClient
+	// cache holds repeated index lookups; nil when disabled and with the
+	// memory backend
+	cache *guesser.CachedStore
+	gs    *guesser.Client
+	// cves enriches search results on request; nil unless cve.enabled
+	cves *cve.Client
+	// keys are the API keys the lookup endpoints require; nil when they
+	// are open
+	keys *apiKeys
+	// adminKeys are the keys the admin endpoints require; nil when they
+	// are disabled
+	adminKeys *apiKeys
+}
+
+// newServerState connects to the Redis endpoints in cfg, or redisOverride
+// for the primary when set. Clients of prev are reused when their address
+// and connection settings are unchanged. With the memory, bolt or sqlite backend it loads or opens the
+// local index instead, or reuses the one of prev.
+func newServerState(cfg *config.Config, redisOverride string, prev *serverState) *serverState {
+	s := &serverState{cfg: cfg, redisAddr: cfg.GetRedisAddr(), readAddr: cfg.GetReadRedisAddr()}
+	if redisOverride != "" {
+		s.redisAddr = redisOverride
 	}
 
-	// Get intersection of all sets
-	var cpes []string
-	var err error
-	if len(keys) == 1 {
-		cpes, err = rdb.SMembers(ctx, keys[0]).Result()
-		if err != nil {
-			return nil, err
+	var store guesser.Store
+	if cfg.Storage.Backend != "" && cfg.Storage.Backend != config.BackendValkey {
+		switch {
+		case prev != nil && prev.store != nil:
+			s.store = prev.store
+		case cfg.Storage.Backend == config.BackendMemory:
+			s.store = loadMemoryIndex(cfg)
+		default:
+			s.store = openFileIndex(cfg)
 		}
+		store = s.store
 	} else {
-		cpes, err = rdb.SInter(ctx, keys...).Result()
-		if err != nil {
-			return nil, err
+		reuse := prev != nil && sameValkeyOptions(prev.cfg, cfg)
+		if reuse && prev.rdb != nil && prev.redisAddr == s.redisAddr {
+			s.rdb = prev.rdb
+		} else {
+			s.rdb = newRedisClient(cfg, s.redisAddr, cfg.GetIndexDB())
+		}
+		switch {
+		case s.readAddr == "":
+			s.rdbRead = s.rdb
+		case reuse && prev.rdbRead != nil && prev.readAddr == s.readAddr:
+			s.rdbRead = prev.rdbRead
+		default:
+			s.rdbRead = newRedisClient(cfg, s.readAddr, cfg.GetIndexDB())
 		}
+		store = guesser.NewRedisStore(s.rdbRead)
+	}
+	// The memory backend answers from the process already
+	if size := cfg.GetCacheSize(); size > 0 && cfg.Storage.Backend != config.BackendMemory {
+		s.cache = guesser.NewCachedStore(store, size, cfg.GetCacheTTL())
+		store = s.cache
 	}
 
-	if len(cpes) == 0 {
-		return nil, nil
+	s.gs = guesser.NewWithStore(store)
+	s.gs.MaxPartialWords = cfg.GetMaxPartialWords()
+	s.gs.SetSynonyms(cfg.GetSynonyms())
+	s.gs.SetTokenizer(configTokenizer(cfg))
+	s.gs.SetStopwords(configStopwords(cfg))
+	s.gs.SetPURLMappings(cfg.PURLMappings)
+	if cfg.CVE.Enabled {
+		s.cves = cve.New(cfg.GetCVEAPI(), cfg.GetCVEURL(), cfg.GetCVETimeout(), cfg.GetCVELatest(), cfg.GetCVECacheTTL())
+	}
+	var prevKeys *apiKeys
+	if prev != nil && sameValkeyOptions(prev.cfg, cfg) {
+		prevKeys = prev.keys
 	}
+	s.keys = newAPIKeys(cfg, s.redisAddr, prevKeys)
+	if len(cfg.Server.AdminKeys) > 0 {
+		s.adminKeys = &apiKeys{static: cfg.Server.AdminKeys}
+	}
+	return s
+}
 
-	// Get rank for each CPE
-	result := make([][2]interface{}, 0, len(cpes))
-	for _, cpe := range cpes {
-		rank, err := rdb.ZScore(ctx, "rank:cpe", cpe).Result()
-		if err == redis.Nil {
-			// If no rank, use 0
-			result = append(result, [2]interface{}{0.0, cpe})
-		} else if err != nil {
-			return nil, err
-		} else {
-			result = append(result, [2]interface{}{rank, cpe})
+// close releases the Redis clients and the index file of s.
+func (s *serverState) close() {
+	if s.rdb != nil {
+		s.rdb.Close()
+	}
+	if s.rdbRead != nil && s.rdbRead != s.rdb {
+		s.rdbRead.Close()
+	}
+	if fst, ok := s.store.(fileStore); ok {
+		fst.Close()
+	}
+	s.keys.close()
+}
+
+// fileStore is a Store kept in a local file, which the import writes and the
+// server opens read-only.
+type fileStore interface {
+	guesser.Store
+	Len() (int, error)
+	Reset() error
+	Close() error
+}
+
+// openSQLite opens a SQLite index file. It is nil unless the binary is built
+// with the sqlite_fts5 tag.
+var openSQLite func(path string, readOnly bool) (fileStore, error)
+
+// openFileStore opens the index file of the bolt or sqlite backend of cfg.
+func openFileStore(cfg *config.Config, path string, readOnly bool) (fileStore, error) {
+	if cfg.Storage.Backend == config.BackendSQLite {
+		if openSQLite == nil {
+			return nil, errors.New("this binary is built without SQLite support, rebuild it with -tags sqlite_fts5")
 		}
+		return openSQLite(path, readOnly)
 	}
+	return guesser.OpenBoltStore(path, readOnly)
+}
 
-	// Sort by rank (highest first)
-	sort.Slice(result, func(i, j int) bool {
-		return result[i][0].(float64) > result[j][0].(float64)
-	})
+// openFileIndex opens the index file of cfg read-only.
+func openFileIndex(cfg *config.Config) fileStore {
+	path := cfg.GetStoragePath()
+	if !fileExists(path) {
+		log.Fatalf("Index file %s not found, run the import first", path)
+	}
+	store, err := openFileStore(cfg, path, true)
+	if err != nil {
+		log.Fatalf("Failed to open index file %s: %v", path, err)
+	}
+	cpes, err := store.Len()
+	if err != nil {
+		log.Fatalf("Failed to read index file %s: %v", path, err)
+	}
+	slog.Info("Opened index file", "path", path, "cpes", cpes)
+	return store
+}
 
-	return result, nil
+// configTokenizer returns the tokenization rules of cfg, shared by the import
+// and the server.
+func configTokenizer(cfg *config.Config) guesser.Tokenizer {
+	return guesser.Tokenizer{
+		Separators: cfg.GetTokenSeparators(),
+		Digits:     cfg.Tokenize.Digits,
+		CamelCase:  cfg.Tokenize.CamelCase,
+	}
 }
 
-func partialSearch(words []string) ([][2]interface{}, error) {
-	if len(words) == 0 {
-		return nil, nil
+// configStopwords returns the stopwords of cfg, which the import leaves out
+// of the index and the server out of queries.
+func configStopwords(cfg *config.Config) guesser.Stopwords {
+	return guesser.NewStopwords(!cfg.Stopwords.DisableDefaults, cfg.Stopwords.Words)
+}
+
+// loadMemoryIndex builds the in-memory index from the CPE dictionary of cfg,
+// downloading it first when there is no local copy, or loads the index dump
+// of cpe.index_snapshot.
+func loadMemoryIndex(cfg *config.Config) *guesser.MemoryStore {
+	if cfg.CPE.IndexSnapshot != "" {
+		d, input, release := fetchIndexDump(ctx, cfg)
+		defer release()
+		store := guesser.NewMemoryStore()
+		start := time.Now()
+		prog := &progressReporter{w: importOut, src: input, start: start, last: start}
+		stats, err := d.restore(ctx, store.NewBatch, 1, cfg.GetBatchSize(), prog)
+		if err != nil {
+			log.Fatalf("Failed to load the index dump: %v", err)
+		}
+		cpes, words := store.Len()
+		slog.Info("In-memory index ready", "cpes", cpes, "words", words, "exported", d.header.Exported, "elapsed", stats.elapsed)
+		return store
 	}
 
-	// Create a map to store all matching CPEs
-	cpeMap := make(map[string]struct{})
+	src, closeSrc := openEntries(ctx, cfg, false, false, false, cfg.GetReadBuffer(), time.Time{})
+	defer closeSrc()
 
-	// For each word, find partially matching sets
-	for _, w := range words {
-		pattern := "w:*" + strings.ToLower(w) + "*"
-		iter := rdb.Scan(ctx, 0, pattern, 0).Iterator()
+	var cveCounts map[string]int
+	if len(cfg.CPE.CVEFeeds) > 0 {
+		cveCounts = loadCVEFeeds(ctx, cfg)
+	}
+	slog.Info("Building in-memory index")
+	store := guesser.NewMemoryStore()
+	// The store takes one batch at a time
+	stats, err := populate(ctx, src, store.NewBatch, populateOptions{
+		rankPolicy: rankEntries,
+		references: cfg.CPE.IndexReferences,
+		versions:   cfg.CPE.IndexVersions,
+		titles:     cfg.CPE.IndexTitles,
+		tokenizer:  configTokenizer(cfg),
+		stopwords:  configStopwords(cfg),
+		cveCounts:  cveCounts,
+		cveWeight:  cfg.GetCVEWeight(),
+		batchSize:  cfg.GetBatchSize(),
+		workers:    1,
+		progress:   importOut,
+	})
+	if err != nil {
+		log.Fatalf("Failed to build in-memory index: %v", err)
+	}
+	cpes, words := store.Len()
+	slog.Info("In-memory index ready", "cpes", cpes, "words", words, "entries", stats.items, "elapsed", stats.elapsed)
+	return store
+}
+
+// tuples converts results to the [rank, cpe] pairs returned by the API. Scored
+// results carry their score in place of the rank.
+func tuples(res []guesser.Result) [][2]interface{} {
+	if res == nil {
+		return nil
+	}
+	out := make([][2]interface{}, len(res))
+	for i, r := range res {
+		if r.Scored {
+			out[i] = [2]interface{}{r.Score, r.CPE}
+			continue
+		}
+		out[i] = [2]interface{}{r.Rank, r.CPE}
+	}
+	return out
+}
 
-		for iter.Next(ctx) {
-			key := iter.Val()
-			members, err := rdb.SMembers(ctx, key).Result()
+// decodeRequest decodes the JSON request body into req. A bare array of
+// words, as posted by the original Python tool, is decoded into query instead.
+// GET requests are decoded from their URL parameters.
+func decodeRequest(r *http.Request, req interface{}, query interface{}) error {
+	if r.Method == http.MethodGet {
+		return decodeParams(r.URL.Query(), req)
+	}
+	br := bufio.NewReader(r.Body)
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		br.UnreadByte()
+		if c == '[' {
+			return json.NewDecoder(br).Decode(query)
+		}
+		return json.NewDecoder(br).Decode(req)
+	}
+}
+
+// decodeParams decodes GET parameters into the JSON request req: q holds the
+// comma-separated query words and the other parameters set the option of the
+// same name, converted to the type of its field.
+func decodeParams(params url.Values, req interface{}) error {
+	var words []string
+	for _, w := range strings.Split(params.Get("q"), ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			words = append(words, w)
+		}
+	}
+	if len(words) == 0 {
+		return errors.New("missing q parameter")
+	}
+	body := map[string]interface{}{"query": words}
+	for name := range params {
+		if name == "q" || name == "query" {
+			continue
+		}
+		val := params.Get(name)
+		kind, ok := paramKind(req, name)
+		switch {
+		case !ok:
+			body[name] = val
+		case kind == reflect.Bool:
+			b, err := strconv.ParseBool(val)
 			if err != nil {
-				return nil, err
+				return fmt.Errorf("%s must be true or false", name)
 			}
-
-			for _, cpe := range members {
-				cpeMap[cpe] = struct{}{}
+			body[name] = b
+		case kind >= reflect.Int && kind <= reflect.Float64:
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return fmt.Errorf("%s must be a number", name)
 			}
+			body[name] = f
+		case kind == reflect.String:
+			body[name] = val
+		default:
+			return fmt.Errorf("%s needs a POST body", name)
 		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, req); err != nil {
+		return errors.New("invalid query parameters")
+	}
+	return nil
+}
 
-		if err := iter.Err(); err != nil {
-			return nil, err
+// paramKind returns the kind of the field of the struct req points to whose
+// JSON name is name, looking through pointers.
+func paramKind(req interface{}, name string) (reflect.Kind, bool) {
+	t := reflect.TypeOf(req).Elem()
+	if t.Kind() != reflect.Struct {
+		return reflect.Invalid, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag != name {
+			continue
 		}
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		return ft.Kind(), true
 	}
+	return reflect.Invalid, false
+}
 
-	if len(cpeMap) == 0 {
-		return nil, nil
+// decodeError is the response message for a request decodeRequest rejected.
+func decodeError(r *http.Request, err error) string {
+	if r.Method == http.MethodGet {
+		return err.Error()
 	}
+	return "bad JSON"
+}
 
-	// Get rank for each CPE
-	result := make([][2]interface{}, 0, len(cpeMap))
-	for cpe := range cpeMap {
-		rank, err := rdb.ZScore(ctx, "rank:cpe", cpe).Result()
-		if err == redis.Nil {
-			// If no rank, use 0
-			result = append(result, [2]interface{}{0.0, cpe})
-		} else if err != nil {
-			return nil, err
-		} else {
-			result = append(result, [2]interface{}{rank, cpe})
+// queryTerms is a /search query: an array of words or of weighted terms,
+// {"term": "tomcat", "weight": 2}, which may be mixed. Words and terms
+// without a weight weigh 1.
+type queryTerms struct {
+	Words []string
+	// Weights is nil when no term carries a weight.
+	Weights []float64
+}
+
+func (q *queryTerms) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	q.Words, q.Weights = make([]string, len(items)), nil
+	weights := make([]float64, len(items))
+	for i, item := range items {
+		weights[i] = 1
+		if err := json.Unmarshal(item, &q.Words[i]); err == nil {
+			continue
+		}
+		var term struct {
+			Term   string   `json:"term"`
+			Weight *float64 `json:"weight"`
+		}
+		if err := json.Unmarshal(item, &term); err != nil || term.Term == "" {
+			return fmt.Errorf("query term %d must be a string or a {term, weight} object", i)
+		}
+		q.Words[i] = term.Term
+		if term.Weight != nil {
+			weights[i] = *term.Weight
+			q.Weights = weights
 		}
 	}
+	return nil
+}
 
-	// Sort by rank (highest first)
-	sort.Slice(result, func(i, j int) bool {
-		return result[i][0].(float64) > result[j][0].(float64)
-	})
+// validate checks that every weight is positive.
+func (q queryTerms) validate() error {
+	for i, w := range q.Words {
+		if q.Weights != nil && q.Weights[i] <= 0 {
+			return fmt.Errorf("weight of %q must be positive", w)
+		}
+	}
+	return nil
+}
+
+// recordQuery counts the searched words for /popular when query analytics
+// are enabled. The write happens in the background so it never delays the
+// response.
+func (s *serverState) recordQuery(words []string) {
+	if !s.cfg.Server.QueryAnalytics || s.rdb == nil || len(words) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		pipe := s.rdb.Pipeline()
+		for _, w := range words {
+			pipe.ZIncrBy(ctx, "qstat:terms", 1, guesser.Normalize(w))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			slog.Warn("Could not record query analytics", "err", err)
+		}
+	}()
+}
+
+// logSlowQuery reports a request that took longer than the configured
+// slow query threshold.
+func (s *serverState) logSlowQuery(start time.Time, endpoint string, words []string, path string, count int) {
+	threshold := s.cfg.Server.SlowQueryThreshold
+	elapsed := time.Since(start)
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+	slowQueries.Add(1)
+	slog.Warn("Slow query", "endpoint", endpoint, "duration", elapsed, "path", path, "results", count, "query", words)
+}
+
+// Result shapes accepted by the /search format option.
+const (
+	formatCompact = "compact"
+	formatObject  = "object"
+)
+
+// mediaTypeV2 is the media type of version 2 of the /search response, which
+// defaults to the object format.
+const mediaTypeV2 = "application/vnd.cpe-guesser.v2+json"
 
-	return result, nil
+// acceptsV2 reports whether the Accept header of r asks for mediaTypeV2.
+func acceptsV2(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt, _, err := mime.ParseMediaType(accept); err == nil && mt == mediaTypeV2 {
+			return true
+		}
+	}
+	return false
+}
+
+// formatResults shapes res for the response: [rank, cpe] tuples for
+// formatCompact or {"rank", "cpe", "vendor", "product"} objects for
+// formatObject.
+func formatResults(format string, res []guesser.Result) (interface{}, error) {
+	switch format {
+	case "", formatCompact:
+		return tuples(res), nil
+	case formatObject:
+		return res, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
 }
 
 func handleSearch(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
 	var req struct {
-		Query []string `json:"query"`
+		Query          queryTerms `json:"query"`
+		MinRank        *float64   `json:"min_rank"`
+		Anchored       bool       `json:"anchored"`
+		Format         string     `json:"format"`
+		Distinct       string     `json:"distinct"`
+		Scoring        string     `json:"scoring"`
+		Titles         *bool      `json:"titles"`
+		Strategy       string     `json:"strategy"`
+		References     bool       `json:"references"`
+		PartPriority   []string   `json:"part_priority"`
+		Part           string     `json:"part"`
+		Related        bool       `json:"related"`
+		DisablePartial *bool      `json:"disable_partial"`
+		TimeBudget     string     `json:"time_budget"`
+		FlagSubstrings *bool      `json:"flag_substrings"`
+		Binding        string     `json:"binding"`
+		Limit          *int       `json:"limit"`
+		Offset         int        `json:"offset"`
+		// ExcludeDeprecated overrides server.exclude_deprecated
+		ExcludeDeprecated *bool `json:"exclude_deprecated"`
+		CVEs              bool  `json:"cves"`
+		// Fuzzy and FuzzyDistance override server.fuzzy and
+		// server.fuzzy_distance
+		Fuzzy         *bool `json:"fuzzy"`
+		FuzzyDistance *int  `json:"fuzzy_distance"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad JSON", http.StatusBadRequest)
+	if err := decodeRequest(r, &req, &req.Query); err != nil {
+		http.Error(w, decodeError(r, err), http.StatusBadRequest)
+		return
+	}
+	if err := req.Query.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	words := req.Query.Words
+
+	limit := st.cfg.GetDefaultLimit()
+	if req.Limit != nil {
+		limit = *req.Limit
+	}
+	if limit < 0 || req.Offset < 0 {
+		http.Error(w, "limit and offset must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.Distinct != "" && req.Distinct != "product" {
+		http.Error(w, fmt.Sprintf("unknown distinct mode %q", req.Distinct), http.StatusBadRequest)
+		return
+	}
+	scoring := st.cfg.Server.Scoring
+	if req.Query.Weights != nil {
+		// Weights only matter once coverage is scored
+		scoring = "coverage"
+	}
+	if req.Scoring != "" {
+		scoring = req.Scoring
+	}
+	if scoring != "" && scoring != "rank" && scoring != "coverage" && scoring != "idf" {
+		http.Error(w, fmt.Sprintf("unknown scoring %q", scoring), http.StatusBadRequest)
+		return
+	}
+	strategy, err := guesser.ParseStrategy(req.Strategy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	binding, err := guesser.ParseBinding(req.Binding)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	partPriority := st.cfg.Server.PartPriority
+	if req.PartPriority != nil {
+		partPriority = req.PartPriority
+	}
+	for _, p := range partPriority {
+		if p != "a" && p != "o" && p != "h" {
+			http.Error(w, fmt.Sprintf("unknown CPE part %q", p), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Part != "" && req.Part != "a" && req.Part != "o" && req.Part != "h" {
+		http.Error(w, fmt.Sprintf("unknown CPE part %q", req.Part), http.StatusBadRequest)
+		return
+	}
+	st.recordQuery(words)
+
+	disablePartial := st.cfg.Server.DisablePartial
+	if req.DisablePartial != nil {
+		disablePartial = *req.DisablePartial
+	}
+	budget := st.cfg.Server.TimeBudget
+	if req.TimeBudget != "" {
+		budget, err = time.ParseDuration(req.TimeBudget)
+		if err != nil || budget < 0 {
+			http.Error(w, fmt.Sprintf("invalid time_budget %q", req.TimeBudget), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fuzzy := 0
+	if req.Fuzzy != nil && *req.Fuzzy || req.Fuzzy == nil && st.cfg.Server.Fuzzy {
+		fuzzy = st.cfg.GetFuzzyDistance()
+		if req.FuzzyDistance != nil {
+			fuzzy = *req.FuzzyDistance
+		}
+	}
+	if fuzzy < 0 {
+		http.Error(w, "fuzzy_distance must not be negative", http.StatusBadRequest)
 		return
 	}
 
-	res, err := exactSearch(req.Query)
+	start := time.Now()
+	res, path, err := st.gs.Search(r.Context(), words, guesser.SearchOptions{
+		Strategy:       strategy,
+		Anchored:       req.Anchored,
+		DisablePartial: disablePartial,
+		Weights:        req.Query.Weights,
+		Fuzzy:          fuzzy,
+		Budget:         budget,
+		Part:           req.Part,
+	})
+	partialResults := errors.Is(err, guesser.ErrPartialResults)
+	if partialResults {
+		w.Header().Set("X-Partial-Results", "true")
+		err = nil
+	}
+	if errors.Is(err, guesser.ErrTooManyWords) {
+		http.Error(w, err.Error()+"; use the exact_only strategy or fewer words", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if len(res) == 0 {
-		res, err = partialSearch(req.Query)
+	partialSkipped := disablePartial && strategy != guesser.ExactOnly && len(res) == 0
+	if partialSkipped {
+		w.Header().Set("X-Partial-Skipped", "true")
+	}
+	flagSubstrings := st.cfg.Server.FlagSubstrings
+	if req.FlagSubstrings != nil {
+		flagSubstrings = *req.FlagSubstrings
+	}
+	if flagSubstrings && path == "partial" {
+		st.gs.MarkSubstringOnly(res, words)
+	}
+	minRank := st.cfg.Server.MinRank
+	if req.MinRank != nil {
+		minRank = *req.MinRank
+	}
+	res = guesser.FilterMinRank(res, minRank)
+	excludeDeprecated := st.cfg.Server.ExcludeDeprecated
+	if req.ExcludeDeprecated != nil {
+		excludeDeprecated = *req.ExcludeDeprecated
+	}
+	if excludeDeprecated {
+		if res, err = st.gs.ExcludeDeprecated(r.Context(), res); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if scoring == "coverage" {
+		weight := st.cfg.Server.CoverageWeight
+		if req.Query.Weights != nil && weight == 0 {
+			// Scoring by rank alone would ignore the term weights
+			weight = 1
+		}
+		guesser.ScoreByCoverage(res, weight)
+	}
+	if scoring == "idf" {
+		if err := st.gs.ScoreByIDF(r.Context(), res, words, req.Query.Weights); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	guesser.SortByPartPriority(res, partPriority)
+	if req.Distinct == "product" {
+		res = guesser.DistinctProducts(res)
+	}
+
+	format := st.cfg.Server.ResponseFormat
+	if acceptsV2(r) {
+		format = formatObject
+		w.Header().Set("Content-Type", mediaTypeV2)
+	}
+	if req.Format != "" {
+		format = req.Format
+	}
+	// Object results carry the titles when the import indexed them
+	titles := st.cfg.CPE.IndexTitles && format == formatObject
+	if req.Titles != nil {
+		titles = *req.Titles
+	}
+	if (titles || req.References || req.CVEs) && format != formatObject {
+		http.Error(w, "titles, references and cves require the object format", http.StatusBadRequest)
+		return
+	}
+	if req.CVEs && st.cves == nil {
+		http.Error(w, "CVE enrichment is not enabled", http.StatusBadRequest)
+		return
+	}
+	var related []guesser.Result
+	if req.Related && len(res) > 0 {
+		related, err = st.gs.Related(r.Context(), res[0].CPE, maxRelated)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
-	json.NewEncoder(w).Encode(res)
+	// Page before the per-result lookups below
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(res)))
+	res = guesser.Paginate(res, req.Offset, limit)
+	if titles {
+		if err := st.gs.Titles(r.Context(), res); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.References {
+		if err := st.gs.References(r.Context(), res); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.CVEs {
+		st.lookupCVEs(r.Context(), res)
+	}
+	if format == formatObject && !excludeDeprecated {
+		if err := st.gs.Deprecations(r.Context(), res); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Convert last, the steps above need the stored formatted strings
+	guesser.SetVendorProduct(res)
+	guesser.SetVendorProduct(related)
+	if err := guesser.RebindResults(res, binding); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := guesser.RebindResults(related, binding); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := formatResults(format, res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Related || req.TimeBudget != "" || partialResults || partialSkipped {
+		// Extras go next to the results so their shape is unchanged
+		body := map[string]interface{}{"results": out}
+		if req.Related {
+			body["related"], _ = formatResults(format, related)
+		}
+		// Whichever budget cut the scan short, the request's or
+		// server.time_budget
+		if req.TimeBudget != "" || partialResults {
+			body["partial_results"] = partialResults
+		}
+		if partialSkipped {
+			body["partial_skipped"] = true
+		}
+		st.logSlowQuery(start, "/search", words, path, len(res))
+		setResultCount(r, len(res))
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	st.logSlowQuery(start, "/search", words, path, len(res))
+	setResultCount(r, len(res))
+	json.NewEncoder(w).Encode(out)
 }
 
 func handleUnique(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
+	var req api.UniqueRequest
+	if err := decodeRequest(r, &req, &req.Query); err != nil {
+		http.Error(w, decodeError(r, err), http.StatusBadRequest)
+		return
+	}
+
+	st.recordQuery(req.Query)
+
+	start := time.Now()
+	cpe, err := st.gs.Unique(r.Context(), req.Query)
+	count := 0
+	if cpe != "" {
+		count = 1
+	}
+	st.logSlowQuery(start, "/unique", req.Query, "exact_then_partial", count)
+	setResultCount(r, count)
+	if errors.Is(err, guesser.ErrTooManyWords) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == nil && cpe != "" {
+		if req.Version != nil && *req.Version != "" {
+			full, err := st.gs.Version(r.Context(), cpe, *req.Version)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if full != "" {
+				cpe = full
+			} else {
+				w.Header().Set("X-Version-Unknown", "true")
+			}
+		}
+		json.NewEncoder(w).Encode(cpe)
+		return
+	}
+	json.NewEncoder(w).Encode([]string{})
+}
+
+func handleUniqueBatch(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
+	var req struct {
+		Queries [][]string `json:"queries"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Queries) > maxBatchQueries {
+		http.Error(w, fmt.Sprintf("too many queries (max %d)", maxBatchQueries), http.StatusBadRequest)
+		return
+	}
+
+	cpes, err := st.gs.UniqueBatch(r.Context(), req.Queries)
+	if err != nil {
+		slog.Warn("Batch unique lookup failed", "err", err)
+	}
+
+	// Queries without a match are reported as null
+	res := make([]*string, len(cpes))
+	matched := 0
+	for i := range cpes {
+		if cpes[i] != "" {
+			res[i] = &cpes[i]
+			matched++
+		}
+	}
+	setResultCount(r, matched)
+	json.NewEncoder(w).Encode(res)
+}
+
+// maxCVELookups is the number of CVE lookups run at once for a search.
+const maxCVELookups = 4
+
+// lookupCVEs sets the CVE summary of each result. A failed lookup is logged
+// and leaves the result without one, so the search still answers when the
+// CVE instance is down.
+func (s *serverState) lookupCVEs(ctx context.Context, res []guesser.Result) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxCVELookups)
+	for i := range res {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *guesser.Result) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			sum, err := s.cves.Lookup(ctx, r.CPE)
+			if err != nil {
+				slog.Warn("CVE lookup failed", "cpe", r.CPE, "err", err)
+				return
+			}
+			r.CVEs = &guesser.CVEs{Count: sum.Count, Latest: sum.Latest}
+		}(&res[i])
+	}
+	wg.Wait()
+}
+
+// handlePURL maps each of a list of package URLs to candidate CPE lines,
+// along with the full CPE at the package version when the index knows it.
+func handlePURL(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
 	var req struct {
-		Query []string `json:"query"`
+		PURLs []string `json:"purls"`
+		Limit int      `json:"limit"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "bad JSON", http.StatusBadRequest)
 		return
 	}
+	if len(req.PURLs) > maxBatchQueries {
+		http.Error(w, fmt.Sprintf("too many purls (max %d)", maxBatchQueries), http.StatusBadRequest)
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultPURLResults
+	}
+
+	type match struct {
+		PURL    string           `json:"purl"`
+		Source  string           `json:"source,omitempty"`
+		CPE     string           `json:"cpe,omitempty"`
+		Results []guesser.Result `json:"results"`
+		Error   string           `json:"error,omitempty"`
+	}
+	out := make([]match, len(req.PURLs))
+	matched := 0
+	for i, s := range req.PURLs {
+		out[i] = match{PURL: s, Results: []guesser.Result{}}
+		p, err := guesser.ParsePURL(s)
+		if err != nil {
+			out[i].Error = err.Error()
+			continue
+		}
+		res, source, err := st.gs.PURL(r.Context(), p, req.Limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out[i].Source = source
+		if len(res) > 0 && p.Version != "" {
+			full, err := st.gs.Version(r.Context(), res[0].CPE, p.Version)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out[i].CPE = full
+		}
+		guesser.SetVendorProduct(res)
+		if res != nil {
+			out[i].Results = res
+		}
+		if len(res) > 0 {
+			matched++
+		}
+	}
+
+	setResultCount(r, matched)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func handleProducts(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
+	vendor := r.URL.Query().Get("vendor")
+	if vendor == "" {
+		http.Error(w, "missing vendor", http.StatusBadRequest)
+		return
+	}
+	order := r.URL.Query().Get("sort")
+	if order != "" && order != "rank" && order != "name" {
+		http.Error(w, fmt.Sprintf("unknown sort %q", order), http.StatusBadRequest)
+		return
+	}
 
-	res, err := exactSearch(req.Query)
-	if err == nil && len(res) > 0 {
-		json.NewEncoder(w).Encode(res[0][1])
+	res, err := st.gs.Products(r.Context(), vendor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	res, err = partialSearch(req.Query)
-	if err == nil && len(res) > 0 {
-		json.NewEncoder(w).Encode(res[0][1])
+
+	type product struct {
+		Product string  `json:"product"`
+		CPE     string  `json:"cpe"`
+		Rank    float64 `json:"rank"`
+	}
+	out := make([]product, 0, len(res))
+	for _, p := range guesser.DistinctProducts(res) {
+		name := p.CPE
+		if parts := guesser.SplitCPE(p.CPE); len(parts) >= 5 {
+			name = guesser.Unescape(parts[4])
+		}
+		out = append(out, product{Product: name, CPE: p.CPE, Rank: p.Rank})
+	}
+	if order == "name" {
+		sort.Slice(out, func(i, j int) bool {
+			return out[i].Product < out[j].Product
+		})
+	}
+
+	setResultCount(r, len(out))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleVendor lists every product of the vendor named in the path,
+// /vendor/<vendor>, alphabetically, with the CPE prefixes of all its parts.
+func handleVendor(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
+	vendor := strings.TrimPrefix(r.URL.Path, "/vendor/")
+	if vendor == "" || strings.Contains(vendor, "/") {
+		http.Error(w, "use /vendor/<vendor>", http.StatusBadRequest)
 		return
 	}
-	json.NewEncoder(w).Encode([]string{})
+
+	res, err := st.gs.Products(r.Context(), vendor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(res) == 0 {
+		http.Error(w, fmt.Sprintf("no products indexed for vendor %q", vendor), http.StatusNotFound)
+		return
+	}
+
+	type product struct {
+		Product string `json:"product"`
+		// Prefixes are the CPE prefixes of the product, one per part,
+		// highest rank first
+		Prefixes []string `json:"prefixes"`
+		Rank     float64  `json:"rank"`
+	}
+	var out []*product
+	byName := make(map[string]*product)
+	for _, p := range res {
+		parts := guesser.SplitCPE(p.CPE)
+		if len(parts) < 5 {
+			continue
+		}
+		name := guesser.Unescape(parts[4])
+		prod, ok := byName[name]
+		if !ok {
+			prod = &product{Product: name, Rank: p.Rank}
+			byName[name] = prod
+			out = append(out, prod)
+		}
+		prod.Prefixes = append(prod.Prefixes, p.CPE)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Product < out[j].Product
+	})
+
+	setResultCount(r, len(out))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"vendor":   vendor,
+		"products": out,
+	})
+}
+
+// handleAutocomplete completes the word q to indexed words and to the CPE
+// lines whose vendor or product starts with it, for interactive clients.
+func handleAutocomplete(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "missing q", http.StatusBadRequest)
+		return
+	}
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			http.Error(w, "n must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	words, res, err := st.gs.Autocomplete(r.Context(), q, n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	guesser.SetVendorProduct(res)
+	if words == nil {
+		words = []guesser.Completion{}
+	}
+	if res == nil {
+		res = []guesser.Result{}
+	}
+
+	setResultCount(r, len(words)+len(res))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Words    []guesser.Completion `json:"words"`
+		Products []guesser.Result     `json:"products"`
+	}{words, res})
+}
+
+// handleCPE returns what the index stores about the CPE line of the CPE name
+// in the path, /cpe/<cpe>: its rank, title, reference URLs and deprecation.
+func handleCPE(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
+	name := strings.TrimPrefix(r.URL.Path, "/cpe/")
+	_, vendor, product, _, line := extract(name)
+	if !strings.HasPrefix(name, "cpe:2.3:") || vendor == "" || product == "" {
+		http.Error(w, "use /cpe/<CPE 2.3 name>, such as /cpe/cpe:2.3:a:apache:tomcat", http.StatusBadRequest)
+		return
+	}
+
+	res, err := st.gs.Lookup(r.Context(), line)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if res == nil {
+		http.Error(w, fmt.Sprintf("CPE %q is not indexed", line), http.StatusNotFound)
+		return
+	}
+	out := []guesser.Result{*res}
+	guesser.SetVendorProduct(out)
+
+	setResultCount(r, 1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out[0])
+}
+
+func handlePopular(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
+	if !st.cfg.Server.QueryAnalytics || st.rdb == nil {
+		http.Error(w, "query analytics disabled", http.StatusNotFound)
+		return
+	}
+
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "n must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+	}
+
+	terms, err := st.rdbRead.ZRevRangeWithScores(r.Context(), "qstat:terms", 0, int64(n-1)).Result()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type popular struct {
+		Term  string `json:"term"`
+		Count int64  `json:"count"`
+	}
+	res := make([]popular, len(terms))
+	for i, t := range terms {
+		res[i] = popular{Term: t.Member.(string), Count: int64(t.Score)}
+	}
+	setResultCount(r, len(res))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// ping checks the Redis connections of s, if any.
+func (s *serverState) ping(ctx context.Context) error {
+	if s.rdb != nil {
+		if err := s.rdb.Ping(ctx).Err(); err != nil {
+			return errors.New("Redis connection failed")
+		}
+	}
+	if s.rdbRead != s.rdb {
+		if err := s.rdbRead.Ping(ctx).Err(); err != nil {
+			return errors.New("Redis read replica connection failed")
+		}
+	}
+	return nil
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
 	// Check Redis connection
-	_, err := rdb.Ping(ctx).Result()
-	if err != nil {
-		http.Error(w, "Redis connection failed", http.StatusServiceUnavailable)
+	if err := st.ping(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
 	// Return health status
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "healthy",
-		"time":   time.Now().Format(time.RFC3339),
+	json.NewEncoder(w).Encode(api.Health{
+		Status:    "healthy",
+		Time:      time.Now().Format(time.RFC3339),
+		Version:   version,
+		Commit:    commit,
+		BuildDate: date,
+		GoVersion: runtime.Version(),
+		Uptime:    time.Since(startTime).Round(time.Second).String(),
 	})
 }
 
-func runServer() {
-	// Define command line flags
-	port := flag.String("port", "", "Port to listen on (overrides config)")
-	redisHost := flag.String("redis", "", "Redis host:port (overrides config)")
-	configPath := flag.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
-
-	// Parse flags
-	flag.Parse()
+// handleOpenAPI serves the OpenAPI document of the HTTP API.
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	spec, err := api.SpecJSON()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
 
-	// Load config based on flag
-	var err error
-	cfg, err = config.Load(*configPath)
+// loadConfig loads and validates the config of configPath, exiting on any
+// problem.
+func loadConfig(configPath string) *config.Config {
+	c, err := config.Load(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if err := c.Validate(); err != nil {
+		log.Fatalf("Invalid config:\n%v", err)
+	}
+	return c
+}
 
-	// Use command line flags if provided, otherwise use config
-	serverPort := cfg.Server.Port
-	if *port != "" {
-		serverPort = 8000 // Default if parsing fails
-		if _, err := fmt.Sscanf(*port, "%d", &serverPort); err != nil {
-			log.Printf("Invalid port number, using default: %d", serverPort)
+// newRedisClient returns a client for database db at addr, with the
+// credentials and TLS settings of the valkey section of c. With
+// valkey.cluster it returns a cluster client discovering the cluster from
+// addr, and db is ignored.
+func newRedisClient(c *config.Config, addr string, db int) redis.UniversalClient {
+	var tc *tls.Config
+	if c.Valkey.TLS {
+		var err error
+		tc, err = valkeyTLSConfig(c, addr)
+		if err != nil {
+			log.Fatalf("Failed to set up Valkey TLS: %v", err)
 		}
 	}
+	if c.Valkey.Cluster {
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     []string{addr},
+			Username:  c.Valkey.Username,
+			Password:  c.GetValkeyPassword(),
+			PoolSize:  20,
+			TLSConfig: tc,
+		})
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:      addr,
+		DB:        db,
+		Username:  c.Valkey.Username,
+		Password:  c.GetValkeyPassword(),
+		PoolSize:  20,
+		TLSConfig: tc,
+	})
+}
+
+// sameValkeyOptions reports whether the clients of a and b connect with the
+// same credentials, databases, TLS and cluster settings, so one can be reused
+// for the other.
+func sameValkeyOptions(a, b *config.Config) bool {
+	return a.Valkey.Username == b.Valkey.Username &&
+		a.GetValkeyPassword() == b.GetValkeyPassword() &&
+		a.GetIndexDB() == b.GetIndexDB() &&
+		a.GetAPIKeysDB() == b.GetAPIKeysDB() &&
+		a.Valkey.TLS == b.Valkey.TLS &&
+		a.Valkey.TLSCAFile == b.Valkey.TLSCAFile &&
+		a.Valkey.Cluster == b.Valkey.Cluster
+}
 
-	redisAddr := cfg.GetRedisAddr()
-	if *redisHost != "" {
-		redisAddr = *redisHost
+// reloadOnHangup reloads the configuration from configPath, with the
+// synonyms file it names, whenever the process receives SIGHUP. Settings
+// read per request, such as the search options, thresholds, aliases and Redis
+// endpoints, take effect immediately while in-flight requests finish with
+// the previous ones; the listening ports, TLS, storage and tracing only
+// change on restart.
+func reloadOnHangup(configPath, redisOverride string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		stateMu.Lock()
+		reloadConfig(configPath, redisOverride)
+		stateMu.Unlock()
+	}
+}
+
+// reloadConfig replaces the server state with one of the configuration at
+// configPath, keeping the current one when it is invalid.
+func reloadConfig(configPath, redisOverride string) {
+	newCfg, err := config.Load(configPath)
+	if err == nil {
+		err = newCfg.Validate()
+	}
+	if err != nil {
+		slog.Warn("Config reload failed, keeping the current config", "err", err)
+		return
+	}
+
+	old := state.Load()
+	if newCfg.Server.Port != old.cfg.Server.Port {
+		slog.Warn("server.port change is ignored until restart")
+	}
+	if newCfg.Server.GRPCPort != old.cfg.Server.GRPCPort {
+		slog.Warn("server.grpc_port change is ignored until restart")
+	}
+	if newCfg.Server.TLS != old.cfg.Server.TLS {
+		slog.Warn("server.tls changes are ignored until restart")
+	}
+	if newCfg.Tracing != old.cfg.Tracing {
+		slog.Warn("tracing changes are ignored until restart")
 	}
+	if newCfg.Server.ReimportInterval != old.cfg.Server.ReimportInterval {
+		slog.Warn("server.reimport_interval change is ignored until restart")
+	}
+	if newCfg.Storage != old.cfg.Storage {
+		slog.Warn("storage changes are ignored until restart")
+		newCfg.Storage = old.cfg.Storage
+	}
+	if newCfg.Tokenize != old.cfg.Tokenize || !reflect.DeepEqual(newCfg.Stopwords, old.cfg.Stopwords) {
+		slog.Warn("tokenize and stopwords changes apply to queries now but to the index only after a new import")
+	}
+
+	st := newServerState(newCfg, redisOverride, old)
+	state.Store(st)
+	slog.Info("Reloaded config")
+
+	// Give in-flight requests time to finish before closing replaced clients
+	var stale []redis.UniversalClient
+	if old.rdb != st.rdb {
+		stale = append(stale, old.rdb)
+	}
+	if old.rdbRead != old.rdb && old.rdbRead != st.rdbRead {
+		stale = append(stale, old.rdbRead)
+	}
+	if old.keys != nil && old.keys.rdb != nil && (st.keys == nil || st.keys.rdb != old.keys.rdb) {
+		stale = append(stale, old.keys.rdb)
+	}
+	if len(stale) > 0 {
+		time.AfterFunc(time.Minute, func() {
+			for _, c := range stale {
+				c.Close()
+			}
+		})
+	}
+}
+
+func runServer(fs *flag.FlagSet) func() {
+	// Define command line flags
+	port := fs.String("port", "", "Port to listen on (overrides config)")
+	redisHost := fs.String("redis", "", "Redis host:port (overrides config)")
+	configPath := fs.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
+
+	return func() {
+		// Load config based on flag
+		cfg = loadConfig(*configPath)
 
-	// Initialize Redis client
-	rdb = redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		DB:       8,
-		PoolSize: 20,
+		// Use command line flags if provided, otherwise use config
+		serverPort := cfg.Server.Port
+		if *port != "" {
+			serverPort = 8000 // Default if parsing fails
+			if _, err := fmt.Sscanf(*port, "%d", &serverPort); err != nil {
+				slog.Warn("Invalid port number, using default", "port", serverPort)
+			}
+		}
+
+		// An import -swap renames a new index file over the one served,
+		// which is identified before it is opened
+		var indexInfo os.FileInfo
+		if cfg.Storage.Backend == config.BackendBolt || cfg.Storage.Backend == config.BackendSQLite {
+			indexInfo, _ = os.Stat(cfg.GetStoragePath())
+		}
+
+		// Initialize Redis clients
+		st := newServerState(cfg, *redisHost, nil)
+		if st.readAddr != "" {
+			slog.Info("Redis read replica", "addr", st.readAddr)
+		}
+		state.Store(st)
+		go reloadOnHangup(*configPath, *redisHost)
+		if indexInfo != nil {
+			go watchIndexFile(cfg.GetStoragePath(), *redisHost, indexInfo)
+		}
+
+		// Imports run on a schedule or on request of the admin endpoints
+		if cfg.Storage.Backend != config.BackendMemory {
+			var err error
+			if reimports, err = newReimporter(*configPath, *redisHost, cfg); err != nil {
+				log.Fatalf("Failed to set up imports: %v", err)
+			}
+			if interval := cfg.Server.ReimportInterval; interval > 0 {
+				slog.Info("Scheduled imports", "interval", interval)
+				go reimports.schedule(interval)
+			}
+		}
+
+		// Create server
+		mux := http.NewServeMux()
+		mux.Handle("/search", requireAPIKey(handleSearch))
+		mux.Handle("/unique", requireAPIKey(handleUnique))
+		mux.Handle("/unique/batch", requireAPIKey(handleUniqueBatch))
+		mux.Handle("/purl", requireAPIKey(handlePURL))
+		mux.Handle("/sbom", requireAPIKey(handleSBOM))
+		mux.Handle("/spdx", requireAPIKey(handleSPDX))
+		mux.Handle("/products", requireAPIKey(handleProducts))
+		mux.Handle("/vendor/", requireAPIKey(handleVendor))
+		mux.Handle("/cpe/", requireAPIKey(handleCPE))
+		mux.Handle("/popular", requireAPIKey(handlePopular))
+		mux.Handle("/autocomplete", requireAPIKey(handleAutocomplete))
+		mux.Handle("/admin/reimport", requireAdminKey(handleReimport))
+		mux.Handle("/admin/import-status", requireAdminKey(handleImportStatus))
+		mux.HandleFunc("/health", handleHealth)
+		mux.HandleFunc("/openapi.json", handleOpenAPI)
+		mux.Handle("/debug/vars", expvar.Handler())
+		expvar.Publish("query_cache", expvar.Func(func() any {
+			if c := state.Load().cache; c != nil {
+				return c.Stats()
+			}
+			return nil
+		}))
+
+		handler := logRequests(withDeadline(withCORS(mux), writeTimeout))
+		if cfg.Tracing.Enabled {
+			shutdown, err := tracing.Setup(ctx, cfg.Tracing.Endpoint, cfg.Tracing.Insecure)
+			if err != nil {
+				log.Fatalf("Failed to set up tracing: %v", err)
+			}
+			defer shutdown(ctx)
+			handler = tracing.Middleware(handler)
+		}
+
+		tlsConfig, err := serverTLSConfig(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		srv := &http.Server{
+			Addr:         fmt.Sprintf(":%d", serverPort),
+			Handler:      handler,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: writeTimeout,
+			TLSConfig:    tlsConfig,
+		}
+
+		var gsrv *grpc.Server
+		if cfg.Server.GRPCPort != 0 {
+			lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Server.GRPCPort))
+			if err != nil {
+				log.Fatalf("Failed to listen for gRPC: %v", err)
+			}
+			opts := []grpc.ServerOption{
+				grpc.UnaryInterceptor(grpcUnaryAuth),
+				grpc.StreamInterceptor(grpcStreamAuth),
+			}
+			if tlsConfig != nil {
+				opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+			}
+			gsrv = grpc.NewServer(opts...)
+			guesserpb.RegisterGuesserServer(gsrv, grpcServer{})
+			slog.Info("Starting gRPC server", "port", cfg.Server.GRPCPort)
+			go func() {
+				if err := gsrv.Serve(lis); err != nil {
+					log.Fatalf("gRPC server failed: %v", err)
+				}
+			}()
+		}
+
+		slog.Info("Starting server", "port", serverPort, "tls", tlsConfig != nil)
+		switch {
+		case st.rdb != nil:
+			slog.Info("Redis connection", "addr", st.redisAddr)
+		case cfg.Storage.Backend == config.BackendMemory:
+			slog.Info("Serving the in-memory index")
+		default:
+			slog.Info("Serving the index file", "path", cfg.GetStoragePath())
+		}
+
+		stop, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		errc := make(chan error, 1)
+		go func() {
+			if tlsConfig != nil {
+				// The certificate comes from TLSConfig
+				errc <- srv.ListenAndServeTLS("", "")
+			} else {
+				errc <- srv.ListenAndServe()
+			}
+		}()
+		select {
+		case err := <-errc:
+			log.Fatalf("Server failed: %v", err)
+		case <-stop.Done():
+		}
+		// A second signal kills the process
+		cancel()
+		stopServer(srv, gsrv, reimports, state.Load())
+	}
+}
+
+// writeTimeout bounds the handling of a request. The connection is closed
+// when it passes, so withDeadline also stops the lookups of the request then.
+const writeTimeout = 5 * time.Second
+
+// withDeadline cancels the context of each request handled by next after d,
+// so the index lookups of a request that outlived its timeout stop. The
+// context is also canceled when the client goes away.
+func withDeadline(next http.Handler, d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(rctx))
 	})
+}
+
+// stopServer stops accepting connections, waits for the in-flight requests of
+// srv and gsrv for up to the shutdown timeout of st, then stops the import
+// reimport runs, if any, and closes the clients of st.
+func stopServer(srv *http.Server, gsrv *grpc.Server, reimport *reimporter, st *serverState) {
+	timeout := st.cfg.GetShutdownTimeout()
+	slog.Info("Shutting down", "timeout", timeout)
+	sctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	grpcDone := make(chan struct{})
+	go func() {
+		if gsrv != nil {
+			gsrv.GracefulStop()
+		}
+		close(grpcDone)
+	}()
+	if err := srv.Shutdown(sctx); err != nil {
+		slog.Warn("Requests still in flight at the shutdown timeout", "err", err)
+		srv.Close()
+	}
+	select {
+	case <-grpcDone:
+	case <-sctx.Done():
+		if gsrv != nil {
+			gsrv.Stop()
+		}
+	}
 
-	// Create server
-	mux := http.NewServeMux()
-	mux.HandleFunc("/search", handleSearch)
-	mux.HandleFunc("/unique", handleUnique)
-	mux.HandleFunc("/health", handleHealth)
+	if reimport != nil {
+		reimport.close()
+	}
+	st.close()
+	slog.Info("Server stopped")
+}
+
+// command is a subcommand of the binary. setup defines its flags on fs and
+// returns the function running it once they are parsed, so the flags can
+// also be listed without running anything.
+type command struct {
+	name    string
+	summary string
+	// args are the words accepted as first positional argument.
+	args  []string
+	setup func(fs *flag.FlagSet) func()
+}
+
+// commands returns the subcommands in the order they are listed in the usage.
+func commands() []command {
+	return []command{
+		{name: "server", summary: "Run the HTTP search server", setup: runServer},
+		{name: "import", summary: "Import the CPE dictionary into Valkey", setup: runImport},
+		{name: "snapshot", summary: "Write the indexed CPEs to a file", setup: runSnapshot},
+		{name: "diff", summary: "Compare two snapshots", setup: runDiff},
+		{name: "export", summary: "Write the index to a dump file", setup: runExport},
+		{name: "restore", summary: "Load an index dump written by export", setup: runRestore},
+		{name: "query", summary: "Search the index from the command line", setup: runQuery},
+		{name: "verify", summary: "Check the index for inconsistencies", setup: runVerify},
+		{name: "bench", summary: "Measure search throughput and latency", setup: runBench},
+		{name: "config", summary: "Validate a config file", args: []string{"check"}, setup: runConfig},
+		{name: "completion", summary: "Print a shell completion script", args: completionShells, setup: runCompletion},
+	}
+}
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", serverPort),
-		Handler:      mux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+// usage prints the available subcommands.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", progName)
+	for _, cmd := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", cmd.name, cmd.summary)
 	}
+}
 
-	log.Printf("Starting server on port %d", serverPort)
-	log.Printf("Redis connection: %s", redisAddr)
-	log.Fatal(srv.ListenAndServe())
+// parseArgs parses the flags of fs found anywhere in args, so flags may also
+// follow positional arguments such as the words of a query.
+func parseArgs(fs *flag.FlagSet, args []string) {
+	var pos []string
+	fs.Parse(args)
+	for fs.NArg() > 0 {
+		pos = append(pos, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	fs.Parse(append([]string{"--"}, pos...))
 }
 
 func main() {
 	// Check if command is provided
 	if len(os.Args) < 2 {
-		log.Fatal("Please specify a command: server or import")
+		usage()
+		os.Exit(2)
 	}
 
-	// Get the command and shift arguments
-	command := os.Args[1]
-	os.Args = append(os.Args[:1], os.Args[2:]...)
-
-	// Reset flag parsing for the subcommand
-	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-
 	// Execute the appropriate command
-	switch command {
-	case "server":
-		runServer()
-	case "import":
-		runImport()
-	default:
-		log.Fatalf("Unknown command: %s", command)
+	for _, cmd := range commands() {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+		fs := flag.NewFlagSet(progName+" "+cmd.name, flag.ExitOnError)
+		setupLogging := logFlags(fs)
+		run := cmd.setup(fs)
+		parseArgs(fs, os.Args[2:])
+		setupLogging()
+		run()
+		return
 	}
+	log.Fatalf("Unknown command: %s", os.Args[1])
 }
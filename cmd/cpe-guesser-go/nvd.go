@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+)
+
+// nvdMaxRange is the longest lastModified range the API accepts in one
+// request.
+const nvdMaxRange = 120 * 24 * time.Hour
+
+// nvdTimeLayout formats the lastModified range parameters.
+const nvdTimeLayout = "2006-01-02T15:04:05.000-07:00"
+
+// maxNVDRetries is the number of times a page request refused by the NVD
+// rate limiter, the network or the server is retried.
+const maxNVDRetries = 5
+
+// nvdResponse is a page of the NVD Products API 2.0.
+type nvdResponse struct {
+	TotalResults int `json:"totalResults"`
+	Products     []struct {
+		CPE nvdCPE `json:"cpe"`
+	} `json:"products"`
+}
+
+// nvdCPE maps the parts of an NVD API product the import uses.
+type nvdCPE struct {
+	CPEName      string `json:"cpeName"`
+	Deprecated   bool   `json:"deprecated"`
+	DeprecatedBy []struct {
+		CPEName string `json:"cpeName"`
+	} `json:"deprecatedBy"`
+	Created      string `json:"created"`
+	LastModified string `json:"lastModified"`
+	Titles       []struct {
+		Title string `json:"title"`
+		Lang  string `json:"lang"`
+	} `json:"titles"`
+	Refs []struct {
+		Ref string `json:"ref"`
+	} `json:"refs"`
+}
+
+// entry returns the parts of c the import uses.
+func (c *nvdCPE) entry() *cpeEntry {
+	titles := make([]XMLTitle, len(c.Titles))
+	for i, t := range c.Titles {
+		titles[i] = XMLTitle{Lang: t.Lang, Text: t.Title}
+	}
+	e := &cpeEntry{
+		name:       c.CPEName,
+		title:      englishTitle(titles),
+		created:    parseNVDTime(c.Created),
+		modified:   parseNVDTime(c.LastModified),
+		deprecated: c.Deprecated,
+	}
+	for _, by := range c.DeprecatedBy {
+		e.deprecatedBy = append(e.deprecatedBy, by.CPEName)
+	}
+	for _, ref := range c.Refs {
+		e.refs = append(e.refs, ref.Ref)
+	}
+	return e
+}
+
+// nvdSource pages through the CPEs of the NVD Products API, waiting
+// interval between requests to stay within the rate limit. An incremental
+// import pages through the CPEs modified in each of windows in turn.
+type nvdSource struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	apiKey   string
+	pageSize int
+	interval time.Duration
+	windows  [][2]time.Time
+
+	// page holds the entries of the last page not returned yet
+	page []*cpeEntry
+	// start is the index of the next page, total the number of results
+	// reported by the API, -1 before the first page
+	start, total int
+	// read is the number of entries returned
+	read int
+	last time.Time
+	// inputProgress counts the results fetched
+	inputProgress
+}
+
+func newNVDSource(ctx context.Context, c *config.Config, since time.Time) *nvdSource {
+	s := &nvdSource{
+		ctx:      ctx,
+		client:   newDownloadClient(),
+		url:      c.GetNVDURL(),
+		apiKey:   c.GetNVDAPIKey(),
+		pageSize: c.GetNVDResultsPerPage(),
+		interval: c.GetNVDRequestInterval(),
+		total:    -1,
+	}
+	s.inputProgress.total.Store(-1)
+	if !since.IsZero() {
+		now := time.Now().UTC()
+		for start := since.UTC(); start.Before(now); start = start.Add(nvdMaxRange) {
+			end := start.Add(nvdMaxRange)
+			if end.After(now) {
+				end = now
+			}
+			s.windows = append(s.windows, [2]time.Time{start, end})
+		}
+		if len(s.windows) == 0 {
+			// Nothing can have changed since
+			s.total = 0
+		}
+	}
+	return s
+}
+
+func (s *nvdSource) next() (*cpeEntry, error) {
+	for len(s.page) == 0 {
+		if s.total >= 0 && s.start >= s.total {
+			if len(s.windows) <= 1 {
+				return nil, io.EOF
+			}
+			s.windows = s.windows[1:]
+			s.start, s.total = 0, -1
+		}
+		if err := s.fetch(); err != nil {
+			return nil, err
+		}
+	}
+	e := s.page[0]
+	s.page = s.page[1:]
+	s.read++
+	return e, nil
+}
+
+func (s *nvdSource) pos() string {
+	return fmt.Sprintf("NVD result %d", s.read)
+}
+
+// fetch reads the page at s.start, retrying with a growing delay while it
+// fails.
+func (s *nvdSource) fetch() error {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return fmt.Errorf("invalid NVD API URL %q: %w", s.url, err)
+	}
+	q := u.Query()
+	q.Set("resultsPerPage", strconv.Itoa(s.pageSize))
+	q.Set("startIndex", strconv.Itoa(s.start))
+	if len(s.windows) > 0 {
+		q.Set("lastModStartDate", s.windows[0][0].Format(nvdTimeLayout))
+		q.Set("lastModEndDate", s.windows[0][1].Format(nvdTimeLayout))
+	}
+	u.RawQuery = q.Encode()
+
+	wait := s.interval - time.Since(s.last)
+	for attempt := 0; ; attempt++ {
+		if err := sleepContext(s.ctx, wait); err != nil {
+			return err
+		}
+		s.last = time.Now()
+		page, status, err := s.get(u.String())
+		if err == nil {
+			s.add(page)
+			return nil
+		}
+		// Network errors and the statuses of the rate limiter and of an
+		// overloaded server are worth another try
+		retry := status == 0 && s.ctx.Err() == nil ||
+			status == http.StatusForbidden || status == http.StatusTooManyRequests || status >= 500
+		if !retry || attempt == maxNVDRetries {
+			return err
+		}
+		wait = s.interval << attempt
+		slog.Warn("NVD request failed, retrying", "err", err, "wait", wait)
+	}
+}
+
+// get requests one page, returning the HTTP status along with any error.
+func (s *nvdSource) get(rawURL string) (*nvdResponse, int, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.apiKey != "" {
+		req.Header.Set("apiKey", s.apiKey)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	var page nvdResponse
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("decoding NVD API page: %w", err)
+	}
+	return &page, resp.StatusCode, nil
+}
+
+// add queues the entries of page and moves on to the next one.
+func (s *nvdSource) add(page *nvdResponse) {
+	for i := range page.Products {
+		if page.Products[i].CPE.CPEName != "" {
+			s.page = append(s.page, page.Products[i].CPE.entry())
+		}
+	}
+	s.total = page.TotalResults
+	s.start += len(page.Products)
+	// An empty page would never reach the total
+	if len(page.Products) == 0 {
+		s.total = s.start
+	}
+	// The total of an incremental import is only known for its last
+	// window
+	s.inputProgress.read.Store(int64(s.start))
+	if len(s.windows) <= 1 {
+		s.inputProgress.total.Store(int64(s.total))
+	}
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// importOut is where the import writes its messages: stdout, or stderr with
+// -json, which leaves stdout to the JSON summary.
+var importOut io.Writer = os.Stdout
+
+// progressInterval is the least time between two progress lines.
+const progressInterval = time.Second
+
+// inputProgress tracks how much of its input a source has read, in bytes or
+// entries, for the import progress. total is -1 when unknown.
+type inputProgress struct {
+	read, total atomic.Int64
+}
+
+func (p *inputProgress) inputRead() (read, total int64) {
+	return p.read.Load(), p.total.Load()
+}
+
+// progressSource is an input telling how much of it was read.
+type progressSource interface {
+	inputRead() (read, total int64)
+}
+
+// countingReader counts the bytes read through it into p.
+type countingReader struct {
+	r io.Reader
+	p *inputProgress
+}
+
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.p.read.Add(int64(n))
+	return n, err
+}
+
+// countingFile counts the bytes read from a dictionary file into p, by
+// reads and by the random access of zip archives.
+type countingFile struct {
+	*os.File
+	p *inputProgress
+}
+
+func (f *countingFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	f.p.read.Add(int64(n))
+	return n, err
+}
+
+func (f *countingFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(b, off)
+	f.p.read.Add(int64(n))
+	return n, err
+}
+
+// fileSource is the entry source of a dictionary file, counting the bytes
+// read from it.
+type fileSource struct {
+	entrySource
+	inputProgress
+}
+
+// progressReporter prints the progress of an import to w: the entries
+// indexed, the rate and, when the size of the input is known, the share read
+// and the time left. It prints nothing when w is nil.
+type progressReporter struct {
+	w io.Writer
+	// src tells how much of the input was read; nil when unknown
+	src   progressSource
+	start time.Time
+	last  time.Time
+}
+
+// report prints the progress after items entries giving words words, at
+// most once per progressInterval.
+func (p *progressReporter) report(items, words int) {
+	now := time.Now()
+	if p.w == nil || now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+	elapsed := now.Sub(p.start)
+	rate := float64(items) / elapsed.Seconds()
+	if p.src != nil {
+		if read, total := p.src.inputRead(); read > 0 && total > 0 {
+			done := min(float64(read)/float64(total), 1)
+			eta := time.Duration(float64(elapsed) * (1 - done) / done)
+			fmt.Fprintf(p.w, "... %.1f%% %d items (%d words) in %s, %.0f items/s, ETA %s\n",
+				done*100, items, words, elapsed.Round(time.Second), rate, eta.Round(time.Second))
+			return
+		}
+	}
+	fmt.Fprintf(p.w, "... %d items (%d words) in %s, %.0f items/s\n", items, words, elapsed.Round(time.Second), rate)
+}
+
+// importSummary is the summary of an import printed with -json.
+type importSummary struct {
+	Items          int      `json:"items"`
+	Words          int      `json:"words"`
+	Lines          int      `json:"lines"`
+	Duplicates     int      `json:"duplicates"`
+	RankPolicy     string   `json:"rank_policy"`
+	SkippedParts   int      `json:"skipped_parts"`
+	Unchanged      int      `json:"unchanged"`
+	Deprecated     int      `json:"deprecated"`
+	CVELines       int      `json:"cve_lines"`
+	ResumedBatches int      `json:"resumed_batches"`
+	InvalidEntries int      `json:"invalid_entries"`
+	InvalidSamples []string `json:"invalid_samples,omitempty"`
+	ElapsedSeconds float64  `json:"elapsed_seconds"`
+	ItemsPerSecond float64  `json:"items_per_second"`
+	// Keys is the size of a Valkey index, and IndexFile and IndexBytes
+	// the file of the bolt and sqlite backends
+	Keys                int64  `json:"keys,omitempty"`
+	MemoryEstimateBytes int64  `json:"memory_estimate_bytes,omitempty"`
+	IndexFile           string `json:"index_file,omitempty"`
+	IndexBytes          int64  `json:"index_bytes,omitempty"`
+}
+
+// newImportSummary returns the summary of the import described by stats.
+func newImportSummary(stats *importStats, rankPolicy string) *importSummary {
+	s := &importSummary{
+		Items:          stats.items,
+		Words:          stats.words,
+		Lines:          stats.lines,
+		Duplicates:     stats.dups,
+		RankPolicy:     rankPolicy,
+		SkippedParts:   stats.skippedParts,
+		Unchanged:      stats.unchanged,
+		Deprecated:     stats.deprecated,
+		CVELines:       stats.cveLines,
+		ResumedBatches: stats.resumed,
+		InvalidEntries: stats.errs.count,
+		InvalidSamples: stats.errs.samples,
+		ElapsedSeconds: stats.elapsed.Seconds(),
+	}
+	if s.ElapsedSeconds > 0 {
+		s.ItemsPerSecond = float64(stats.items) / s.ElapsedSeconds
+	}
+	return s
+}
+
+// print writes s to stdout as one JSON object.
+func (s *importSummary) print() {
+	if err := json.NewEncoder(os.Stdout).Encode(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the import summary: %v\n", err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+)
+
+// queryTimeout bounds a query sent to a running server.
+const queryTimeout = 30 * time.Second
+
+// runQuery searches for the words given as arguments and prints the ranked
+// CPEs, from the configured index or from a running server.
+func runQuery(fs *flag.FlagSet) func() {
+	unique := fs.Bool("unique", false, "Print only the best CPE, as /unique returns it")
+	asJSON := fs.Bool("json", false, "Print the results as JSON")
+	limit := fs.Int("limit", 10, "Maximum number of results, 0 for all")
+	bulk := fs.String("bulk", "", "File with one software name per line to map to CPEs, - for stdin")
+	format := fs.String("format", "ndjson", "Output format of -bulk: ndjson or csv")
+	workers := fs.Int("workers", 8, "Number of concurrent lookups of -bulk")
+	serverURL := fs.String("server", "", "URL of a running server to query instead of the index, e.g. http://localhost:8000")
+	apiKey := fs.String("api-key", "", "API key sent to the server of -server (default: $CPG_API_KEY)")
+	redisHost := fs.String("redis", "", "Redis host:port (overrides config)")
+	configPath := fs.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
+
+	return func() {
+		words := fs.Args()
+		if *bulk != "" && len(words) > 0 {
+			log.Fatal("-bulk takes the names from a file, not the command line")
+		}
+		if *bulk == "" && len(words) == 0 {
+			log.Fatalf("Usage: %s query [flags] <word>...", progName)
+		}
+		if *format != "ndjson" && *format != "csv" {
+			log.Fatalf("Unknown format %q, use ndjson or csv", *format)
+		}
+		if *workers < 1 {
+			log.Fatal("-workers must be at least 1")
+		}
+
+		var query queryFunc
+		if *serverURL != "" {
+			key := *apiKey
+			if key == "" {
+				key = os.Getenv("CPG_API_KEY")
+			}
+			query = serverQuery(strings.TrimRight(*serverURL, "/"), key)
+		} else {
+			query = indexQuery(*configPath, *redisHost)
+		}
+		if *bulk != "" {
+			runBulkQuery(query, *bulk, *format, *workers)
+			return
+		}
+
+		res, best, err := query(words, *unique, *limit)
+		if err != nil {
+			log.Fatalf("Query failed: %v", err)
+		}
+
+		switch {
+		case *unique && *asJSON:
+			if best == "" {
+				fmt.Println("[]")
+			} else {
+				out, _ := json.Marshal(best)
+				fmt.Println(string(out))
+			}
+		case *unique:
+			if best == "" {
+				fmt.Fprintln(os.Stderr, "No match")
+				os.Exit(1)
+			}
+			fmt.Println(best)
+		case *asJSON:
+			if res == nil {
+				res = []guesser.Result{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(res)
+		default:
+			if len(res) == 0 {
+				fmt.Fprintln(os.Stderr, "No match")
+				os.Exit(1)
+			}
+			for _, r := range res {
+				fmt.Printf("%8g  %s\n", r.Rank, r.CPE)
+			}
+		}
+	}
+}
+
+// queryFunc searches for words and returns up to limit ranked results, 0 for
+// all, or only the best CPE when unique is set.
+type queryFunc func(words []string, unique bool, limit int) ([]guesser.Result, string, error)
+
+// indexQuery returns a queryFunc reading the index of the configured storage
+// backend, with the search settings the server applies by default.
+func indexQuery(configPath, redisHost string) queryFunc {
+	cfg = loadConfig(configPath)
+	// Building an in-memory index reports its progress, keep stdout for
+	// the results
+	importOut = os.Stderr
+	st := newServerState(cfg, redisHost, nil)
+	return func(words []string, unique bool, limit int) ([]guesser.Result, string, error) {
+		return searchIndex(st, words, unique, limit)
+	}
+}
+
+// searchIndex runs a queryFunc search through the guesser client of st.
+func searchIndex(st *serverState, words []string, unique bool, limit int) ([]guesser.Result, string, error) {
+	cfg := st.cfg
+	if unique {
+		best, err := st.gs.Unique(ctx, words)
+		return nil, best, err
+	}
+	fuzzy := 0
+	if cfg.Server.Fuzzy {
+		fuzzy = cfg.GetFuzzyDistance()
+	}
+	res, path, err := st.gs.Search(ctx, words, guesser.SearchOptions{
+		DisablePartial: cfg.Server.DisablePartial,
+		Fuzzy:          fuzzy,
+		Budget:         cfg.Server.TimeBudget,
+	})
+	if err != nil && !errors.Is(err, guesser.ErrPartialResults) {
+		return nil, "", err
+	}
+	if cfg.Server.FlagSubstrings && path == "partial" {
+		st.gs.MarkSubstringOnly(res, words)
+	}
+	res = guesser.FilterMinRank(res, cfg.Server.MinRank)
+	if cfg.Server.ExcludeDeprecated {
+		if res, err = st.gs.ExcludeDeprecated(ctx, res); err != nil {
+			return nil, "", err
+		}
+	}
+	switch cfg.Server.Scoring {
+	case "coverage":
+		guesser.ScoreByCoverage(res, cfg.Server.CoverageWeight)
+	case "idf":
+		if err := st.gs.ScoreByIDF(ctx, res, words, nil); err != nil {
+			return nil, "", err
+		}
+	}
+	guesser.SortByPartPriority(res, cfg.Server.PartPriority)
+	res = guesser.Paginate(res, 0, limit)
+	if !cfg.Server.ExcludeDeprecated {
+		if err := st.gs.Deprecations(ctx, res); err != nil {
+			return nil, "", err
+		}
+	}
+	guesser.SetVendorProduct(res)
+	return res, "", nil
+}
+
+// serverQuery returns a queryFunc sending the queries to the /search or
+// /unique endpoint of the server at baseURL, with apiKey when set.
+func serverQuery(baseURL, apiKey string) queryFunc {
+	client := &http.Client{Timeout: queryTimeout}
+	return func(words []string, unique bool, limit int) ([]guesser.Result, string, error) {
+		return searchServer(client, baseURL, apiKey, words, unique, limit)
+	}
+}
+
+// searchServer runs a queryFunc search through the server at baseURL.
+func searchServer(client *http.Client, baseURL, apiKey string, words []string, unique bool, limit int) ([]guesser.Result, string, error) {
+	if unique {
+		var best json.RawMessage
+		if err := postJSON(client, apiKey, baseURL+"/unique", map[string]interface{}{"query": words}, &best); err != nil {
+			return nil, "", err
+		}
+		// No match is an empty array
+		var cpe string
+		json.Unmarshal(best, &cpe)
+		return nil, cpe, nil
+	}
+	req := map[string]interface{}{"query": words, "format": formatObject}
+	if limit > 0 {
+		req["limit"] = limit
+	}
+	var res []guesser.Result
+	err := postJSON(client, apiKey, baseURL+"/search", req, &res)
+	return res, "", err
+}
+
+// postJSON posts body as JSON to url, with apiKey when set, and decodes the
+// response into out.
+func postJSON(client *http.Client, apiKey, url string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		return fmt.Errorf("POST %s: %s: %s", url, resp.Status, strings.TrimSpace(msg.String()))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+)
+
+// reimports runs the imports of the server; nil with the memory backend.
+var reimports *reimporter
+
+// reimporter runs the import command in a child process of the server, on a
+// schedule or on request, one import at a time. Running the import apart
+// keeps a failed one from taking the server down; the server picks up the new
+// index as it does after any import.
+type reimporter struct {
+	exe  string
+	args []string
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+
+	mu     sync.Mutex
+	status importStatus
+}
+
+// importStatus describes the import the server runs, or the last one it ran.
+type importStatus struct {
+	Running  bool       `json:"running"`
+	Trigger  string     `json:"trigger,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Progress is the last line the import printed
+	Progress string `json:"progress,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Summary is the summary of a completed import
+	Summary *importSummary `json:"summary,omitempty"`
+	// Next is when the next scheduled import starts
+	Next *time.Time `json:"next,omitempty"`
+}
+
+// newReimporter returns a reimporter running this binary's import with the
+// configuration at configPath and redisOverride, like the server. It
+// downloads the dictionary and builds the index apart to swap it in, or
+// updates a Valkey cluster, which can't swap, in place. A cluster loading
+// cpe.index_snapshot, which can't update, is replaced.
+func newReimporter(configPath, redisOverride string, cfg *config.Config) (*reimporter, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"import", "-download", "-json"}
+	switch {
+	case !cfg.Valkey.Cluster || (cfg.Storage.Backend != "" && cfg.Storage.Backend != config.BackendValkey):
+		args = append(args, "-swap")
+	case cfg.CPE.IndexSnapshot != "":
+		args = append(args, "-replace")
+	default:
+		args = append(args, "-update")
+	}
+	if configPath != "" {
+		args = append(args, "-config", configPath)
+	}
+	if redisOverride != "" {
+		args = append(args, "-redis", redisOverride)
+	}
+	r := &reimporter{exe: exe, args: args}
+	r.ctx, r.stop = context.WithCancel(context.Background())
+	return r, nil
+}
+
+// start starts an import unless one is running, and reports whether it did.
+func (r *reimporter) start(trigger string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.Running || r.ctx.Err() != nil {
+		return false
+	}
+	now := time.Now()
+	r.status = importStatus{Running: true, Trigger: trigger, Started: &now, Next: r.status.Next}
+	r.wg.Add(1)
+	go r.run(trigger)
+	return true
+}
+
+// currentStatus returns the status of the running or last import.
+func (r *reimporter) currentStatus() importStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// run runs the import, following its progress on its stderr and reading its
+// JSON summary from its stdout.
+func (r *reimporter) run(trigger string) {
+	defer r.wg.Done()
+	slog.Info("Starting an import", "trigger", trigger)
+	cmd := exec.CommandContext(r.ctx, r.exe, r.args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	stderr, err := cmd.StderrPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err == nil {
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			slog.Debug("import", "line", sc.Text())
+			r.mu.Lock()
+			r.status.Progress = sc.Text()
+			r.mu.Unlock()
+		}
+		err = cmd.Wait()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.status.Running = false
+	r.status.Finished = &now
+	if err != nil {
+		// The last line the import printed says why it failed
+		r.status.Error = err.Error()
+		if r.status.Progress != "" {
+			r.status.Error = fmt.Sprintf("%v: %s", err, r.status.Progress)
+		}
+		slog.Warn("Import failed, still serving the previous index", "trigger", trigger, "err", r.status.Error)
+		return
+	}
+	var summary importSummary
+	if err := json.Unmarshal(stdout.Bytes(), &summary); err == nil {
+		r.status.Summary = &summary
+	}
+	slog.Info("Import completed", "trigger", trigger, "items", summary.Items, "elapsed", now.Sub(*r.status.Started))
+}
+
+// schedule starts an import every interval until the reimporter is closed.
+// An import still running when the next one is due delays it by another
+// interval.
+func (r *reimporter) schedule(interval time.Duration) {
+	for {
+		next := time.Now().Add(interval)
+		r.mu.Lock()
+		r.status.Next = &next
+		r.mu.Unlock()
+		select {
+		case <-time.After(interval):
+		case <-r.ctx.Done():
+			return
+		}
+		if !r.start("schedule") && r.ctx.Err() == nil {
+			slog.Warn("Skipping the scheduled import, the previous one is still running")
+		}
+	}
+}
+
+// close stops a running import and waits for it to exit.
+func (r *reimporter) close() {
+	r.stop()
+	// An import starting meanwhile is counted once the lock is released
+	r.mu.Lock()
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// handleReimport starts an import in the background and answers 202 Accepted
+// with its status, or 409 Conflict with the status of the import already
+// running.
+func handleReimport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST to start an import", http.StatusMethodNotAllowed)
+		return
+	}
+	if reimports == nil {
+		http.Error(w, "the memory backend builds its index at startup only", http.StatusNotImplemented)
+		return
+	}
+	code := http.StatusAccepted
+	if !reimports.start("admin") {
+		code = http.StatusConflict
+	}
+	writeImportStatus(w, code, reimports.currentStatus())
+}
+
+// handleImportStatus returns the status of the running or last import.
+func handleImportStatus(w http.ResponseWriter, r *http.Request) {
+	if reimports == nil {
+		http.Error(w, "the memory backend builds its index at startup only", http.StatusNotImplemented)
+		return
+	}
+	writeImportStatus(w, http.StatusOK, reimports.currentStatus())
+}
+
+func writeImportStatus(w http.ResponseWriter, code int, status importStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+)
+
+// maxSBOMComponents caps the number of components /sbom guesses CPEs for in
+// one document.
+const maxSBOMComponents = 10000
+
+// Properties /sbom adds to the components it sets a CPE on.
+const (
+	propSource         = "cpe-guesser:source"
+	propVersionUnknown = "cpe-guesser:version-unknown"
+)
+
+// handleSBOM sets the cpe of each component of a CycloneDX JSON document that
+// has none and returns the document. Fields the guesser doesn't use are passed
+// through unchanged.
+func handleSBOM(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		http.Error(w, "bad JSON", http.StatusBadRequest)
+		return
+	}
+	if format, _ := doc["bomFormat"].(string); format != "CycloneDX" {
+		http.Error(w, "not a CycloneDX document", http.StatusBadRequest)
+		return
+	}
+
+	components := sbomComponents(doc)
+	if len(components) > maxSBOMComponents {
+		http.Error(w, fmt.Sprintf("too many components (max %d)", maxSBOMComponents), http.StatusBadRequest)
+		return
+	}
+	for _, c := range components {
+		if err := st.guessComponent(r.Context(), c); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	setResultCount(r, len(components))
+	w.Header().Set("Content-Type", "application/vnd.cyclonedx+json")
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Keep URLs in external references readable
+	enc.SetEscapeHTML(false)
+	enc.Encode(doc)
+	w.Write(buf.Bytes())
+}
+
+// sbomComponents returns the metadata component and the components of doc,
+// including nested ones.
+func sbomComponents(doc map[string]any) []map[string]any {
+	var out []map[string]any
+	var walk func(list any)
+	walk = func(list any) {
+		items, _ := list.([]any)
+		for _, item := range items {
+			if c, ok := item.(map[string]any); ok {
+				out = append(out, c)
+				walk(c["components"])
+			}
+		}
+	}
+	if meta, ok := doc["metadata"].(map[string]any); ok {
+		if c, ok := meta["component"].(map[string]any); ok {
+			out = append(out, c)
+			walk(c["components"])
+		}
+	}
+	walk(doc["components"])
+	return out
+}
+
+// guessComponent sets the cpe of the CycloneDX component c unless it has one.
+// The CPE carries the component version, marked with a property when the
+// index doesn't know that version.
+func (s *serverState) guessComponent(ctx context.Context, c map[string]any) error {
+	if cpe, _ := c["cpe"].(string); cpe != "" {
+		return nil
+	}
+
+	p, res, source, err := s.guessPackage(ctx, sbomPackage{
+		purl:    stringField(c, "purl"),
+		vendor:  componentVendor(c),
+		name:    stringField(c, "name"),
+		version: stringField(c, "version"),
+	}, 1)
+	if err != nil || len(res) == 0 {
+		return err
+	}
+	cpe, known, err := s.fullCPE(ctx, res[0].CPE, p.Version)
+	if err != nil {
+		return err
+	}
+	c["cpe"] = cpe
+	addProperty(c, propSource, source)
+	if !known {
+		addProperty(c, propVersionUnknown, "true")
+	}
+	return nil
+}
+
+// sbomPackage is what the guesser uses of a package listed in an SBOM.
+type sbomPackage struct {
+	purl, vendor, name, version string
+}
+
+// guessPackage returns up to limit candidate CPE lines for pkg, along with
+// the package it searched for. A package with a valid package URL is looked
+// up like /purl does; otherwise its name is searched, with its vendor
+// favouring candidates from that vendor. The package version fills in for a
+// package URL without one.
+func (s *serverState) guessPackage(ctx context.Context, pkg sbomPackage, limit int) (guesser.PURL, []guesser.Result, string, error) {
+	p, err := guesser.ParsePURL(pkg.purl)
+	if err != nil {
+		p = guesser.PURL{Type: "generic", Namespace: pkg.vendor, Name: pkg.name}
+	}
+	if p.Version == "" {
+		p.Version = pkg.version
+	}
+	if p.Name == "" {
+		return p, nil, "", nil
+	}
+	res, source, err := s.gs.PURL(ctx, p, limit)
+	return p, res, source, err
+}
+
+// fullCPE returns the full CPE of the CPE line cpe at version, which is ANY
+// when empty, and whether the index knows that version. An unknown version
+// is used as given.
+func (s *serverState) fullCPE(ctx context.Context, cpe, version string) (string, bool, error) {
+	if version == "" {
+		return cpe + strings.Repeat(":*", 8), true, nil
+	}
+	full, err := s.gs.Version(ctx, cpe, version)
+	if err != nil || full != "" {
+		return full, true, err
+	}
+	return cpe + ":" + guesser.Escape(version) + strings.Repeat(":*", 7), false, nil
+}
+
+// componentVendor returns the name of the publisher, supplier or group of
+// the component c, in that order of preference.
+func componentVendor(c map[string]any) string {
+	if v := stringField(c, "publisher"); v != "" {
+		return v
+	}
+	if supplier, ok := c["supplier"].(map[string]any); ok {
+		if v := stringField(supplier, "name"); v != "" {
+			return v
+		}
+	}
+	return stringField(c, "group")
+}
+
+func stringField(m map[string]any, key string) string {
+	v, _ := m[key].(string)
+	return v
+}
+
+// addProperty appends a name/value pair to the properties of the component c.
+func addProperty(c map[string]any, name, value string) {
+	props, _ := c["properties"].([]any)
+	c["properties"] = append(props, map[string]any{"name": name, "value": value})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+)
+
+// runSnapshot writes the CPE lines currently in the index to a file, one per
+// line and sorted, so two imports can later be compared with diff.
+func runSnapshot(fs *flag.FlagSet) func() {
+	out := fs.String("out", "", "File to write the snapshot to (required)")
+	redisHost := fs.String("redis", "", "Redis host:port (overrides config)")
+	configPath := fs.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
+
+	return func() {
+		if *out == "" {
+			log.Fatal("Please specify the snapshot file with -out")
+		}
+
+		cfg = loadConfig(*configPath)
+
+		ctx := context.Background()
+		store, release := openIndex(cfg, *redisHost)
+		defer release()
+
+		cpes, err := indexedCPEs(ctx, store)
+		if err != nil {
+			log.Fatalf("Failed to list the indexed CPEs: %v", err)
+		}
+
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("File create error: %v", err)
+		}
+		w := bufio.NewWriter(f)
+		for _, cpe := range cpes {
+			fmt.Fprintln(w, cpe)
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatalf("Failed to write snapshot: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Failed to write snapshot: %v", err)
+		}
+
+		fmt.Printf("Wrote %d CPEs to %s\n", len(cpes), *out)
+	}
+}
+
+// indexedCPEs returns the CPE lines indexed under some word of store, sorted.
+func indexedCPEs(ctx context.Context, store guesser.Store) ([]string, error) {
+	var words []string
+	err := store.Words(ctx, func(chunk []string) error {
+		words = append(words, chunk...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{})
+	for rest := words; len(rest) > 0; {
+		chunk := rest[:min(len(rest), dumpChunk)]
+		rest = rest[len(chunk):]
+		sets, err := store.Members(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		for _, cpes := range sets {
+			for _, cpe := range cpes {
+				seen[cpe] = struct{}{}
+			}
+		}
+	}
+	cpes := make([]string, 0, len(seen))
+	for cpe := range seen {
+		cpes = append(cpes, cpe)
+	}
+	sort.Strings(cpes)
+	return cpes, nil
+}
+
+// runDiff reports the CPEs added and removed between two snapshots.
+func runDiff(fs *flag.FlagSet) func() {
+	return func() {
+		if fs.NArg() != 2 {
+			log.Fatal("Usage: diff <old snapshot> <new snapshot>")
+		}
+
+		before, err := readSnapshot(fs.Arg(0))
+		if err != nil {
+			log.Fatalf("Failed to read snapshot: %v", err)
+		}
+		after, err := readSnapshot(fs.Arg(1))
+		if err != nil {
+			log.Fatalf("Failed to read snapshot: %v", err)
+		}
+
+		var added, removed []string
+		for cpe := range after {
+			if _, ok := before[cpe]; !ok {
+				added = append(added, cpe)
+			}
+		}
+		for cpe := range before {
+			if _, ok := after[cpe]; !ok {
+				removed = append(removed, cpe)
+			}
+		}
+		sort.Strings(added)
+		sort.Strings(removed)
+
+		for _, cpe := range added {
+			fmt.Printf("+ %s\n", cpe)
+		}
+		for _, cpe := range removed {
+			fmt.Printf("- %s\n", cpe)
+		}
+		fmt.Printf("%d added, %d removed\n", len(added), len(removed))
+	}
+}
+
+func readSnapshot(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cpes := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			cpes[line] = struct{}{}
+		}
+	}
+	return cpes, scanner.Err()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// spdxPackage is a package of an SPDX document.
+type spdxPackage struct {
+	id string
+	sbomPackage
+	// cpes are the CPE 2.3 external references the document already has
+	cpes []string
+}
+
+// spdxCandidate is a CPE guessed for an SPDX package.
+type spdxCandidate struct {
+	CPE            string  `json:"cpe"`
+	Confidence     float64 `json:"confidence"`
+	VersionUnknown bool    `json:"version_unknown,omitempty"`
+}
+
+// spdxMatch holds the CPEs guessed for an SPDX package.
+type spdxMatch struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version,omitempty"`
+	Source  string          `json:"source,omitempty"`
+	CPEs    []spdxCandidate `json:"cpes"`
+}
+
+// handleSPDX guesses CPEs for the packages of an SPDX 2 document, in JSON or
+// tag-value format, and returns them by SPDX identifier. CPEs the document
+// already lists for a package are returned as they are, with source
+// "document".
+func handleSPDX(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+
+	limit := defaultPURLResults
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var pkgs []spdxPackage
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		pkgs, err = parseSPDXJSON(body)
+	} else {
+		pkgs, err = parseSPDXTagValue(body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(pkgs) > maxSBOMComponents {
+		http.Error(w, fmt.Sprintf("too many packages (max %d)", maxSBOMComponents), http.StatusBadRequest)
+		return
+	}
+
+	out := make(map[string]spdxMatch, len(pkgs))
+	for _, pkg := range pkgs {
+		m := spdxMatch{Name: pkg.name, Version: pkg.version, CPEs: []spdxCandidate{}}
+		if len(pkg.cpes) > 0 {
+			m.Source = "document"
+			for _, cpe := range pkg.cpes {
+				m.CPEs = append(m.CPEs, spdxCandidate{CPE: cpe, Confidence: 1})
+			}
+			out[pkg.id] = m
+			continue
+		}
+
+		p, res, source, err := st.guessPackage(r.Context(), pkg.sbomPackage, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.Source = source
+		for _, c := range res {
+			cpe, known, err := st.fullCPE(r.Context(), c.CPE, p.Version)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			m.CPEs = append(m.CPEs, spdxCandidate{CPE: cpe, Confidence: c.Confidence, VersionUnknown: !known})
+		}
+		out[pkg.id] = m
+	}
+
+	setResultCount(r, len(out))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// parseSPDXJSON returns the packages of an SPDX JSON document.
+func parseSPDXJSON(body []byte) ([]spdxPackage, error) {
+	var doc struct {
+		SPDXVersion string `json:"spdxVersion"`
+		Packages    []struct {
+			SPDXID       string `json:"SPDXID"`
+			Name         string `json:"name"`
+			VersionInfo  string `json:"versionInfo"`
+			Supplier     string `json:"supplier"`
+			Originator   string `json:"originator"`
+			ExternalRefs []struct {
+				ReferenceType    string `json:"referenceType"`
+				ReferenceLocator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, errors.New("bad JSON")
+	}
+	if doc.SPDXVersion == "" {
+		return nil, errors.New("not an SPDX document")
+	}
+	pkgs := make([]spdxPackage, 0, len(doc.Packages))
+	for _, p := range doc.Packages {
+		pkg := spdxPackage{id: p.SPDXID}
+		pkg.name, pkg.version = p.Name, p.VersionInfo
+		pkg.vendor = spdxVendor(p.Supplier, p.Originator)
+		for _, ref := range p.ExternalRefs {
+			pkg.addRef(ref.ReferenceType, ref.ReferenceLocator)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// parseSPDXTagValue returns the packages of an SPDX tag-value document. A
+// package starts at its PackageName tag; multi-line <text> values are
+// skipped.
+func parseSPDXTagValue(body []byte) ([]spdxPackage, error) {
+	var (
+		pkgs       []spdxPackage
+		cur        *spdxPackage
+		isSPDX     bool
+		inText     bool
+		supplier   string
+		originator string
+	)
+	flush := func() {
+		if cur != nil {
+			cur.vendor = spdxVendor(supplier, originator)
+			pkgs = append(pkgs, *cur)
+		}
+		cur, supplier, originator = nil, "", ""
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if inText {
+			inText = !strings.Contains(line, "</text>")
+			continue
+		}
+		tag, value, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		tag, value = strings.TrimSpace(tag), strings.TrimSpace(value)
+		if strings.HasPrefix(value, "<text>") {
+			inText = !strings.Contains(value, "</text>")
+			continue
+		}
+
+		switch tag {
+		case "SPDXVersion":
+			isSPDX = true
+		case "PackageName":
+			flush()
+			cur = &spdxPackage{}
+			cur.name = value
+		}
+		if cur == nil {
+			continue
+		}
+		switch tag {
+		case "SPDXID":
+			if cur.id == "" {
+				cur.id = value
+			}
+		case "PackageVersion":
+			cur.version = value
+		case "PackageSupplier":
+			supplier = value
+		case "PackageOriginator":
+			originator = value
+		case "ExternalRef":
+			// CATEGORY TYPE LOCATOR
+			if f := strings.Fields(value); len(f) == 3 {
+				cur.addRef(f[1], f[2])
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if !isSPDX {
+		return nil, errors.New("not an SPDX document")
+	}
+	flush()
+	return pkgs, nil
+}
+
+// addRef records the external reference of type typ at locator if the
+// guesser uses it.
+func (p *spdxPackage) addRef(typ, locator string) {
+	switch typ {
+	case "purl":
+		if p.purl == "" {
+			p.purl = locator
+		}
+	case "cpe23Type":
+		p.cpes = append(p.cpes, locator)
+	}
+}
+
+// spdxVendor returns the organization or person name of an SPDX supplier,
+// or of the originator when the supplier is unknown, such as
+// "Organization: Apache Software Foundation (contact@example.com)".
+func spdxVendor(supplier, originator string) string {
+	for _, v := range []string{supplier, originator} {
+		_, name, ok := strings.Cut(v, ":")
+		if !ok {
+			continue
+		}
+		if i := strings.IndexByte(name, '('); i >= 0 {
+			name = name[:i]
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,11 @@
+//go:build sqlite_fts5
+
+package main
+
+import "github.com/aringo/cpe-guesser-go/pkg/guesser"
+
+func init() {
+	openSQLite = func(path string, readOnly bool) (fileStore, error) {
+		return guesser.OpenSQLiteStore(path, readOnly)
+	}
+}
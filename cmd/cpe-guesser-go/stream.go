@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+)
+
+// streamSource reads the entries of a dictionary download as it arrives,
+// without storing the download first. The uncompressed stream is hashed on
+// the way, and copied to the CPE file when teeing, and checked like a
+// downloaded file once the last entry is read.
+type streamSource struct {
+	entrySource
+	source string
+	// rest is the uncompressed stream, read to its end after the last
+	// entry so that its size and SHA-256 cover the whole dictionary
+	rest    io.Reader
+	hash    hash.Hash
+	size    int64
+	entries int
+	// meta is the metadata file of the source, nil when there is none
+	meta       *dictionaryMeta
+	metaSource string
+	// out is the copy written to path when teeing, nil otherwise
+	out  *os.File
+	path string
+	done bool
+	// inputProgress counts the bytes downloaded
+	inputProgress
+}
+
+func (s *streamSource) Write(p []byte) (int, error) {
+	s.hash.Write(p)
+	s.size += int64(len(p))
+	if s.out != nil {
+		return s.out.Write(p)
+	}
+	return len(p), nil
+}
+
+func (s *streamSource) next() (*cpeEntry, error) {
+	e, err := s.entrySource.next()
+	if err == nil {
+		s.entries++
+	}
+	if err != io.EOF || s.done {
+		return e, err
+	}
+	if err := s.finish(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// finish checks the stream once its last entry was read, and moves the copy
+// of a teeing stream over the CPE file. A failed check drops the copy, as the
+// import exits without releasing the source.
+func (s *streamSource) finish() error {
+	s.done = true
+	err := s.check()
+	if s.out == nil {
+		return err
+	}
+	tmp := s.out.Name()
+	if cerr := s.out.Close(); err == nil {
+		err = cerr
+	}
+	s.out = nil
+	if err == nil {
+		if err = os.Rename(tmp, s.path); err == nil {
+			fmt.Fprintf(importOut, "Kept the download in %s\n", s.path)
+			return nil
+		}
+		err = fmt.Errorf("keeping the download in %s: %w", s.path, err)
+	}
+	os.Remove(tmp)
+	return err
+}
+
+// check reads the rest of the stream and compares it with the metadata file.
+func (s *streamSource) check() error {
+	if _, err := io.Copy(io.Discard, s.rest); err != nil {
+		return err
+	}
+	if s.entries == 0 {
+		return errors.New("no entries in the download")
+	}
+	if s.meta == nil {
+		return nil
+	}
+	sum := hex.EncodeToString(s.hash.Sum(nil))
+	if err := s.meta.check(s.size, sum, s.metaSource); err != nil {
+		return fmt.Errorf("refusing the download of %s: %w", s.source, err)
+	}
+	fmt.Fprintf(importOut, "Verified the download against %s: %d bytes, SHA-256 %s\n", s.metaSource, s.size, sum)
+	return nil
+}
+
+// streamDictionary opens the CPE source of c and returns the entries of the
+// download as it arrives, uncompressing a gzip download on the way. With tee
+// the uncompressed dictionary is also written to the CPE file, replacing the
+// previous copy once the whole download checked out. The download timeout
+// bounds the whole import, which lasts as long as the transfer. Zip
+// archives need random access and can't be streamed. The returned function
+// releases the download.
+func streamDictionary(ctx context.Context, c *config.Config, tee bool, readBuffer int) (entrySource, func()) {
+	source, err := c.GetCPESource(time.Now())
+	if err != nil {
+		log.Fatalf("Failed to resolve CPE source: %v", err)
+	}
+	// The metadata file is fetched first, so a missing one fails before
+	// anything is written
+	meta, metaSource, err := sourceMeta(ctx, c, source)
+	if err != nil {
+		log.Fatalf("Refusing to import the download of %s: %v", source, err)
+	}
+
+	fmt.Fprintf(importOut, "Streaming CPE data from %s ...\n", source)
+	timeout, _, _ := c.GetDownloadTimeouts()
+	dctx, cancel := context.WithTimeout(ctx, timeout)
+	body, err := openSource(dctx, source)
+	if err != nil {
+		cancel()
+		if isTimeout(err) {
+			log.Fatalf("Download timed out after %s: %v", timeout, err)
+		}
+		log.Fatalf("Download error: %v", err)
+	}
+	release := func() {
+		body.Close()
+		cancel()
+	}
+
+	s := &streamSource{source: source, hash: sha256.New(), meta: meta, metaSource: metaSource}
+	s.total.Store(downloadSize(body))
+	br := bufio.NewReaderSize(countingReader{r: body, p: &s.inputProgress}, readBuffer)
+	if head, _ := br.Peek(len(zipMagic)); bytes.Equal(head, zipMagic) {
+		release()
+		log.Fatal("The CPE source is a zip archive, which can't be streamed; import it without -stream")
+	}
+	r, err := uncompressed(br)
+	if err != nil {
+		release()
+		log.Fatalf("Download error: %v", err)
+	}
+
+	if tee {
+		s.path = c.GetCPEPath()
+		if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+			release()
+			log.Fatalf("Failed to create CPE directory: %v", err)
+		}
+		if s.out, err = os.Create(s.path + ".new"); err != nil {
+			release()
+			log.Fatalf("File create error: %v", err)
+		}
+	}
+	// An import failing before the end leaves the previous copy
+	closeSrc := func() {
+		release()
+		if s.out != nil {
+			s.out.Close()
+			os.Remove(s.out.Name())
+		}
+	}
+
+	s.rest = io.TeeReader(r, s)
+	if s.entrySource, err = detectSource(bufio.NewReaderSize(s.rest, readBuffer), readBuffer); err != nil {
+		closeSrc()
+		log.Fatalf("Open CPE download: %v", err)
+	}
+	return s, closeSrc
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// indexCheckInterval is how often the server checks whether an import swapped
+// a new index file in.
+const indexCheckInterval = 10 * time.Second
+
+// watchIndexFile switches the server to the index file at path whenever an
+// import -swap renames a new one over the file it serves, described by
+// opened. An import updating the file in place leaves it the same file.
+func watchIndexFile(path, redisOverride string, opened os.FileInfo) {
+	for range time.Tick(indexCheckInterval) {
+		fi, err := os.Stat(path)
+		if err != nil || os.SameFile(fi, opened) {
+			continue
+		}
+		if err := switchIndexFile(path, redisOverride); err != nil {
+			slog.Warn("Could not open the new index file, still serving the previous one", "path", path, "err", err)
+			continue
+		}
+		opened = fi
+	}
+}
+
+// switchIndexFile opens the index file at path and replaces the server state
+// with one serving it. Requests already running finish with the previous
+// file, which is closed a minute later.
+func switchIndexFile(path, redisOverride string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	old := state.Load()
+	store, err := openFileStore(old.cfg, path, true)
+	if err != nil {
+		return err
+	}
+	cpes, err := store.Len()
+	if err != nil {
+		store.Close()
+		return err
+	}
+
+	// The new state takes the store of prev
+	prev := *old
+	prev.store = store
+	state.Store(newServerState(old.cfg, redisOverride, &prev))
+	slog.Info("Switched to the new index file", "path", path, "cpes", cpes)
+
+	if stale, ok := old.store.(fileStore); ok {
+		time.AfterFunc(time.Minute, func() { stale.Close() })
+	}
+	return nil
+}
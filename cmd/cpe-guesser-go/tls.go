@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+)
+
+// certCheckInterval is how often the certificate files are checked for a
+// rotation.
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves a certificate from files, loading it again when they
+// change, so a rotated certificate is picked up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// newCertReloader loads the certificate and key of certFile and keyFile.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// filesModTime returns the latest modification time of the certificate and
+// key files.
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate returns the current certificate, reloading the files when
+// they changed since they were last checked. A failed reload is logged and
+// the previous certificate kept, as the key and certificate may be replaced
+// one after the other.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checked = time.Now()
+	modTime, err := r.filesModTime()
+	if err != nil {
+		slog.Warn("Could not check the TLS certificate", "err", err)
+		return r.cert, nil
+	}
+	if !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	if err := r.load(modTime); err != nil {
+		slog.Warn("Could not reload the TLS certificate, keeping the current one", "err", err)
+		return r.cert, nil
+	}
+	slog.Info("Reloaded the TLS certificate", "cert_file", r.certFile)
+	return r.cert, nil
+}
+
+// valkeyTLSConfig returns the TLS configuration of the Valkey clients of c
+// connecting to addr.
+func valkeyTLSConfig(c *config.Config, addr string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: host}
+	if c.Valkey.TLSCAFile != "" {
+		pem, err := os.ReadFile(c.Valkey.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading CAs: %w", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + c.Valkey.TLSCAFile)
+		}
+	}
+	return tc, nil
+}
+
+// serverTLSConfig returns the TLS configuration of the servers of c, or nil
+// when they serve plain text.
+func serverTLSConfig(c *config.Config) (*tls.Config, error) {
+	t := c.Server.TLS
+	if t.CertFile == "" {
+		return nil, nil
+	}
+	certs, err := newCertReloader(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %w", err)
+	}
+	tc := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client CAs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + t.ClientCAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aringo/cpe-guesser-go/internal/config"
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+)
+
+// runVerify checks that the word sets and rank:cpe describe the same CPEs.
+// Word set members without a rank and ranked CPEs without any word set are
+// reported, and removed when -fix is given.
+func runVerify(fs *flag.FlagSet) func() {
+	fix := fs.Bool("fix", false, "Remove inconsistent entries")
+	redisHost := fs.String("redis", "", "Redis host:port (overrides config)")
+	configPath := fs.String("config", "", "Path to config file (default: search for settings.yaml in current directory)")
+
+	return func() {
+		cfg = loadConfig(*configPath)
+		if cfg.Storage.Backend != "" && cfg.Storage.Backend != config.BackendValkey {
+			log.Fatalf("verify checks the keys of a Valkey index and doesn't apply to the %s storage backend", cfg.Storage.Backend)
+		}
+
+		redisAddr := cfg.GetRedisAddr()
+		if *redisHost != "" {
+			redisAddr = *redisHost
+		}
+
+		ctx := context.Background()
+		rdb := newRedisClient(cfg, redisAddr, cfg.GetIndexDB())
+		defer rdb.Close()
+		prefix := guesser.KeyPrefix(rdb)
+		node, err := guesser.IndexNode(ctx, rdb)
+		if err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+
+		ranked, err := rdb.ZRange(ctx, prefix+"rank:cpe", 0, -1).Result()
+		if err != nil {
+			log.Fatalf("Failed to read rank:cpe: %v", err)
+		}
+		// seen tracks which ranked CPEs are referenced by at least one word set
+		seen := make(map[string]bool, len(ranked))
+		for _, cpe := range ranked {
+			seen[cpe] = false
+		}
+
+		// Word set members that have no rank, keyed by word set
+		dangling := make(map[string][]string)
+		danglingCount := 0
+		keyCount := 0
+
+		iter := node.Scan(ctx, 0, prefix+"w:*", 1000).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			keyCount++
+			members, err := rdb.SMembers(ctx, key).Result()
+			if err != nil {
+				log.Fatalf("Failed to read %s: %v", key, err)
+			}
+			for _, cpe := range members {
+				if _, ok := seen[cpe]; ok {
+					seen[cpe] = true
+					continue
+				}
+				dangling[key] = append(dangling[key], cpe)
+				danglingCount++
+			}
+		}
+		if err := iter.Err(); err != nil {
+			log.Fatalf("Failed to scan word sets: %v", err)
+		}
+
+		var orphaned []string
+		for cpe, ok := range seen {
+			if !ok {
+				orphaned = append(orphaned, cpe)
+			}
+		}
+
+		fmt.Printf("Checked %d word sets and %d ranked CPEs\n", keyCount, len(ranked))
+		fmt.Printf("%d word set entries without a rank\n", danglingCount)
+		fmt.Printf("%d ranked CPEs without a word set\n", len(orphaned))
+
+		if danglingCount == 0 && len(orphaned) == 0 {
+			fmt.Println("Index is consistent")
+			return
+		}
+		if !*fix {
+			fmt.Println("Run with -fix to remove these entries")
+			os.Exit(1)
+		}
+
+		pipe := rdb.Pipeline()
+		for key, cpes := range dangling {
+			members := make([]interface{}, len(cpes))
+			for i, cpe := range cpes {
+				members[i] = cpe
+			}
+			pipe.SRem(ctx, key, members...)
+			pipe.ZRem(ctx, prefix+"s:"+strings.TrimPrefix(key, prefix+"w:"), members...)
+		}
+		for _, cpe := range orphaned {
+			pipe.ZRem(ctx, prefix+"rank:cpe", cpe)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Fatalf("Failed to repair index: %v", err)
+		}
+		fmt.Printf("Removed %d word set entries and %d ranked CPEs\n", danglingCount, len(orphaned))
+	}
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:generate oapi-codegen -generate types -package api -o types.gen.go openapi.yaml
+
+// specYAML is the OpenAPI document of the HTTP API, which types.gen.go is
+// generated from.
+//
+//go:embed openapi.yaml
+var specYAML []byte
+
+// SpecJSON returns the OpenAPI document as JSON, as served on /openapi.json.
+func SpecJSON() ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(specYAML, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
@@ -1,26 +1,300 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// Defaults of the settings a config file or the environment may leave out.
+const (
+	DefaultPort       = 8000
+	DefaultValkeyHost = "localhost"
+	DefaultValkeyPort = 6379
+	DefaultCPEPath    = "./data/official-cpe-dictionary_v2.3.xml"
+	DefaultCPESource  = "https://nvd.nist.gov/feeds/xml/cpe/dictionary/official-cpe-dictionary_v2.3.xml.gz"
+)
+
+// Storage backends.
+const (
+	BackendValkey = "valkey"
+	BackendMemory = "memory"
+	BackendBolt   = "bolt"
+	BackendSQLite = "sqlite"
+)
+
 type Config struct {
 	Server struct {
 		Port int `yaml:"port"`
+		// GRPCPort serves the gRPC API on this port as well; zero disables it.
+		GRPCPort int `yaml:"grpc_port"`
+		// SlowQueryThreshold logs any request slower than this; zero disables it.
+		SlowQueryThreshold time.Duration `yaml:"slow_query_threshold"`
+		// MinRank is the default minimum rank for /search results.
+		MinRank float64 `yaml:"min_rank"`
+		// ResponseFormat is the default /search result shape, "compact" or "object".
+		ResponseFormat string `yaml:"response_format"`
+		// QueryAnalytics counts searched words for the /popular endpoint.
+		QueryAnalytics bool `yaml:"query_analytics"`
+		// Scoring is the default /search ordering, "rank", "coverage" or
+		// "idf".
+		Scoring string `yaml:"scoring"`
+		// CoverageWeight is the exponent applied to query coverage when
+		// scoring by coverage.
+		CoverageWeight float64 `yaml:"coverage_weight"`
+		// MaxPartialWords caps the words of a partial search; 0 uses the
+		// default of 5 and a negative value removes the limit.
+		MaxPartialWords int `yaml:"max_partial_words"`
+		// PartPriority orders equally ranked results by CPE part, e.g.
+		// [a, o, h] puts applications before operating systems and hardware.
+		PartPriority []string `yaml:"part_priority"`
+		// DisablePartial serves exact matches only, skipping the partial
+		// search fallback.
+		DisablePartial bool `yaml:"disable_partial"`
+		// TimeBudget bounds each /search; a partial search still scanning
+		// when it runs out returns the results found so far. Zero disables it.
+		TimeBudget time.Duration `yaml:"time_budget"`
+		// FlagSubstrings marks partial search results that only matched
+		// inside longer words with substring_only.
+		FlagSubstrings bool `yaml:"flag_substrings"`
+		// DefaultLimit caps the /search results of requests without a
+		// limit; 0 uses the default of 100 and a negative value removes the
+		// cap.
+		DefaultLimit int `yaml:"default_limit"`
+		// ExcludeDeprecated drops the CPE lines the dictionary deprecated
+		// from /search results by default.
+		ExcludeDeprecated bool `yaml:"exclude_deprecated"`
+		// Fuzzy matches misspelled query words to indexed words within
+		// FuzzyDistance edits when nothing matches exactly.
+		Fuzzy bool `yaml:"fuzzy"`
+		// FuzzyDistance is the most edits a fuzzy match allows; 0 uses
+		// the default of 2.
+		FuzzyDistance int `yaml:"fuzzy_distance"`
+		// ShutdownTimeout bounds how long the server waits for in-flight
+		// requests on SIGINT or SIGTERM; 0 uses the default of 10s and a
+		// negative value stops without waiting.
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+		// CacheSize is the number of index lookups the server keeps in its
+		// LRU cache; 0 uses the default of 10000 and a negative value
+		// disables the cache.
+		CacheSize int `yaml:"cache_size"`
+		// CacheTTL is how long a cached lookup is reused; 0 uses the
+		// default of 10m and a negative value disables the cache.
+		CacheTTL time.Duration `yaml:"cache_ttl"`
+		// ReimportInterval makes the server download the dictionary and
+		// import it again this often, in the background; zero disables it.
+		ReimportInterval time.Duration `yaml:"reimport_interval"`
+		// TLS serves HTTPS, and gRPC over TLS, when CertFile and KeyFile
+		// are set. The files are reloaded when they change.
+		TLS struct {
+			CertFile string `yaml:"cert_file"`
+			KeyFile  string `yaml:"key_file"`
+			// ClientCAFile requires clients to present a certificate
+			// signed by one of its CAs.
+			ClientCAFile string `yaml:"client_ca_file"`
+		} `yaml:"tls"`
+		// APIKeys maps key names to keys; when set, the lookup endpoints
+		// require one of them in an X-API-Key or Authorization: Bearer
+		// header.
+		APIKeys map[string]string `yaml:"api_keys"`
+		// APIKeysValkey also accepts the keys of the cpe-guesser:apikeys
+		// hash of Valkey database 0, key to name.
+		APIKeysValkey bool `yaml:"api_keys_valkey"`
+		// AdminKeys maps key names to the keys the admin endpoints
+		// require, in the same headers as APIKeys; the admin endpoints
+		// are disabled without them.
+		AdminKeys map[string]string `yaml:"admin_keys"`
+		// CORS lets browser front-ends served from other origins call the
+		// API.
+		CORS struct {
+			// AllowedOrigins are the origins allowed, "*" for any; empty
+			// disables CORS.
+			AllowedOrigins []string `yaml:"allowed_origins"`
+			// AllowedMethods are the methods allowed, GET and POST by
+			// default.
+			AllowedMethods []string `yaml:"allowed_methods"`
+		} `yaml:"cors"`
 	} `yaml:"server"`
 	Valkey struct {
 		Host string `yaml:"host"`
 		Port int    `yaml:"port"`
+		// ReadHost and ReadPort point the server's searches at a read
+		// replica; when unset searches use Host and Port.
+		ReadHost string `yaml:"read_host"`
+		ReadPort int    `yaml:"read_port"`
+		// StagingDB is the database import -swap builds the new index in;
+		// unset uses 9, or 10 when the index is served from 9.
+		StagingDB *int `yaml:"staging_db"`
+		// DB is the database the index is served from; unset uses 8.
+		DB *int `yaml:"db"`
+		// APIKeysDB is the database holding the API keys of
+		// server.api_keys_valkey; unset uses 0.
+		APIKeysDB *int `yaml:"api_keys_db"`
+		// Username and Password authenticate to Valkey with AUTH; the
+		// VALKEY_PASSWORD environment variable takes precedence over
+		// Password.
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		// TLS connects to Valkey over TLS, verifying its certificate
+		// against TLSCAFile when set and the system roots otherwise.
+		TLS       bool   `yaml:"tls"`
+		TLSCAFile string `yaml:"tls_ca_file"`
+		// Cluster connects to a Valkey cluster through the node at Host
+		// and Port. The index keys share a hash tag, so the index is held
+		// by a single shard, in database 0.
+		Cluster bool `yaml:"cluster"`
 	} `yaml:"valkey"`
 	CPE struct {
 		Path   string `yaml:"path"`
 		Source string `yaml:"source"`
+		// MetaSource is the NVD metadata file giving the size and SHA-256
+		// of the dictionary, checked after a download. Empty derives it
+		// from an .xml or .xml.gz Source and "none" skips the check.
+		MetaSource string `yaml:"meta_source"`
+		// DownloadTimeout bounds the whole download, ConnectTimeout and
+		// TLSTimeout the connection set-up. Zero values use the defaults.
+		DownloadTimeout time.Duration `yaml:"download_timeout"`
+		ConnectTimeout  time.Duration `yaml:"connect_timeout"`
+		TLSTimeout      time.Duration `yaml:"tls_timeout"`
+		// ReadBuffer is the size in bytes of the buffer the dictionary is
+		// read through during import.
+		ReadBuffer int `yaml:"read_buffer"`
+		// ImportWorkers is the number of batches the import writes to
+		// Valkey concurrently; zero means one per CPU.
+		ImportWorkers int `yaml:"import_workers"`
+		// BatchSize is the number of dictionary entries the import
+		// writes per batch; zero means 5000.
+		BatchSize int `yaml:"batch_size"`
+		// IndexReferences stores the reference URLs of each CPE.
+		IndexReferences bool `yaml:"index_references"`
+		// IndexVersions stores the version components of each CPE, so
+		// /unique can return full CPE names for a version.
+		IndexVersions bool `yaml:"index_versions"`
+		// IndexTitles also indexes each CPE line under the words of its
+		// dictionary title, so queries using product names as marketed
+		// find it, and returns the titles with object results.
+		IndexTitles bool `yaml:"index_titles"`
+		// CVEFeeds are NVD CVE JSON feeds, files or URLs, whose CVE
+		// counts raise the rank of the CPE lines they report.
+		CVEFeeds []string `yaml:"cve_feeds"`
+		// CVEWeight is the rank a CVE adds to its CPE lines; zero means 1.
+		CVEWeight float64 `yaml:"cve_weight"`
+		// IndexSnapshot is the URL of an index dump written by export,
+		// which the import and the memory backend load instead of
+		// reading the dictionary.
+		IndexSnapshot string `yaml:"index_snapshot"`
 	} `yaml:"cpe"`
+	// NVD configures the NVD Products API 2.0, which the import reads CPEs
+	// from instead of the dictionary file when enabled.
+	NVD struct {
+		Enabled bool   `yaml:"enabled"`
+		URL     string `yaml:"url"`
+		// APIKey raises the NVD rate limit; the NVD_API_KEY environment
+		// variable takes precedence.
+		APIKey string `yaml:"api_key"`
+		// ResultsPerPage is the page size requested, at most 10000.
+		ResultsPerPage int `yaml:"results_per_page"`
+		// RequestInterval is the minimum delay between two requests; zero
+		// uses the NVD rate limit for the key in use.
+		RequestInterval time.Duration `yaml:"request_interval"`
+	} `yaml:"nvd"`
+	// CVE configures the CVE-Search or vulnerability-lookup instance that
+	// search results are enriched from on request.
+	CVE struct {
+		Enabled bool `yaml:"enabled"`
+		// API is vulnerability-lookup (the default) or cve-search.
+		API string `yaml:"api"`
+		URL string `yaml:"url"`
+		// Timeout bounds each lookup; zero means 10s.
+		Timeout time.Duration `yaml:"timeout"`
+		// Latest is the number of CVE IDs listed with the count; zero
+		// means 5, negative lists none.
+		Latest int `yaml:"latest"`
+		// CacheTTL is how long an answer is reused; zero means 1h,
+		// negative disables the cache.
+		CacheTTL time.Duration `yaml:"cache_ttl"`
+	} `yaml:"cve"`
+	// Synonyms maps query words to the indexed words they stand for, e.g.
+	// msft: microsoft, adding to the built-in aliases and to those of
+	// SynonymsFile, and overriding their entries.
+	Synonyms map[string]SynonymWords `yaml:"synonyms"`
+	// SynonymsFile is a YAML file of aliases in the format of Synonyms.
+	SynonymsFile string `yaml:"synonyms_file"`
+	// Tokenize sets how vendors, products and query words are split into
+	// index words. Changing it takes a new import.
+	Tokenize struct {
+		// Separators are the characters words are split at; empty means
+		// "_-.".
+		Separators string `yaml:"separators"`
+		// Digits splits where letters and digits meet.
+		Digits bool `yaml:"digits"`
+		// CamelCase splits camelCase and PascalCase words.
+		CamelCase bool `yaml:"camel_case"`
+	} `yaml:"tokenize"`
+	// Stopwords are left out of the indexed words and of queries, so noise
+	// such as "inc" or "server" does not dominate partial matches. Changing
+	// them takes a new import.
+	Stopwords struct {
+		// DisableDefaults drops the built-in stopwords, keeping only Words.
+		DisableDefaults bool     `yaml:"disable_defaults"`
+		Words           []string `yaml:"words"`
+	} `yaml:"stopwords"`
+	// PURLMappings maps packages, as type/namespace/name or type/name, to
+	// the CPE line /purl returns for them, adding to or overriding the
+	// built-in table.
+	PURLMappings map[string]string `yaml:"purl_mappings"`
+	Storage      struct {
+		// Backend is where the server reads the index from: valkey (the
+		// default), memory, which builds the index in process at startup, or
+		// bolt or sqlite, a local index file written by the import.
+		Backend string `yaml:"backend"`
+		// Path is the index file of the bolt and sqlite backends.
+		Path string `yaml:"path"`
+	} `yaml:"storage"`
+	Tracing struct {
+		Enabled bool `yaml:"enabled"`
+		// Endpoint is the OTLP/HTTP collector host:port; empty uses the
+		// OTEL_EXPORTER_OTLP_* environment variables.
+		Endpoint string `yaml:"endpoint"`
+		Insecure bool   `yaml:"insecure"`
+	} `yaml:"tracing"`
+
+	sourceTmpl *template.Template
+	// fileSynonyms holds the aliases read from SynonymsFile
+	fileSynonyms map[string]SynonymWords
+}
+
+// SynonymWords are the words an alias stands for, written as a list or, for
+// a single word, as a string.
+type SynonymWords []string
+
+// UnmarshalYAML accepts a single word as well as a list.
+func (w *SynonymWords) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*w = SynonymWords{value.Value}
+		return nil
+	}
+	return value.Decode((*[]string)(w))
+}
+
+// sourceData is the data the CPE source template is executed with.
+type sourceData struct {
+	now time.Time
+}
+
+// Date formats the fetch time using a Go time layout, e.g. {{.Date "2006-01-02"}}.
+func (d sourceData) Date(layout string) string {
+	return d.now.Format(layout)
 }
 
 func Load(configPath string) (*Config, error) {
@@ -38,7 +312,7 @@ func Load(configPath string) (*Config, error) {
 		// Debug: check if file exists
 		_, err = os.Stat(configFile)
 		if os.IsNotExist(err) {
-			log.Printf("Debug: Config file not found at: %s", configFile)
+			slog.Debug("Config file not found", "path", configFile)
 			return nil, fmt.Errorf("config file does not exist: %s", configFile)
 		}
 	} else {
@@ -46,27 +320,558 @@ func Load(configPath string) (*Config, error) {
 		configFile = "settings.yaml"
 	}
 
-	log.Printf("Debug: Loading config from: %s", configFile)
-
-	// Read the file
+	// Read the file; without a path, a missing settings.yaml leaves the
+	// defaults and the environment
+	var config Config
 	data, err := os.ReadFile(configFile)
-	if err != nil {
+	switch {
+	case err == nil:
+		slog.Debug("Loading config", "path", configFile)
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("error parsing config file: %w", err)
+		}
+	case configPath == "" && os.IsNotExist(err):
+		slog.Debug("No settings.yaml found, using the defaults")
+	default:
 		return nil, fmt.Errorf("error reading config file %s: %w", configFile, err)
 	}
+	if err := applyEnv(&config); err != nil {
+		return nil, err
+	}
+	config.setDefaults()
 
-	// Parse YAML
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("error parsing config file: %w", err)
+	// Parse the source template so a bad placeholder fails at load time
+	config.sourceTmpl, err = template.New("source").Parse(config.CPE.Source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpe source template: %w", err)
+	}
+	if _, err := config.GetCPESource(time.Now()); err != nil {
+		return nil, err
+	}
+
+	if config.SynonymsFile != "" {
+		data, err := os.ReadFile(config.SynonymsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading synonyms file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &config.fileSynonyms); err != nil {
+			return nil, fmt.Errorf("error parsing synonyms file %s: %w", config.SynonymsFile, err)
+		}
 	}
 
 	return &config, nil
 }
 
+// setDefaults fills in the settings left unset that have no getter applying
+// a default.
+func (c *Config) setDefaults() {
+	if c.Server.Port == 0 {
+		c.Server.Port = DefaultPort
+	}
+	if c.Valkey.Host == "" {
+		c.Valkey.Host = DefaultValkeyHost
+	}
+	if c.Valkey.Port == 0 {
+		c.Valkey.Port = DefaultValkeyPort
+	}
+	if c.CPE.Path == "" {
+		c.CPE.Path = DefaultCPEPath
+	}
+	if c.CPE.Source == "" && !c.NVD.Enabled {
+		c.CPE.Source = DefaultCPESource
+	}
+}
+
+// Validate reports every problem found in the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(validPort(c.Server.Port), "server.port %d is not a valid port", c.Server.Port)
+	check(c.Server.GRPCPort == 0 || validPort(c.Server.GRPCPort) && c.Server.GRPCPort != c.Server.Port,
+		"server.grpc_port %d is not a valid port or is server.port", c.Server.GRPCPort)
+	check(c.Server.SlowQueryThreshold >= 0, "server.slow_query_threshold must not be negative")
+	check(c.Server.MinRank >= 0, "server.min_rank must not be negative")
+	check(c.Server.ResponseFormat == "" || c.Server.ResponseFormat == "compact" || c.Server.ResponseFormat == "object",
+		"server.response_format %q must be compact or object", c.Server.ResponseFormat)
+	check(c.Server.Scoring == "" || c.Server.Scoring == "rank" || c.Server.Scoring == "coverage" || c.Server.Scoring == "idf",
+		"server.scoring %q must be rank, coverage or idf", c.Server.Scoring)
+	check(c.Server.CoverageWeight >= 0, "server.coverage_weight must not be negative")
+	check(c.Server.TimeBudget >= 0, "server.time_budget must not be negative")
+	check(c.Server.ReimportInterval >= 0, "server.reimport_interval must not be negative")
+	check(c.Server.ReimportInterval == 0 || c.Storage.Backend != BackendMemory,
+		"server.reimport_interval needs the valkey, bolt or sqlite storage backend")
+	check((c.Server.TLS.CertFile == "") == (c.Server.TLS.KeyFile == ""),
+		"server.tls.cert_file and server.tls.key_file must be set together")
+	check(c.Server.TLS.ClientCAFile == "" || c.Server.TLS.CertFile != "",
+		"server.tls.client_ca_file needs server.tls.cert_file and key_file")
+	for _, p := range c.Server.PartPriority {
+		check(p == "a" || p == "o" || p == "h", "server.part_priority %q must be a, o or h", p)
+	}
+
+	check(c.Valkey.Host != "", "valkey.host is required")
+	check(validPort(c.Valkey.Port), "valkey.port %d is not a valid port", c.Valkey.Port)
+	check(c.Valkey.ReadPort == 0 || validPort(c.Valkey.ReadPort), "valkey.read_port %d is not a valid port", c.Valkey.ReadPort)
+	check(c.GetStagingDB() >= 0 && c.GetStagingDB() <= 15, "valkey.staging_db %d must be between 0 and 15", c.GetStagingDB())
+	check(c.GetIndexDB() >= 0 && c.GetIndexDB() <= 15, "valkey.db %d must be between 0 and 15", c.GetIndexDB())
+	check(c.Valkey.Cluster || c.GetStagingDB() != c.GetIndexDB(), "valkey.staging_db must differ from valkey.db %d", c.GetIndexDB())
+	check(c.GetAPIKeysDB() >= 0 && c.GetAPIKeysDB() <= 15, "valkey.api_keys_db %d must be between 0 and 15", c.GetAPIKeysDB())
+	check(c.Valkey.TLSCAFile == "" || c.Valkey.TLS, "valkey.tls_ca_file needs valkey.tls")
+	check(!c.Valkey.Cluster || c.Valkey.DB == nil || *c.Valkey.DB == 0,
+		"valkey.cluster only has database 0, set valkey.db to 0 or remove it")
+	check(!c.Valkey.Cluster || c.GetAPIKeysDB() == 0,
+		"valkey.cluster only has database 0, set valkey.api_keys_db to 0 or remove it")
+	check(!c.Valkey.Cluster || c.Valkey.ReadHost == "",
+		"valkey.read_host is not supported with valkey.cluster")
+
+	check(c.CPE.Path != "", "cpe.path is required")
+	if dir, ok := usableDir(filepath.Dir(c.GetCPEPath())); !ok {
+		check(false, "cpe.path directory %s is not a directory and cannot be created", dir)
+	}
+	check(c.CPE.Source != "" || c.NVD.Enabled || c.CPE.IndexSnapshot != "", "cpe.source is required")
+	if source, err := c.GetCPESource(time.Now()); err == nil && c.CPE.Source != "" {
+		u, err := url.Parse(source)
+		check(err == nil && u.Scheme != "" && (u.Host != "" || u.Path != ""),
+			"cpe.source %q is not a URL", source)
+	}
+	if meta := c.CPE.MetaSource; meta != "" && meta != "none" {
+		u, err := url.Parse(meta)
+		check(err == nil && u.Scheme != "" && (u.Host != "" || u.Path != ""),
+			"cpe.meta_source %q is not a URL", meta)
+	}
+	if snapshot := c.CPE.IndexSnapshot; snapshot != "" {
+		u, err := url.Parse(snapshot)
+		check(err == nil && u.Scheme != "" && (u.Host != "" || u.Path != ""),
+			"cpe.index_snapshot %q is not a URL", snapshot)
+	}
+	check(c.CPE.DownloadTimeout >= 0 && c.CPE.ConnectTimeout >= 0 && c.CPE.TLSTimeout >= 0,
+		"cpe download timeouts must not be negative")
+	check(c.CPE.ReadBuffer >= 0, "cpe.read_buffer must not be negative")
+	check(c.CPE.ImportWorkers >= 0, "cpe.import_workers must not be negative")
+	check(c.CPE.BatchSize >= 0, "cpe.batch_size must not be negative")
+	check(c.NVD.ResultsPerPage >= 0 && c.NVD.ResultsPerPage <= 10000,
+		"nvd.results_per_page %d must be between 0 and 10000", c.NVD.ResultsPerPage)
+	check(c.Server.FuzzyDistance >= 0, "server.fuzzy_distance must not be negative")
+	check(c.NVD.RequestInterval >= 0, "nvd.request_interval must not be negative")
+
+	switch c.Storage.Backend {
+	case "", BackendValkey, BackendMemory, BackendBolt, BackendSQLite:
+	default:
+		check(false, "storage.backend %q must be valkey, memory, bolt or sqlite", c.Storage.Backend)
+	}
+	check(c.Storage.Backend == "" || c.Storage.Backend == BackendValkey || !c.Server.QueryAnalytics,
+		"server.query_analytics needs the valkey storage backend")
+	check(c.Storage.Backend == "" || c.Storage.Backend == BackendValkey || !c.Server.APIKeysValkey,
+		"server.api_keys_valkey needs the valkey storage backend")
+	check(!c.Server.APIKeysValkey || c.Valkey.Cluster || c.GetAPIKeysDB() != c.GetIndexDB(),
+		"server.api_keys_valkey keeps its keys in valkey.api_keys_db %d, which valkey.db must not be as imports flush it", c.GetAPIKeysDB())
+	check(!c.Server.APIKeysValkey || c.Valkey.Cluster || c.GetAPIKeysDB() != c.GetStagingDB(),
+		"server.api_keys_valkey keeps its keys in valkey.api_keys_db %d, which valkey.staging_db must not be as import -swap flushes it", c.GetAPIKeysDB())
+	for _, m := range c.Server.CORS.AllowedMethods {
+		check(m == strings.ToUpper(m) && m != "", "server.cors.allowed_methods %q must be an uppercase HTTP method", m)
+	}
+	for name, key := range c.Server.APIKeys {
+		check(key != "", "server.api_keys %q has no key", name)
+	}
+	for name, key := range c.Server.AdminKeys {
+		check(key != "", "server.admin_keys %q has no key", name)
+	}
+
+	switch c.CVE.API {
+	case "", "vulnerability-lookup", "cve-search":
+	default:
+		check(false, "cve.api %q must be vulnerability-lookup or cve-search", c.CVE.API)
+	}
+	check(c.CVE.Timeout >= 0, "cve.timeout must not be negative")
+	check(c.CPE.CVEWeight >= 0, "cpe.cve_weight must not be negative")
+
+	for alias, words := range c.fileSynonyms {
+		check(len(words) > 0, "synonym %q of %s has no words", alias, c.SynonymsFile)
+	}
+	for alias, words := range c.Synonyms {
+		check(len(words) > 0, "synonym %q has no words", alias)
+	}
+	for pkg, cpe := range c.PURLMappings {
+		check(strings.HasPrefix(cpe, "cpe:2.3:") && strings.Count(cpe, ":") >= 4,
+			"purl_mappings %q: %q is not a CPE 2.3 line", pkg, cpe)
+	}
+
+	return errors.Join(errs...)
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// usableDir reports whether dir is a directory or can be created, as its
+// closest existing ancestor is one. It also returns the path that isn't.
+func usableDir(dir string) (string, bool) {
+	for {
+		fi, err := os.Stat(dir)
+		if err == nil {
+			return dir, fi.IsDir()
+		}
+		if !os.IsNotExist(err) {
+			return dir, false
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir, false
+		}
+		dir = parent
+	}
+}
+
 func (c *Config) GetRedisAddr() string {
 	return fmt.Sprintf("%s:%d", c.Valkey.Host, c.Valkey.Port)
 }
 
+// GetMaxPartialWords returns the word limit for partial searches, 5 by
+// default and 0 when unlimited.
+func (c *Config) GetMaxPartialWords() int {
+	switch {
+	case c.Server.MaxPartialWords < 0:
+		return 0
+	case c.Server.MaxPartialWords == 0:
+		return 5
+	}
+	return c.Server.MaxPartialWords
+}
+
+// GetFuzzyDistance returns the edit distance of fuzzy matches, 2 by default.
+func (c *Config) GetFuzzyDistance() int {
+	if c.Server.FuzzyDistance == 0 {
+		return 2
+	}
+	return c.Server.FuzzyDistance
+}
+
+// GetDefaultLimit returns the default /search result limit, 100 by default
+// and 0 when unlimited.
+func (c *Config) GetDefaultLimit() int {
+	switch {
+	case c.Server.DefaultLimit < 0:
+		return 0
+	case c.Server.DefaultLimit == 0:
+		return 100
+	}
+	return c.Server.DefaultLimit
+}
+
+// GetShutdownTimeout returns how long the server drains in-flight requests
+// on shutdown, 10s by default and 0 when it doesn't.
+func (c *Config) GetShutdownTimeout() time.Duration {
+	switch {
+	case c.Server.ShutdownTimeout == 0:
+		return 10 * time.Second
+	case c.Server.ShutdownTimeout < 0:
+		return 0
+	}
+	return c.Server.ShutdownTimeout
+}
+
+// GetCORSMethods returns the methods allowed cross-origin, GET and POST by
+// default.
+func (c *Config) GetCORSMethods() []string {
+	if len(c.Server.CORS.AllowedMethods) == 0 {
+		return []string{"GET", "POST"}
+	}
+	return c.Server.CORS.AllowedMethods
+}
+
+// GetReadRedisAddr returns the read replica address, or an empty string when
+// no replica is configured.
+func (c *Config) GetReadRedisAddr() string {
+	if c.Valkey.ReadHost == "" {
+		return ""
+	}
+	port := c.Valkey.ReadPort
+	if port == 0 {
+		port = c.Valkey.Port
+	}
+	return fmt.Sprintf("%s:%d", c.Valkey.ReadHost, port)
+}
+
+// GetIndexDB returns the database the index is served from, 8 by default
+// and 0 in a cluster.
+func (c *Config) GetIndexDB() int {
+	if c.Valkey.DB == nil {
+		if c.Valkey.Cluster {
+			return 0
+		}
+		return 8
+	}
+	return *c.Valkey.DB
+}
+
+// GetValkeyPassword returns the Valkey password, from VALKEY_PASSWORD when
+// set.
+func (c *Config) GetValkeyPassword() string {
+	if pw := os.Getenv("VALKEY_PASSWORD"); pw != "" {
+		return pw
+	}
+	return c.Valkey.Password
+}
+
+// GetStagingDB returns the staging database for swap imports, 9 by default,
+// or 10 when the index is served from database 9.
+func (c *Config) GetStagingDB() int {
+	if c.Valkey.StagingDB == nil {
+		if c.GetIndexDB() == 9 {
+			return 10
+		}
+		return 9
+	}
+	return *c.Valkey.StagingDB
+}
+
+// GetAPIKeysDB returns the database of the API keys managed at runtime, 0
+// by default.
+func (c *Config) GetAPIKeysDB() int {
+	if c.Valkey.APIKeysDB == nil {
+		return 0
+	}
+	return *c.Valkey.APIKeysDB
+}
+
+// GetDownloadTimeouts returns the overall, connect and TLS handshake timeouts
+// for downloading the CPE dictionary, filling in defaults for unset values.
+func (c *Config) GetDownloadTimeouts() (overall, connect, tls time.Duration) {
+	overall, connect, tls = c.CPE.DownloadTimeout, c.CPE.ConnectTimeout, c.CPE.TLSTimeout
+	if overall <= 0 {
+		overall = 10 * time.Minute
+	}
+	if connect <= 0 {
+		connect = 30 * time.Second
+	}
+	if tls <= 0 {
+		tls = 10 * time.Second
+	}
+	return overall, connect, tls
+}
+
+// GetReadBuffer returns the import read buffer size, 256 KiB by default:
+// BenchmarkXMLSource decodes about 10% faster with it than with 64 KiB, and
+// barely faster with 1 MiB.
+func (c *Config) GetReadBuffer() int {
+	if c.CPE.ReadBuffer <= 0 {
+		return 256 * 1024
+	}
+	return c.CPE.ReadBuffer
+}
+
+// GetImportWorkers returns the number of import workers, one per CPU by
+// default.
+func (c *Config) GetImportWorkers() int {
+	if c.CPE.ImportWorkers <= 0 {
+		return runtime.NumCPU()
+	}
+	return c.CPE.ImportWorkers
+}
+
+// GetBatchSize returns the number of entries per import batch, 5000 by
+// default.
+func (c *Config) GetBatchSize() int {
+	if c.CPE.BatchSize <= 0 {
+		return 5000
+	}
+	return c.CPE.BatchSize
+}
+
+// GetNVDURL returns the NVD Products API endpoint.
+func (c *Config) GetNVDURL() string {
+	if c.NVD.URL == "" {
+		return "https://services.nvd.nist.gov/rest/json/cpes/2.0"
+	}
+	return c.NVD.URL
+}
+
+// GetNVDAPIKey returns the NVD API key from the NVD_API_KEY environment
+// variable or the configuration, or an empty string when there is none.
+func (c *Config) GetNVDAPIKey() string {
+	if key := os.Getenv("NVD_API_KEY"); key != "" {
+		return key
+	}
+	return c.NVD.APIKey
+}
+
+// GetNVDResultsPerPage returns the NVD API page size, 10000 by default.
+func (c *Config) GetNVDResultsPerPage() int {
+	if c.NVD.ResultsPerPage == 0 {
+		return 10000
+	}
+	return c.NVD.ResultsPerPage
+}
+
+// GetNVDRequestInterval returns the delay between NVD API requests. The
+// default follows the NVD rate limits of 5 requests per 30 seconds without
+// an API key and 50 with one.
+func (c *Config) GetNVDRequestInterval() time.Duration {
+	switch {
+	case c.NVD.RequestInterval > 0:
+		return c.NVD.RequestInterval
+	case c.GetNVDAPIKey() != "":
+		return 600 * time.Millisecond
+	}
+	return 6 * time.Second
+}
+
+// GetCVEAPI returns the API of the CVE instance, vulnerability-lookup by
+// default.
+func (c *Config) GetCVEAPI() string {
+	if c.CVE.API == "" {
+		return "vulnerability-lookup"
+	}
+	return c.CVE.API
+}
+
+// GetCVEURL returns the base URL of the CVE instance, the public
+// vulnerability-lookup instance of CIRCL by default.
+func (c *Config) GetCVEURL() string {
+	if c.CVE.URL == "" {
+		return "https://vulnerability.circl.lu"
+	}
+	return c.CVE.URL
+}
+
+// GetCVETimeout returns the timeout of a CVE lookup, 10s by default.
+func (c *Config) GetCVETimeout() time.Duration {
+	if c.CVE.Timeout == 0 {
+		return 10 * time.Second
+	}
+	return c.CVE.Timeout
+}
+
+// GetCVELatest returns the number of CVE IDs listed per result, 5 by
+// default and 0 when none are.
+func (c *Config) GetCVELatest() int {
+	switch {
+	case c.CVE.Latest == 0:
+		return 5
+	case c.CVE.Latest < 0:
+		return 0
+	}
+	return c.CVE.Latest
+}
+
+// GetCacheSize returns the number of lookups the server caches, 10000 by
+// default and 0 when the cache is disabled.
+func (c *Config) GetCacheSize() int {
+	switch {
+	case c.Server.CacheSize < 0 || c.Server.CacheTTL < 0:
+		return 0
+	case c.Server.CacheSize == 0:
+		return 10000
+	}
+	return c.Server.CacheSize
+}
+
+// GetCacheTTL returns how long the server reuses a cached lookup, 10m by
+// default and 0 when the cache is disabled.
+func (c *Config) GetCacheTTL() time.Duration {
+	switch {
+	case c.Server.CacheTTL < 0 || c.Server.CacheSize < 0:
+		return 0
+	case c.Server.CacheTTL == 0:
+		return 10 * time.Minute
+	}
+	return c.Server.CacheTTL
+}
+
+// GetCVECacheTTL returns how long CVE lookups are cached, 1h by default and
+// 0 when they aren't.
+func (c *Config) GetCVECacheTTL() time.Duration {
+	switch {
+	case c.CVE.CacheTTL == 0:
+		return time.Hour
+	case c.CVE.CacheTTL < 0:
+		return 0
+	}
+	return c.CVE.CacheTTL
+}
+
+// GetSynonyms returns the aliases of SynonymsFile and Synonyms, the latter
+// taking precedence.
+func (c *Config) GetSynonyms() map[string][]string {
+	synonyms := make(map[string][]string, len(c.fileSynonyms)+len(c.Synonyms))
+	for alias, words := range c.fileSynonyms {
+		synonyms[alias] = words
+	}
+	for alias, words := range c.Synonyms {
+		synonyms[alias] = words
+	}
+	return synonyms
+}
+
+// GetCVEWeight returns the rank each CVE of the CVE feeds adds to a CPE line.
+func (c *Config) GetCVEWeight() float64 {
+	if c.CPE.CVEWeight == 0 {
+		return 1
+	}
+	return c.CPE.CVEWeight
+}
+
+// GetTokenSeparators returns the characters index words are split at.
+func (c *Config) GetTokenSeparators() string {
+	if c.Tokenize.Separators == "" {
+		return "_-."
+	}
+	return c.Tokenize.Separators
+}
+
+// GetCPESource returns the CPE source URL with any date placeholders expanded
+// for the given fetch time.
+func (c *Config) GetCPESource(now time.Time) (string, error) {
+	if c.sourceTmpl == nil {
+		return c.CPE.Source, nil
+	}
+	var buf bytes.Buffer
+	if err := c.sourceTmpl.Execute(&buf, sourceData{now: now}); err != nil {
+		return "", fmt.Errorf("invalid cpe source template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// GetCPEMetaSource returns the URL of the metadata file of the dictionary
+// downloaded from source, empty when there is none to check. The second
+// result reports whether it was configured rather than derived.
+func (c *Config) GetCPEMetaSource(source string) (string, bool) {
+	switch meta := c.CPE.MetaSource; meta {
+	case "none":
+		return "", false
+	case "":
+	default:
+		return meta, true
+	}
+	for _, ext := range []string{".xml.gz", ".xml"} {
+		if base, ok := strings.CutSuffix(source, ext); ok {
+			return base + ".meta", false
+		}
+	}
+	return "", false
+}
+
+// GetStoragePath returns the absolute path of the index file, by default
+// index.db (bolt) or index.sqlite next to the CPE dictionary.
+func (c *Config) GetStoragePath() string {
+	path := c.Storage.Path
+	if path == "" {
+		name := "index.db"
+		if c.Storage.Backend == BackendSQLite {
+			name = "index.sqlite"
+		}
+		path = filepath.Join(filepath.Dir(c.CPE.Path), name)
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		return absPath
+	}
+	return path
+}
+
 func (c *Config) GetCPEPath() string {
 	// Convert relative path to absolute if needed
 	if !filepath.IsAbs(c.CPE.Path) {
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the names of the environment variables overriding
+// settings. The rest of the name is the path of the setting in upper case,
+// joined by underscores: CPG_SERVER_PORT sets server.port and
+// CPG_SERVER_TLS_CERT_FILE server.tls.cert_file.
+const EnvPrefix = "CPG_"
+
+// applyEnv overrides the settings of c with the environment variables named
+// after them.
+func applyEnv(c *Config) error {
+	return applyEnvStruct(reflect.ValueOf(c).Elem(), EnvPrefix)
+}
+
+func applyEnvStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		env := prefix + strings.ToUpper(name)
+		if f.Type.Kind() == reflect.Struct {
+			if err := applyEnvStruct(v.Field(i), env+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+		val, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(v.Field(i), val); err != nil {
+			return fmt.Errorf("invalid %s: %w", env, err)
+		}
+	}
+	return nil
+}
+
+// setEnvValue sets v from the environment variable value val. Strings are
+// taken as is and lists of strings may be comma-separated; everything else,
+// including lists written [a, b] and maps written {k: v}, is parsed as YAML.
+func setEnvValue(v reflect.Value, val string) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(val)
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String &&
+		!strings.HasPrefix(strings.TrimSpace(val), "["):
+		var items []string
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+		return nil
+	}
+	p := reflect.New(v.Type())
+	if err := yaml.Unmarshal([]byte(val), p.Interface()); err != nil {
+		return err
+	}
+	v.Set(p.Elem())
+	return nil
+}
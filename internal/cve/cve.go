@@ -0,0 +1,189 @@
+// Package cve looks up the CVEs of CPEs in a CVE-Search or
+// vulnerability-lookup instance.
+package cve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported APIs.
+const (
+	APIVulnerabilityLookup = "vulnerability-lookup"
+	APICVESearch           = "cve-search"
+)
+
+// Summary is what a CPE's CVEs are reduced to.
+type Summary struct {
+	Count int `json:"count"`
+	// Latest holds the most recent CVE IDs, newest first
+	Latest []string `json:"latest,omitempty"`
+}
+
+// Client queries an instance and caches the answers.
+type Client struct {
+	api    string
+	url    string
+	latest int
+	ttl    time.Duration
+	http   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+type cached struct {
+	ids     []string
+	expires time.Time
+}
+
+// New returns a Client for the instance of api at baseURL. Summaries list
+// up to latest CVE IDs and answers are cached for ttl.
+func New(api, baseURL string, timeout time.Duration, latest int, ttl time.Duration) *Client {
+	return &Client{
+		api:    api,
+		url:    strings.TrimRight(baseURL, "/"),
+		latest: latest,
+		ttl:    ttl,
+		http:   &http.Client{Timeout: timeout},
+		cache:  make(map[string]cached),
+	}
+}
+
+// Lookup returns the CVE summary of cpe, a CPE line or full CPE.
+func (c *Client) Lookup(ctx context.Context, cpe string) (Summary, error) {
+	ids, err := c.ids(ctx, cpe)
+	if err != nil {
+		return Summary{}, err
+	}
+	s := Summary{Count: len(ids)}
+	if c.latest > 0 {
+		s.Latest = ids[:min(c.latest, len(ids))]
+	}
+	return s, nil
+}
+
+// ids returns the distinct CVE IDs of cpe, newest first.
+func (c *Client) ids(ctx context.Context, cpe string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.cache[cpe]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.ids, nil
+	}
+
+	var path string
+	switch c.api {
+	case APICVESearch:
+		path = "/api/cvefor/"
+	default:
+		path = "/api/vulnerability/cpesearch/"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+path+url.PathEscape(cpe), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return c.store(cpe, nil), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+
+	var records []json.RawMessage
+	if c.api == APICVESearch {
+		err = json.NewDecoder(resp.Body).Decode(&records)
+	} else {
+		// vulnerability-lookup groups the records by source; other keys
+		// are skipped
+		var bySource map[string]json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&bySource)
+		for _, raw := range bySource {
+			var recs []json.RawMessage
+			if json.Unmarshal(raw, &recs) == nil {
+				records = append(records, recs...)
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding CVEs of %s: %w", cpe, err)
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, rec := range records {
+		if id := recordID(rec); strings.HasPrefix(id, "CVE-") && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return newer(ids[i], ids[j]) })
+	return c.store(cpe, ids), nil
+}
+
+func (c *Client) store(cpe string, ids []string) []string {
+	if c.ttl <= 0 {
+		return ids
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache[cpe] = cached{ids: ids, expires: time.Now().Add(c.ttl)}
+	return ids
+}
+
+// recordID returns the CVE ID of a record, which is a CVE-Search entry, a
+// CVE JSON 5 record or an NVD API vulnerability.
+func recordID(rec json.RawMessage) string {
+	var r struct {
+		ID          string `json:"id"`
+		CVEMetadata struct {
+			CVEID string `json:"cveId"`
+		} `json:"cveMetadata"`
+		CVE struct {
+			ID string `json:"id"`
+		} `json:"cve"`
+	}
+	if json.Unmarshal(rec, &r) != nil {
+		return ""
+	}
+	for _, id := range []string{r.CVEMetadata.CVEID, r.CVE.ID, r.ID} {
+		if id != "" {
+			return strings.ToUpper(id)
+		}
+	}
+	return ""
+}
+
+// newer orders CVE IDs by year and then sequence number, descending.
+func newer(a, b string) bool {
+	ya, na := idParts(a)
+	yb, nb := idParts(b)
+	if ya != yb {
+		return ya > yb
+	}
+	return na > nb
+}
+
+func idParts(id string) (year, seq int) {
+	f := strings.Split(id, "-")
+	if len(f) != 3 {
+		return 0, 0
+	}
+	year, _ = strconv.Atoi(f[1])
+	seq, _ = strconv.Atoi(f[2])
+	return year, seq
+}
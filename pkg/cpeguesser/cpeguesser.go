@@ -0,0 +1,77 @@
+// Package cpeguesser embeds CPE guessing in other Go programs, such as
+// scanners, without running the cpe-guesser-go server. A Guesser searches an
+// index built by the import command, in Valkey/Redis or in a bolt index file,
+// the way the server's /search and /unique endpoints do.
+//
+// The package guesser underneath, reachable through Guesser.Client, has the
+// individual search passes, the search options and the result
+// post-processing of the server.
+package cpeguesser
+
+import (
+	"context"
+
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+	"github.com/go-redis/redis/v8"
+)
+
+// Result is a CPE found by a search, with its rank.
+type Result = guesser.Result
+
+// Guesser finds the CPEs matching a list of words.
+type Guesser struct {
+	client *guesser.Client
+	// close releases the index file opened by Open, nil otherwise
+	close func() error
+}
+
+// New returns a Guesser searching the index stored in rdb, which must use the
+// database the import wrote to, guesser.IndexDB unless configured otherwise.
+func New(rdb redis.UniversalClient) *Guesser {
+	return &Guesser{client: guesser.New(rdb)}
+}
+
+// NewWithStore returns a Guesser searching the index held by store.
+func NewWithStore(store guesser.Store) *Guesser {
+	return &Guesser{client: guesser.NewWithStore(store)}
+}
+
+// Open returns a Guesser searching the bolt index file at path, as written by
+// the import with the bolt storage backend. The file is opened read-only, so
+// an import may replace it meanwhile; Close releases it.
+func Open(path string) (*Guesser, error) {
+	store, err := guesser.OpenBoltStore(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Guesser{client: guesser.NewWithStore(store), close: store.Close}, nil
+}
+
+// Close releases the index file of a Guesser returned by Open. It does
+// nothing for the others, whose client or store belongs to the caller.
+func (g *Guesser) Close() error {
+	if g.close == nil {
+		return nil
+	}
+	return g.close()
+}
+
+// Client returns the guesser.Client the Guesser searches with, to set its
+// synonyms, tokenizer or stopwords or to run searches with other options.
+func (g *Guesser) Client() *guesser.Client {
+	return g.client
+}
+
+// Search returns the CPEs matching words, highest ranked first, as /search
+// finds them by default: those matching every word, or else those with words
+// containing some of them.
+func (g *Guesser) Search(ctx context.Context, words []string) ([]Result, error) {
+	res, _, err := g.client.Search(ctx, words, guesser.SearchOptions{})
+	return res, err
+}
+
+// Unique returns the best CPE for words as /unique does, or an empty string
+// when nothing matches.
+func (g *Guesser) Unique(ctx context.Context, words []string) (string, error) {
+	return g.client.Unique(ctx, words)
+}
@@ -0,0 +1,55 @@
+package cpeguesser
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/aringo/cpe-guesser-go/pkg/guesser"
+)
+
+func TestGuesser(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "index.db")
+	store, err := guesser.OpenBoltStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := store.NewBatch()
+	for cpe, words := range map[string][]string{
+		"cpe:2.3:a:apache:tomcat":      {"apache", "tomcat"},
+		"cpe:2.3:a:apache:http_server": {"apache", "http", "server"},
+	} {
+		for _, w := range words {
+			batch.AddWord(w, cpe)
+		}
+		batch.SetRank(words, cpe, float64(len(words)))
+	}
+	if err := batch.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	res, err := g.Search(ctx, []string{"apache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].CPE != "cpe:2.3:a:apache:http_server" {
+		t.Errorf("Search(apache) = %v, want http_server first of 2", res)
+	}
+	cpe, err := g.Unique(ctx, []string{"tomc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpe != "cpe:2.3:a:apache:tomcat" {
+		t.Errorf("Unique(tomc) = %q, want cpe:2.3:a:apache:tomcat", cpe)
+	}
+}
@@ -0,0 +1,82 @@
+package guesser
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// Completion is an indexed word completing a prefix.
+type Completion struct {
+	Word string `json:"word"`
+	// CPEs is the number of CPE lines indexed under the word
+	CPEs int `json:"cpes"`
+}
+
+// maxCompletionProducts caps the CPE lines of the completed words that
+// Autocomplete ranks.
+const maxCompletionProducts = 5000
+
+// topCompletions returns the n completions with the most CPEs, ties in
+// lexical order.
+func topCompletions(c []Completion, n int) []Completion {
+	sort.Slice(c, func(i, j int) bool {
+		if c[i].CPEs != c[j].CPEs {
+			return c[i].CPEs > c[j].CPEs
+		}
+		return c[i].Word < c[j].Word
+	})
+	if n >= 0 && len(c) > n {
+		c = c[:n]
+	}
+	return c
+}
+
+// Autocomplete returns up to n indexed words starting with prefix, those
+// indexing the most CPE lines first, and up to n CPE lines whose vendor or
+// product starts with prefix, highest rank first. The CPE lines are drawn
+// from the completed words.
+func (c *Client) Autocomplete(ctx context.Context, prefix string, n int) (_ []Completion, _ []Result, err error) {
+	ctx, span := tracer.Start(ctx, "guesser.Autocomplete")
+	defer func() { endSpan(span, err) }()
+
+	prefix = Normalize(prefix)
+	if prefix == "" {
+		return nil, nil, nil
+	}
+	words, err := c.store.Complete(ctx, prefix, n)
+	if err != nil || len(words) == 0 {
+		return words, nil, err
+	}
+
+	list := make([]string, len(words))
+	for i, w := range words {
+		list[i] = w.Word
+	}
+	sets, err := c.store.Members(ctx, list)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[string]bool)
+	var cpes []string
+	for _, set := range sets {
+		for _, cpe := range set {
+			if seen[cpe] || len(cpes) == maxCompletionProducts {
+				continue
+			}
+			seen[cpe] = true
+			parts := SplitCPE(cpe)
+			if len(parts) >= 5 && (strings.HasPrefix(Normalize(parts[3]), prefix) || strings.HasPrefix(Normalize(parts[4]), prefix)) {
+				cpes = append(cpes, cpe)
+			}
+		}
+	}
+	res, err := c.rank(ctx, cpes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(res) > n {
+		res = res[:n]
+	}
+	return words, res, nil
+}
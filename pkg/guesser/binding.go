@@ -0,0 +1,203 @@
+package guesser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Binding is an encoding of CPE names, as defined by the CPE 2.3 naming
+// specification (NISTIR 7695).
+type Binding string
+
+const (
+	// BindingFS is the formatted string binding, cpe:2.3:a:vendor:product,
+	// which the index stores. It is the default.
+	BindingFS Binding = "fs"
+	// BindingURI is the CPE 2.2 compatible URI binding, cpe:/a:vendor:product.
+	BindingURI Binding = "uri"
+	// BindingWFN is the well-formed name, wfn:[part="a",vendor="vendor",...].
+	BindingWFN Binding = "wfn"
+)
+
+// ParseBinding validates a binding name. An empty name is BindingFS.
+func ParseBinding(name string) (Binding, error) {
+	switch b := Binding(name); b {
+	case "":
+		return BindingFS, nil
+	case BindingFS, BindingURI, BindingWFN:
+		return b, nil
+	default:
+		return "", fmt.Errorf("unknown binding %q", name)
+	}
+}
+
+// wfnAttributes are the WFN attributes in formatted string order.
+var wfnAttributes = []string{
+	"part", "vendor", "product", "version", "update", "edition",
+	"language", "sw_edition", "target_sw", "target_hw", "other",
+}
+
+// wfnValue is a WFN attribute value: the logical value ANY or NA, or a
+// string with non-alphanumeric characters quoted by a backslash.
+type wfnValue struct {
+	logical string
+	val     string
+}
+
+var anyValue = wfnValue{logical: "ANY"}
+
+// Rebind converts a CPE formatted string into binding b. Attributes missing
+// from a shortened formatted string, like the stored vendor/product lines,
+// are ANY.
+func Rebind(cpe string, b Binding) (string, error) {
+	if b == "" || b == BindingFS {
+		return cpe, nil
+	}
+	wfn, err := unbindFS(cpe)
+	if err != nil {
+		return "", err
+	}
+	if b == BindingWFN {
+		return formatWFN(wfn), nil
+	}
+	return bindURI(wfn), nil
+}
+
+// RebindResults converts the CPE of every result, and the CPE replacing it
+// when deprecated, into binding b in place.
+func RebindResults(res []Result, b Binding) error {
+	for i := range res {
+		cpe, err := Rebind(res[i].CPE, b)
+		if err != nil {
+			return err
+		}
+		res[i].CPE = cpe
+		if res[i].DeprecatedBy != "" {
+			if res[i].DeprecatedBy, err = Rebind(res[i].DeprecatedBy, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unbindFS turns a formatted string into the values of its WFN attributes.
+func unbindFS(cpe string) ([]wfnValue, error) {
+	parts := SplitCPE(cpe)
+	if len(parts) < 3 || parts[0] != "cpe" || parts[1] != "2.3" || len(parts)-2 > len(wfnAttributes) {
+		return nil, fmt.Errorf("invalid CPE 2.3 formatted string %q", cpe)
+	}
+	wfn := make([]wfnValue, len(wfnAttributes))
+	for i := range wfn {
+		wfn[i] = anyValue
+		if i+2 < len(parts) {
+			wfn[i] = unbindValueFS(parts[i+2])
+		}
+	}
+	return wfn, nil
+}
+
+// unbindValueFS converts a formatted string component into a WFN value,
+// quoting the characters the formatted string leaves bare, such as "-" and
+// ".". The wildcards "*" and "?" stay unquoted.
+func unbindValueFS(s string) wfnValue {
+	switch s {
+	case "*", "":
+		return anyValue
+	case "-":
+		return wfnValue{logical: "NA"}
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isAlnum(c) || c == '_' || c == '*' || c == '?':
+			b.WriteByte(c)
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		}
+	}
+	return wfnValue{val: b.String()}
+}
+
+// formatWFN renders a WFN, leaving out the attributes that are ANY.
+func formatWFN(wfn []wfnValue) string {
+	var attrs []string
+	for i, v := range wfn {
+		switch {
+		case v.logical == "ANY":
+		case v.logical != "":
+			attrs = append(attrs, wfnAttributes[i]+"="+v.logical)
+		default:
+			attrs = append(attrs, wfnAttributes[i]+`="`+v.val+`"`)
+		}
+	}
+	return "wfn:[" + strings.Join(attrs, ",") + "]"
+}
+
+// bindURI renders a WFN as a URI. The extended attributes are packed into
+// the edition component with "~" when any of them is set.
+func bindURI(wfn []wfnValue) string {
+	comps := make([]string, 7)
+	for i := range comps {
+		comps[i] = bindValueURI(wfn[i])
+	}
+	extended := wfn[7:]
+	for _, v := range extended {
+		if v != anyValue {
+			edition := "~" + comps[5]
+			for _, e := range extended {
+				edition += "~" + bindValueURI(e)
+			}
+			comps[5] = edition
+			break
+		}
+	}
+	return strings.TrimRight("cpe:/"+strings.Join(comps, ":"), ":")
+}
+
+// bindValueURI percent-encodes a WFN value for the URI binding.
+func bindValueURI(v wfnValue) string {
+	switch v.logical {
+	case "ANY":
+		return ""
+	case "NA":
+		return "-"
+	}
+	var b strings.Builder
+	for i := 0; i < len(v.val); i++ {
+		c := v.val[i]
+		switch {
+		case isAlnum(c) || c == '_':
+			b.WriteByte(c)
+		case c == '\\' && i+1 < len(v.val):
+			i++
+			b.WriteString(pctEncode(v.val[i]))
+		case c == '?':
+			b.WriteString("%01")
+		case c == '*':
+			b.WriteString("%02")
+		default:
+			b.WriteString(pctEncode(c))
+		}
+	}
+	return b.String()
+}
+
+// pctEncode encodes a quoted character for the URI binding, where "-" and
+// "." need no encoding.
+func pctEncode(c byte) string {
+	if c == '-' || c == '.' {
+		return string(c)
+	}
+	return fmt.Sprintf("%%%02x", c)
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
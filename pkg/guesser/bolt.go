@@ -0,0 +1,483 @@
+package guesser
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of a BoltStore. Sets are stored as keys made of the set name and a
+// member separated by boltSep, with empty values.
+var (
+	boltWords      = []byte("words")
+	boltRanks      = []byte("ranks")
+	boltProducts   = []byte("products")
+	boltTitles     = []byte("titles")
+	boltRefs       = []byte("refs")
+	boltVersions   = []byte("versions")
+	boltMeta       = []byte("meta")
+	boltDeprecated = []byte("deprecated")
+
+	boltBuckets = [][]byte{boltWords, boltRanks, boltProducts, boltTitles, boltRefs, boltVersions, boltMeta, boltDeprecated}
+)
+
+const boltSep = "\x00"
+
+// BoltStore is a Store keeping the index in a bbolt database file, so it
+// survives restarts without a Valkey/Redis process.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the index file at path, creating it unless readOnly is
+// set. A file opened for writing is locked against every other open.
+func OpenBoltStore(path string, readOnly bool) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{ReadOnly: readOnly, Timeout: 5 * time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%w: %s is locked by another process", err, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !readOnly {
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, name := range boltBuckets {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the index file.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// Reset empties the index.
+func (s *BoltStore) Reset() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Len returns the number of indexed CPEs.
+func (s *BoltStore) Len() (int, error) {
+	n := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(boltRanks); b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (s *BoltStore) Intersect(ctx context.Context, words []string) ([]string, error) {
+	sets, err := s.Members(ctx, words)
+	if err != nil || len(sets) == 0 {
+		return nil, err
+	}
+	out := sets[0]
+	for _, set := range sets[1:] {
+		in := make(map[string]struct{}, len(set))
+		for _, m := range set {
+			in[m] = struct{}{}
+		}
+		kept := out[:0]
+		for _, m := range out {
+			if _, ok := in[m]; ok {
+				kept = append(kept, m)
+			}
+		}
+		out = kept
+	}
+	return out, nil
+}
+
+func (s *BoltStore) Members(ctx context.Context, words []string) ([][]string, error) {
+	return s.Sample(ctx, words, -1)
+}
+
+// Sample returns the first n members of each set in key order. A negative n
+// returns every member.
+func (s *BoltStore) Sample(ctx context.Context, words []string, n int) ([][]string, error) {
+	out := make([][]string, len(words))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for i, w := range words {
+			out[i] = scanSet(tx.Bucket(boltWords), w, n)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *BoltStore) PartialMatch(ctx context.Context, sub string, fn func(cpes []string) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltWords)
+		if b == nil {
+			return nil
+		}
+		var word string
+		var cpes []string
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			w, cpe, _ := strings.Cut(string(k), boltSep)
+			if w != word {
+				if len(cpes) > 0 {
+					if err := fn(cpes); err != nil {
+						return err
+					}
+					cpes = nil
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				word = w
+			}
+			if strings.Contains(w, sub) {
+				cpes = append(cpes, cpe)
+			}
+		}
+		if len(cpes) > 0 {
+			return fn(cpes)
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Words(ctx context.Context, fn func(words []string) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltWords)
+		if b == nil {
+			return nil
+		}
+		chunk := make([]string, 0, wordsChunk)
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; {
+			w, _, _ := strings.Cut(string(k), boltSep)
+			if chunk = append(chunk, w); len(chunk) == wordsChunk {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if err := fn(chunk); err != nil {
+					return err
+				}
+				chunk = chunk[:0]
+			}
+			// Skip the other CPEs of the word
+			k, _ = c.Seek([]byte(w + boltSep + "\xff"))
+		}
+		if len(chunk) > 0 {
+			return fn(chunk)
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Complete(ctx context.Context, prefix string, n int) ([]Completion, error) {
+	var out []Completion
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltWords)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = c.Next() {
+			w, _, _ := strings.Cut(string(k), boltSep)
+			if len(out) > 0 && out[len(out)-1].Word == w {
+				out[len(out)-1].CPEs++
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			out = append(out, Completion{Word: w, CPEs: 1})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return topCompletions(out, n), nil
+}
+
+func (s *BoltStore) Frequencies(ctx context.Context, words []string) ([]int, int, error) {
+	df := make([]int, len(words))
+	total := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltWords)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for i, w := range words {
+			prefix := setKey(w, "")
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				df[i]++
+			}
+		}
+		if r := tx.Bucket(boltRanks); r != nil {
+			total = r.Stats().KeyN
+		}
+		return nil
+	})
+	return df, total, err
+}
+
+func (s *BoltStore) Ranks(ctx context.Context, cpes []string) ([]float64, error) {
+	ranks := make([]float64, len(cpes))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltRanks)
+		if b == nil {
+			return nil
+		}
+		for i, cpe := range cpes {
+			ranks[i] = decodeFloat(b.Get([]byte(cpe)))
+		}
+		return nil
+	})
+	return ranks, err
+}
+
+func (s *BoltStore) Products(ctx context.Context, vendor string) ([]string, error) {
+	var out []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		out = scanSet(tx.Bucket(boltProducts), Normalize(vendor), -1)
+		return nil
+	})
+	return out, err
+}
+
+func (s *BoltStore) Titles(ctx context.Context, cpes []string) ([]string, error) {
+	titles := make([]string, len(cpes))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltTitles)
+		if b == nil {
+			return nil
+		}
+		for i, cpe := range cpes {
+			titles[i] = string(b.Get([]byte(cpe)))
+		}
+		return nil
+	})
+	return titles, err
+}
+
+func (s *BoltStore) References(ctx context.Context, cpes []string) ([][]string, error) {
+	out := make([][]string, len(cpes))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for i, cpe := range cpes {
+			out[i] = scanSet(tx.Bucket(boltRefs), cpe, -1)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *BoltStore) Versions(ctx context.Context, cpe string) ([]string, error) {
+	var out []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		out = scanSet(tx.Bucket(boltVersions), cpe, -1)
+		return nil
+	})
+	return out, err
+}
+
+func (s *BoltStore) Deprecated(ctx context.Context, cpes []string) (map[string]string, error) {
+	out := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltDeprecated)
+		if b == nil {
+			return nil
+		}
+		for _, cpe := range cpes {
+			if repl := b.Get([]byte(cpe)); repl != nil {
+				out[cpe] = string(repl)
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *BoltStore) LastImport(ctx context.Context) (time.Time, error) {
+	var val []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(boltMeta); b != nil {
+			val = b.Get([]byte(LastImportKey))
+		}
+		return nil
+	})
+	if err != nil || val == nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, string(val))
+}
+
+func (s *BoltStore) Checkpoint(ctx context.Context) (map[string]string, error) {
+	out := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltMeta)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek([]byte(checkpointPrefix)); k != nil && bytes.HasPrefix(k, []byte(checkpointPrefix)); k, v = c.Next() {
+			out[string(k[len(checkpointPrefix):])] = string(v)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *BoltStore) NewBatch() Batch {
+	return &boltBatch{db: s.db}
+}
+
+// scanSet returns up to n members of the set name in b, all of them when n
+// is negative.
+func scanSet(b *bolt.Bucket, name string, n int) []string {
+	if b == nil {
+		return nil
+	}
+	prefix := []byte(name + boltSep)
+	var out []string
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && len(out) != n; k, _ = c.Next() {
+		out = append(out, string(k[len(prefix):]))
+	}
+	return out
+}
+
+func setKey(name, member string) []byte {
+	return []byte(name + boltSep + member)
+}
+
+func encodeFloat(f float64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(f))
+	return buf
+}
+
+func decodeFloat(buf []byte) float64 {
+	if len(buf) != 8 {
+		return 0
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(buf))
+}
+
+// boltBatch queues writes until Exec applies them in one transaction.
+type boltBatch struct {
+	db  *bolt.DB
+	ops []func(tx *bolt.Tx) error
+}
+
+func (b *boltBatch) put(bucket []byte, key, val []byte) {
+	b.ops = append(b.ops, func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, val)
+	})
+}
+
+func (b *boltBatch) AddWord(word, cpe string) {
+	b.put(boltWords, setKey(word, cpe), nil)
+}
+
+func (b *boltBatch) AddProduct(vendor, cpe string) {
+	b.put(boltProducts, setKey(Normalize(vendor), cpe), nil)
+}
+
+func (b *boltBatch) SetTitle(cpe, title string) {
+	b.put(boltTitles, []byte(cpe), []byte(title))
+}
+
+func (b *boltBatch) AddReference(cpe, ref string) {
+	b.put(boltRefs, setKey(cpe, ref), nil)
+}
+
+func (b *boltBatch) AddVersion(cpe, version string) {
+	b.put(boltVersions, setKey(cpe, version), nil)
+}
+
+func (b *boltBatch) SetDeprecated(cpe, replacement string) {
+	// A non-nil empty value still marks the key as present
+	b.put(boltDeprecated, []byte(cpe), append([]byte{}, replacement...))
+}
+
+func (b *boltBatch) ClearDeprecated(cpe string) {
+	b.ops = append(b.ops, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDeprecated).Delete([]byte(cpe))
+	})
+}
+
+func (b *boltBatch) SetLastImport(t time.Time) {
+	b.put(boltMeta, []byte(LastImportKey), []byte(t.UTC().Format(time.RFC3339)))
+}
+
+func (b *boltBatch) SetCheckpoint(field, value string) {
+	b.put(boltMeta, []byte(checkpointPrefix+field), []byte(value))
+}
+
+func (b *boltBatch) ClearCheckpoint() {
+	b.ops = append(b.ops, func(tx *bolt.Tx) error {
+		var keys [][]byte
+		c := tx.Bucket(boltMeta).Cursor()
+		for k, _ := c.Seek([]byte(checkpointPrefix)); k != nil && bytes.HasPrefix(k, []byte(checkpointPrefix)); k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := tx.Bucket(boltMeta).Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltBatch) IncrRank(words []string, cpe string, delta float64) {
+	b.ops = append(b.ops, func(tx *bolt.Tx) error {
+		ranks := tx.Bucket(boltRanks)
+		return ranks.Put([]byte(cpe), encodeFloat(decodeFloat(ranks.Get([]byte(cpe)))+delta))
+	})
+}
+
+func (b *boltBatch) SetRank(words []string, cpe string, rank float64) {
+	b.put(boltRanks, []byte(cpe), encodeFloat(rank))
+}
+
+func (b *boltBatch) Exec(ctx context.Context) error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, op := range b.ops {
+			if err := op(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	b.ops = b.ops[:0]
+	return err
+}
@@ -0,0 +1,315 @@
+package guesser
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheCheckInterval is how often CachedStore checks the last import of the
+// store it wraps, so answers older than an import are dropped within it.
+const cacheCheckInterval = 10 * time.Second
+
+// CachedStore is a Store keeping the answers of another Store for repeated
+// lookups, such as the word sets of hot queries, in an LRU cache. Answers
+// expire after a TTL, and the whole cache is dropped when the wrapped store
+// records a new import. Lookups by CPE, such as Ranks and Titles, are cached
+// per CPE. The scans of PartialMatch and Words, and the random samples of
+// Sample, are not cached.
+type CachedStore struct {
+	Store
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// lastImport is the import the cached answers come from, checked
+	// against the store at most every cacheCheckInterval
+	lastImport time.Time
+	checked    time.Time
+
+	hits, misses atomic.Int64
+}
+
+type cacheEntry struct {
+	key     string
+	val     any
+	expires time.Time
+}
+
+// CacheStats are the counters of a CachedStore.
+type CacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+// NewCachedStore returns store with up to size answers cached for ttl each.
+func NewCachedStore(store Store, size int, ttl time.Duration) *CachedStore {
+	return &CachedStore{
+		Store:   store,
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Stats returns the hits, misses and size of the cache.
+func (s *CachedStore) Stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return CacheStats{Hits: s.hits.Load(), Misses: s.misses.Load(), Entries: s.lru.Len()}
+}
+
+// Invalidate drops every cached answer.
+func (s *CachedStore) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lru.Init()
+	clear(s.entries)
+}
+
+// checkImport drops the cache when the store recorded an import since the
+// last check.
+func (s *CachedStore) checkImport(ctx context.Context) {
+	s.mu.Lock()
+	due := time.Since(s.checked) >= cacheCheckInterval
+	if due {
+		s.checked = time.Now()
+	}
+	s.mu.Unlock()
+	if !due {
+		return
+	}
+	last, err := s.Store.LastImport(ctx)
+	if err != nil {
+		// Check again on the next lookup
+		s.mu.Lock()
+		s.checked = time.Time{}
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !last.Equal(s.lastImport) {
+		s.lastImport = last
+		s.lru.Init()
+		clear(s.entries)
+	}
+}
+
+func (s *CachedStore) get(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		s.lru.Remove(el)
+		delete(s.entries, key)
+		return nil, false
+	}
+	s.lru.MoveToFront(el)
+	return e.val, true
+}
+
+func (s *CachedStore) put(key string, val any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &cacheEntry{key: key, val: val, expires: time.Now().Add(s.ttl)}
+	if el, ok := s.entries[key]; ok {
+		el.Value = e
+		s.lru.MoveToFront(el)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(e)
+	for s.lru.Len() > s.size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// cached returns the answer cached under key, or loads and caches it.
+// Answers are cloned both ways, so callers may modify them.
+func cached[T any](ctx context.Context, s *CachedStore, key string, clone func(T) T, load func() (T, error)) (T, error) {
+	s.checkImport(ctx)
+	if v, ok := s.get(key); ok {
+		s.hits.Add(1)
+		return clone(v.(T)), nil
+	}
+	s.misses.Add(1)
+	v, err := load()
+	if err != nil {
+		return v, err
+	}
+	s.put(key, clone(v))
+	return v, nil
+}
+
+// cachedEach returns the answer for each of cpes, cached one CPE at a time
+// under op so that lookups of overlapping lists share their entries, and
+// loads the CPEs missing from the cache in one lookup.
+func cachedEach[T any](ctx context.Context, s *CachedStore, op string, cpes []string, clone func(T) T, load func([]string) ([]T, error)) ([]T, error) {
+	s.checkImport(ctx)
+	out := make([]T, len(cpes))
+	var missing []string
+	var at []int
+	for i, cpe := range cpes {
+		if v, ok := s.get(cacheKey(op, cpe)); ok {
+			s.hits.Add(1)
+			out[i] = clone(v.(T))
+			continue
+		}
+		s.misses.Add(1)
+		missing = append(missing, cpe)
+		at = append(at, i)
+	}
+	if len(missing) == 0 {
+		return out, nil
+	}
+	vals, err := load(missing)
+	if err != nil {
+		return nil, err
+	}
+	for j, v := range vals {
+		out[at[j]] = v
+		s.put(cacheKey(op, missing[j]), clone(v))
+	}
+	return out, nil
+}
+
+// cacheKey joins the name of a lookup and its arguments into a cache key.
+func cacheKey(op string, args ...string) string {
+	return op + "\x00" + strings.Join(args, "\x00")
+}
+
+func identity[T any](v T) T { return v }
+
+func cloneSets(sets [][]string) [][]string {
+	out := make([][]string, len(sets))
+	for i, set := range sets {
+		out[i] = slices.Clone(set)
+	}
+	return out
+}
+
+func (s *CachedStore) Intersect(ctx context.Context, words []string) ([]string, error) {
+	return cached(ctx, s, cacheKey("intersect", words...), slices.Clone[[]string], func() ([]string, error) {
+		return s.Store.Intersect(ctx, words)
+	})
+}
+
+// IntersectRanked caches the ranked intersection, which the wrapped store
+// computes in one step when it is a RankedIntersecter.
+func (s *CachedStore) IntersectRanked(ctx context.Context, words []string) ([]Result, error) {
+	return cached(ctx, s, cacheKey("intersect-ranked", words...), slices.Clone[[]Result], func() ([]Result, error) {
+		return intersectRanked(ctx, s.Store, words)
+	})
+}
+
+// FuzzyCandidates passes the lookup on to the wrapped store when it is a
+// FuzzyCandidater, and returns false otherwise. The candidates, which may be
+// many, are not cached.
+func (s *CachedStore) FuzzyCandidates(ctx context.Context, trigrams []string, minShared int) ([]string, bool, error) {
+	if fc, ok := s.Store.(FuzzyCandidater); ok {
+		return fc.FuzzyCandidates(ctx, trigrams, minShared)
+	}
+	return nil, false, nil
+}
+
+func (s *CachedStore) Members(ctx context.Context, words []string) ([][]string, error) {
+	return cached(ctx, s, cacheKey("members", words...), cloneSets, func() ([][]string, error) {
+		return s.Store.Members(ctx, words)
+	})
+}
+
+func (s *CachedStore) Complete(ctx context.Context, prefix string, n int) ([]Completion, error) {
+	return cached(ctx, s, cacheKey("complete", prefix, strconv.Itoa(n)), slices.Clone[[]Completion], func() ([]Completion, error) {
+		return s.Store.Complete(ctx, prefix, n)
+	})
+}
+
+type frequencies struct {
+	df    []int
+	total int
+}
+
+func (s *CachedStore) Frequencies(ctx context.Context, words []string) ([]int, int, error) {
+	f, err := cached(ctx, s, cacheKey("frequencies", words...),
+		func(f frequencies) frequencies { return frequencies{slices.Clone(f.df), f.total} },
+		func() (frequencies, error) {
+			df, total, err := s.Store.Frequencies(ctx, words)
+			return frequencies{df, total}, err
+		})
+	return f.df, f.total, err
+}
+
+func (s *CachedStore) Ranks(ctx context.Context, cpes []string) ([]float64, error) {
+	return cachedEach(ctx, s, "rank", cpes, identity[float64], func(cpes []string) ([]float64, error) {
+		return s.Store.Ranks(ctx, cpes)
+	})
+}
+
+func (s *CachedStore) Products(ctx context.Context, vendor string) ([]string, error) {
+	return cached(ctx, s, cacheKey("products", vendor), slices.Clone[[]string], func() ([]string, error) {
+		return s.Store.Products(ctx, vendor)
+	})
+}
+
+func (s *CachedStore) Titles(ctx context.Context, cpes []string) ([]string, error) {
+	return cachedEach(ctx, s, "title", cpes, identity[string], func(cpes []string) ([]string, error) {
+		return s.Store.Titles(ctx, cpes)
+	})
+}
+
+func (s *CachedStore) References(ctx context.Context, cpes []string) ([][]string, error) {
+	return cachedEach(ctx, s, "references", cpes, slices.Clone[[]string], func(cpes []string) ([][]string, error) {
+		return s.Store.References(ctx, cpes)
+	})
+}
+
+func (s *CachedStore) Versions(ctx context.Context, cpe string) ([]string, error) {
+	return cached(ctx, s, cacheKey("versions", cpe), slices.Clone[[]string], func() ([]string, error) {
+		return s.Store.Versions(ctx, cpe)
+	})
+}
+
+// deprecation is the cached deprecation of one CPE.
+type deprecation struct {
+	deprecated  bool
+	replacement string
+}
+
+func (s *CachedStore) Deprecated(ctx context.Context, cpes []string) (map[string]string, error) {
+	deps, err := cachedEach(ctx, s, "deprecated", cpes, identity[deprecation], func(cpes []string) ([]deprecation, error) {
+		m, err := s.Store.Deprecated(ctx, cpes)
+		if err != nil {
+			return nil, err
+		}
+		deps := make([]deprecation, len(cpes))
+		for i, cpe := range cpes {
+			deps[i].replacement, deps[i].deprecated = m[cpe]
+		}
+		return deps, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for i, d := range deps {
+		if d.deprecated {
+			out[cpes[i]] = d.replacement
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,43 @@
+package guesser
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countingStore counts the CPEs whose rank is looked up.
+type countingStore struct {
+	Store
+	ranked int
+}
+
+func (s *countingStore) Ranks(ctx context.Context, cpes []string) ([]float64, error) {
+	s.ranked += len(cpes)
+	return s.Store.Ranks(ctx, cpes)
+}
+
+func TestCachedStoreRanksPerCPE(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, map[string]float64{
+		"cpe:2.3:a:apache:tomcat": 2,
+		"cpe:2.3:a:apache:struts": 1,
+	})
+	counting := &countingStore{Store: c.store}
+	s := NewCachedStore(counting, 100, time.Minute)
+
+	if _, err := s.Ranks(ctx, []string{"cpe:2.3:a:apache:tomcat", "cpe:2.3:a:apache:struts"}); err != nil {
+		t.Fatal(err)
+	}
+	// The same CPEs in another order, and with one more, only load the new one
+	ranks, err := s.Ranks(ctx, []string{"cpe:2.3:a:apache:struts", "cpe:2.3:a:none:none", "cpe:2.3:a:apache:tomcat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{1, 0, 2}; ranks[0] != want[0] || ranks[1] != want[1] || ranks[2] != want[2] {
+		t.Errorf("ranks = %v, want %v", ranks, want)
+	}
+	if counting.ranked != 3 {
+		t.Errorf("looked up %d ranks in the store, want 3", counting.ranked)
+	}
+}
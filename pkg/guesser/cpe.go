@@ -0,0 +1,148 @@
+package guesser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SplitCPE splits a CPE 2.3 formatted string into its components. Colons
+// escaped with a backslash are kept inside the component they belong to.
+func SplitCPE(cpe string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(cpe); i++ {
+		switch cpe[i] {
+		case '\\':
+			i++
+		case ':':
+			parts = append(parts, cpe[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, cpe[start:])
+}
+
+// Unescape removes the backslash quoting CPE 2.3 formatted strings apply to
+// non-alphanumeric characters, so that "c\+\+" becomes "c++".
+func Unescape(val string) string {
+	if !strings.Contains(val, "\\") {
+		return val
+	}
+	var b strings.Builder
+	b.Grow(len(val))
+	for i := 0; i < len(val); i++ {
+		if val[i] == '\\' && i+1 < len(val) {
+			i++
+		}
+		b.WriteByte(val[i])
+	}
+	return b.String()
+}
+
+// Escape quotes a value for use as a component of a CPE 2.3 formatted string,
+// the reverse of Unescape. Whitespace becomes an underscore.
+func Escape(val string) string {
+	var b strings.Builder
+	for _, r := range val {
+		switch {
+		case unicode.IsSpace(r):
+			b.WriteByte('_')
+			continue
+		case r > unicode.MaxASCII, unicode.IsLetter(r), unicode.IsDigit(r), r == '_', r == '-', r == '.':
+		default:
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Canonize turns a vendor or product component into the words it is indexed
+// under with DefaultTokenizer.
+func Canonize(val string) []string {
+	return DefaultTokenizer.Split(val)
+}
+
+// Normalize maps a single word to the form used in index keys. It is applied
+// to query words so they match what Canonize produced at import time.
+func Normalize(word string) string {
+	return strings.ToLower(Unescape(word))
+}
+
+// TitleKey is the hash mapping CPE lines to their dictionary title.
+const TitleKey = "title:cpe"
+
+// DeprecatedKey is the hash mapping deprecated CPE lines to the line that
+// replaces them, empty when the dictionary names none.
+const DeprecatedKey = "deprecated:cpe"
+
+// LastImportKey holds the time the last completed import started, in RFC
+// 3339 format.
+const LastImportKey = "meta:last_import"
+
+// CheckpointKey is the hash recording the progress of an import under way,
+// dropped when it completes.
+const CheckpointKey = "meta:import_checkpoint"
+
+// checkpointPrefix starts the meta keys holding the checkpoint fields in the
+// index files.
+const checkpointPrefix = CheckpointKey + ":"
+
+// WordsLexKey is the sorted set of every indexed word, all with score 0 so
+// they sort lexically for prefix lookups.
+const WordsLexKey = "words:lex"
+
+// TrigramsKey is set once an import has indexed the trigrams of every word,
+// so partial searches can use them.
+const TrigramsKey = "meta:trigrams"
+
+// TrigramKey returns the key of the set holding the indexed words containing
+// trigram.
+func TrigramKey(trigram string) string {
+	return "t:" + trigram
+}
+
+// Trigrams returns the distinct runs of three characters of word, none when
+// it is shorter.
+func Trigrams(word string) []string {
+	r := []rune(word)
+	seen := make(map[string]bool)
+	var out []string
+	for i := 0; i+3 <= len(r); i++ {
+		t := string(r[i : i+3])
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// RefsKey returns the key of the set holding the reference URLs of a CPE line.
+func RefsKey(cpe string) string {
+	return "refs:" + cpe
+}
+
+// VersionsKey returns the key of the set holding the version components
+// indexed for a CPE line.
+func VersionsKey(cpe string) string {
+	return "versions:" + cpe
+}
+
+// VendorKey returns the key of the set holding the CPE lines of vendor.
+func VendorKey(vendor string) string {
+	return "vendor:" + Normalize(vendor)
+}
+
+// escapeGlob quotes the characters Redis treats specially in SCAN patterns.
+func escapeGlob(val string) string {
+	var b strings.Builder
+	for _, r := range val {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package guesser
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// Escaped vendor and product names as found in the NVD dictionary.
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		in, unescaped, normalized string
+	}{
+		{`visual_c\+\+`, `visual_c++`, `visual_c++`},
+		{`notepad\+\+`, `notepad++`, `notepad++`},
+		{`1c\:enterprise`, `1c:enterprise`, `1c:enterprise`},
+		{`Visual_C\+\+`, `Visual_C++`, `visual_c++`},
+		{`c\\d`, `c\d`, `c\d`},
+		{`jackson-databind`, `jackson-databind`, `jackson-databind`},
+		// A trailing backslash quotes nothing and is kept
+		{`abc\`, `abc\`, `abc\`},
+	}
+	for _, tt := range tests {
+		if got := Unescape(tt.in); got != tt.unescaped {
+			t.Errorf("Unescape(%q) = %q, want %q", tt.in, got, tt.unescaped)
+		}
+		if got := Normalize(tt.in); got != tt.normalized {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.normalized)
+		}
+	}
+}
+
+func TestSplitCPE(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`cpe:2.3:a:microsoft:visual_c\+\+`, []string{"cpe", "2.3", "a", "microsoft", `visual_c\+\+`}},
+		{`cpe:2.3:a:1c:1c\:enterprise`, []string{"cpe", "2.3", "a", "1c", `1c\:enterprise`}},
+	}
+	for _, tt := range tests {
+		if got := SplitCPE(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("SplitCPE(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCanonizeEscaped(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`visual_c\+\+`, []string{"visual", "c++"}},
+		{`notepad\+\+`, []string{"notepad++"}},
+		{`notepad-plus-plus`, []string{"notepad", "plus", "plus"}},
+	}
+	for _, tt := range tests {
+		if got := Canonize(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("Canonize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSearchEscapedProduct(t *testing.T) {
+	c := newTestClient(t, map[string]float64{
+		`cpe:2.3:a:microsoft:visual_c\+\+`:        3,
+		`cpe:2.3:a:notepad-plus-plus:notepad\+\+`: 2,
+		`cpe:2.3:a:microsoft:visual_studio_code`:  1,
+	})
+	tests := []struct {
+		query []string
+		want  string
+	}{
+		{[]string{"visual", "c++"}, `cpe:2.3:a:microsoft:visual_c\+\+`},
+		{[]string{`visual_c\+\+`}, `cpe:2.3:a:microsoft:visual_c\+\+`},
+		{[]string{"Notepad++"}, `cpe:2.3:a:notepad-plus-plus:notepad\+\+`},
+	}
+	for _, tt := range tests {
+		res, pass, err := c.Search(context.Background(), tt.query, SearchOptions{})
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if pass != "exact" || len(res) != 1 || res[0].CPE != tt.want {
+			t.Errorf("%q: got %v from the %s pass, want %s from the exact pass", tt.query, resultCPEs(res), pass, tt.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package guesser
+
+import (
+	"context"
+	"slices"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fuzzyRunesPerEdit is the number of characters a query word needs per edit
+// the fuzzy search tolerates, so short words, which are close to too many
+// others, must match exactly.
+const fuzzyRunesPerEdit = 3
+
+// Fuzzy returns the CPEs matching every one of words, or an indexed word
+// within maxDistance edits of it, highest rank first. A word may differ by at
+// most one edit per three characters, so "mozila firefx" finds
+// mozilla:firefox. A word that is indexed as it is only matches itself; one
+// that is not matches the indexed words closest to it.
+func (c *Client) Fuzzy(ctx context.Context, words []string, maxDistance int) (_ []Result, err error) {
+	words = normalizeAll(c.expand(words))
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	ctx, span := tracer.Start(ctx, "guesser.Fuzzy", trace.WithAttributes(attrTerms.Int(len(words))))
+	defer func() { endSpan(span, err) }()
+
+	matches, err := c.closestWords(ctx, words, maxDistance)
+	if err != nil || matches == nil {
+		return nil, err
+	}
+
+	// A CPE must be indexed under a match of every word
+	var cpes map[string]bool
+	for _, m := range matches {
+		sets, err := c.store.Members(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		found := make(map[string]bool)
+		for _, set := range sets {
+			for _, cpe := range set {
+				if cpes == nil || cpes[cpe] {
+					found[cpe] = true
+				}
+			}
+		}
+		if len(found) == 0 {
+			return nil, nil
+		}
+		cpes = found
+	}
+
+	list := make([]string, 0, len(cpes))
+	for cpe := range cpes {
+		list = append(list, cpe)
+	}
+	res, err := c.rank(ctx, list)
+	for i := range res {
+		res[i].Coverage = 1
+	}
+	return res, err
+}
+
+// closestWords returns, for each of words, the word itself when it is
+// indexed, otherwise the indexed words closest to it within the distance it
+// is allowed. It returns nil when a word has no match. Words are compared
+// with the candidates of the trigram index when the store has one and they
+// are long enough, see candidateWords, and otherwise with every indexed word
+// of a length within reach.
+func (c *Client) closestWords(ctx context.Context, words []string, maxDistance int) ([][]string, error) {
+	m := &fuzzyMatch{
+		words:   words,
+		query:   make([][]rune, len(words)),
+		allowed: make([]int, len(words)),
+		best:    make([]int, len(words)),
+		matches: make([][]string, len(words)),
+		exact:   make([]bool, len(words)),
+		done:    make([]bool, len(words)),
+	}
+	for i, w := range words {
+		m.query[i] = []rune(w)
+		m.allowed[i] = min(maxDistance, len(m.query[i])/fuzzyRunesPerEdit)
+		m.best[i] = m.allowed[i] + 1
+	}
+
+	if fc, ok := c.store.(FuzzyCandidater); ok {
+		if err := c.candidateWords(ctx, fc, m); err != nil {
+			return nil, err
+		}
+	}
+	if slices.Contains(m.done, false) {
+		err := c.store.Words(ctx, func(indexed []string) error {
+			for _, iw := range indexed {
+				n := utf8.RuneCountInString(iw)
+				for i := range words {
+					if !m.done[i] && !m.exact[i] && abs(n-len(m.query[i])) <= m.allowed[i] {
+						m.compare(i, iw)
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, match := range m.matches {
+		if len(match) == 0 {
+			return nil, nil
+		}
+	}
+	return m.matches, nil
+}
+
+// candidateWords compares the words of m with the indexed words sharing
+// enough of their trigrams to be within the allowed distance: a word of n
+// characters has n-2 trigrams and every edit changes at most three of them,
+// so a match shares n-2-3*allowed of them. The words for which that leaves
+// none, such as those of up to eight characters allowed two edits, are left
+// to the scan of every word, as are all of them when the store has no
+// trigram index. Words shorter than a trigram, which must be spelled right,
+// are looked up as they are.
+func (c *Client) candidateWords(ctx context.Context, fc FuzzyCandidater, m *fuzzyMatch) error {
+	for i, w := range m.words {
+		trigrams := Trigrams(w)
+		if len(trigrams) == 0 {
+			sets, err := c.store.Members(ctx, []string{w})
+			if err != nil {
+				return err
+			}
+			if len(sets[0]) > 0 {
+				m.compare(i, w)
+			}
+			m.done[i] = true
+			continue
+		}
+		shared := len(trigrams) - 3*m.allowed[i]
+		if shared < 1 {
+			continue
+		}
+		candidates, ok, err := fc.FuzzyCandidates(ctx, trigrams, shared)
+		if err != nil || !ok {
+			return err
+		}
+		for _, iw := range candidates {
+			if m.exact[i] {
+				break
+			}
+			m.compare(i, iw)
+		}
+		m.done[i] = true
+	}
+	return nil
+}
+
+// fuzzyMatch collects the indexed words closest to each query word.
+type fuzzyMatch struct {
+	words   []string
+	query   [][]rune
+	allowed []int
+	best    []int
+	matches [][]string
+	// exact is set for the query words found as they are, and done for
+	// those compared with all their candidates
+	exact []bool
+	done  []bool
+}
+
+// compare records the indexed word iw when it is the i-th query word or at
+// least as close to it as the closest words so far.
+func (m *fuzzyMatch) compare(i int, iw string) {
+	if iw == m.words[i] {
+		m.exact[i] = true
+		m.matches[i] = []string{iw}
+		return
+	}
+	d := levenshtein(m.query[i], []rune(iw), m.best[i])
+	switch {
+	case d < m.best[i]:
+		m.best[i] = d
+		m.matches[i] = []string{iw}
+	case d == m.best[i] && d <= m.allowed[i]:
+		m.matches[i] = append(m.matches[i], iw)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// levenshtein returns the edit distance between a and b, or max+1 once it is
+// known to exceed max.
+func levenshtein(a, b []rune, max int) int {
+	if d := len(a) - len(b); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	if prev[len(b)] > max {
+		return max + 1
+	}
+	return prev[len(b)]
+}
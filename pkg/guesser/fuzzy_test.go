@@ -0,0 +1,75 @@
+package guesser
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// trigramStore answers FuzzyCandidates from the words of its store, as the
+// trigram index of a Valkey store does.
+type trigramStore struct {
+	Store
+}
+
+func (s trigramStore) FuzzyCandidates(ctx context.Context, trigrams []string, minShared int) ([]string, bool, error) {
+	var out []string
+	err := s.Words(ctx, func(words []string) error {
+		for _, w := range words {
+			own := make(map[string]bool)
+			for _, t := range Trigrams(w) {
+				own[t] = true
+			}
+			shared := 0
+			for _, t := range trigrams {
+				if own[t] {
+					shared++
+				}
+			}
+			if shared >= minShared {
+				out = append(out, w)
+			}
+		}
+		return nil
+	})
+	return out, true, err
+}
+
+func TestFuzzy(t *testing.T) {
+	ctx := context.Background()
+	scan := newTestClient(t, map[string]float64{
+		"cpe:2.3:a:mozilla:firefox":         5,
+		"cpe:2.3:a:mozilla:thunderbird":     3,
+		"cpe:2.3:a:apache:http_server":      4,
+		"cpe:2.3:a:openbsd:openssh":         2,
+		"cpe:2.3:a:cisco:adaptive_security": 1,
+		"cpe:2.3:a:hp:hp-ux":                1,
+	})
+	trigrams := NewWithStore(trigramStore{scan.store})
+
+	tests := []struct {
+		query []string
+		want  []string
+	}{
+		{[]string{"mozila", "firefx"}, []string{"cpe:2.3:a:mozilla:firefox"}},
+		{[]string{"mozilla", "thunderbrid"}, []string{"cpe:2.3:a:mozilla:thunderbird"}},
+		{[]string{"openshh"}, []string{"cpe:2.3:a:openbsd:openssh"}},
+		{[]string{"adaptve", "securty"}, []string{"cpe:2.3:a:cisco:adaptive_security"}},
+		{[]string{"apache", "htp"}, []string{"cpe:2.3:a:apache:http_server"}},
+		// Words of up to two characters must be spelled right
+		{[]string{"apache", "hp"}, nil},
+		{[]string{"hp"}, []string{"cpe:2.3:a:hp:hp-ux"}},
+		{[]string{"nothing"}, nil},
+	}
+	for _, tt := range tests {
+		for name, c := range map[string]*Client{"scan": scan, "trigrams": trigrams} {
+			res, err := c.Fuzzy(ctx, tt.query, 2)
+			if err != nil {
+				t.Fatalf("%s %q: %v", name, tt.query, err)
+			}
+			if got := resultCPEs(res); !reflect.DeepEqual(got, tt.want) && (len(got) != 0 || len(tt.want) != 0) {
+				t.Errorf("%s %q = %v, want %v", name, tt.query, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,831 @@
+// Package guesser implements CPE guessing on top of the Valkey/Redis index
+// populated by the cpe-guesser-go import command.
+package guesser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// IndexDB is the Valkey/Redis database the import writes the index to.
+const IndexDB = 8
+
+// relatedSample caps how many members of each word set Related samples.
+const relatedSample = 500
+
+// batchConcurrency bounds the number of queries UniqueBatch runs at once.
+const batchConcurrency = 8
+
+var (
+	tracer = otel.Tracer("github.com/aringo/cpe-guesser-go/pkg/guesser")
+
+	attrTerms      = attribute.Key("cpe.query.terms")
+	attrCandidates = attribute.Key("cpe.candidates")
+)
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Result is a single CPE match together with its rank.
+type Result struct {
+	Rank float64 `json:"rank"`
+	CPE  string  `json:"cpe"`
+	// Vendor and Product are the unescaped CPE components set by
+	// SetVendorProduct.
+	Vendor  string `json:"vendor,omitempty"`
+	Product string `json:"product,omitempty"`
+	// Coverage is the fraction of query words the CPE matched, or of their
+	// total weight for a weighted search.
+	Coverage float64 `json:"-"`
+	// Score is the combined rank and coverage set by ScoreByCoverage, or
+	// the IDF score set by ScoreByIDF.
+	Score float64 `json:"score,omitempty"`
+	// Scored is set along with Score, which may then be zero.
+	Scored bool `json:"-"`
+	// Confidence, between 0 and 1, is set by PURL.
+	Confidence float64 `json:"confidence,omitempty"`
+	// Title is the dictionary title set by Titles.
+	Title string `json:"title,omitempty"`
+	// References are the dictionary reference URLs set by References.
+	References []string `json:"references,omitempty"`
+	// SubstringOnly is set by MarkSubstringOnly on likely false positives.
+	SubstringOnly bool `json:"substring_only,omitempty"`
+	// Deprecated and DeprecatedBy, the CPE line replacing a deprecated one
+	// when the dictionary names it, are set by Deprecations.
+	Deprecated   bool   `json:"deprecated,omitempty"`
+	DeprecatedBy string `json:"deprecated_by,omitempty"`
+	// CVEs is set by the server's CVE enrichment.
+	CVEs *CVEs `json:"cves,omitempty"`
+}
+
+// CVEs summarizes the CVEs known for a CPE.
+type CVEs struct {
+	Count int `json:"count"`
+	// Latest holds the most recent CVE IDs, newest first.
+	Latest []string `json:"latest,omitempty"`
+}
+
+// ErrPartialResults is returned by Search, together with the results found so
+// far, when the partial pass ran out of its time budget.
+var ErrPartialResults = errors.New("search time budget exceeded")
+
+// maxRedirects bounds the chain of replacements Unique follows from a
+// deprecated CPE.
+const maxRedirects = 5
+
+// ErrTooManyWords is returned by Partial for queries with more words than
+// Client.MaxPartialWords.
+var ErrTooManyWords = errors.New("too many words for a partial search")
+
+// Client runs searches against a CPE index.
+type Client struct {
+	store Store
+
+	// MaxPartialWords caps the words of a partial search, which scans the
+	// keyspace once per word. Zero means no limit.
+	MaxPartialWords int
+
+	synonyms     map[string][]string
+	tokenizer    Tokenizer
+	stopwords    Stopwords
+	purlMappings map[string]string
+}
+
+// New returns a Client that searches the index stored in rdb.
+func New(rdb redis.UniversalClient) *Client {
+	return NewWithStore(NewRedisStore(rdb))
+}
+
+// NewWithStore returns a Client that searches the index held by store.
+func NewWithStore(store Store) *Client {
+	return &Client{store: store, tokenizer: DefaultTokenizer}
+}
+
+// DefaultSynonyms are common aliases of vendors and products the NVD names
+// differently.
+var DefaultSynonyms = map[string][]string{
+	"ms":        {"microsoft"},
+	"msft":      {"microsoft"},
+	"msie":      {"internet_explorer"},
+	"ie":        {"internet_explorer"},
+	"mssql":     {"sql_server"},
+	"vscode":    {"visual_studio_code"},
+	"gnu/linux": {"linux"},
+	"osx":       {"mac_os_x"},
+	"rhel":      {"redhat", "enterprise_linux"},
+	"httpd":     {"http_server"},
+	"k8s":       {"kubernetes"},
+	"postgres":  {"postgresql"},
+	"golang":    {"go"},
+	"nodejs":    {"node.js"},
+}
+
+// SetSynonyms sets the aliases query words are expanded with before searching,
+// adding to DefaultSynonyms or overriding its entries. Each key is replaced by
+// the words it maps to, split like the index words, so several aliases can
+// share a canonical word and one alias can stand for several words.
+func (c *Client) SetSynonyms(synonyms map[string][]string) {
+	c.synonyms = make(map[string][]string, len(DefaultSynonyms)+len(synonyms))
+	for alias, words := range DefaultSynonyms {
+		c.synonyms[alias] = words
+	}
+	for alias, words := range synonyms {
+		c.synonyms[Normalize(alias)] = words
+	}
+}
+
+// SetTokenizer sets the rules query words are split with, which should be
+// those the index was imported with.
+func (c *Client) SetTokenizer(t Tokenizer) {
+	c.tokenizer = t
+}
+
+// canonize returns the words cpe component val is indexed under.
+func (c *Client) canonize(val string) []string {
+	return c.stopwords.Filter(c.tokenizer.Split(val))
+}
+
+// SetStopwords sets the words dropped from queries, which should be those the
+// index was imported without. A nil set keeps every word.
+func (c *Client) SetStopwords(stopwords Stopwords) {
+	c.stopwords = stopwords
+}
+
+// expand replaces the aliases among the query words by their canonical words,
+// splits the words like the index words, drops the stopwords, unless they are
+// all stopwords, and drops repeated words, so each index key is looked up
+// once.
+func (c *Client) expand(words []string) []string {
+	words, _ = c.expandWeighted(words, nil)
+	return words
+}
+
+// expandWeighted is expand for weighted words: the tokens and canonical words
+// of a word take its weight and a repeated word keeps its highest weight. A
+// nil weights slice is returned unchanged.
+func (c *Client) expandWeighted(words []string, weights []float64) ([]string, []float64) {
+	words, weights = c.tokenize(words, weights)
+	out := make([]string, 0, len(words))
+	var outWeights []float64
+	if weights != nil {
+		outWeights = make([]float64, 0, len(words))
+	}
+	seen := make(map[string]int, len(words))
+	dropStopwords := !c.stopwords.all(words)
+	for i, w := range words {
+		if dropStopwords && c.stopwords[Normalize(w)] {
+			continue
+		}
+		if j, ok := seen[Normalize(w)]; ok {
+			if weights != nil {
+				outWeights[j] = math.Max(outWeights[j], weights[i])
+			}
+			continue
+		}
+		seen[Normalize(w)] = len(out)
+		out = append(out, w)
+		if weights != nil {
+			outWeights = append(outWeights, weights[i])
+		}
+	}
+	return out, outWeights
+}
+
+// tokenize replaces the aliases among the query words by their canonical
+// words and splits the words, each token taking the weight of its word.
+func (c *Client) tokenize(words []string, weights []float64) ([]string, []float64) {
+	synonyms := c.synonyms
+	if synonyms == nil {
+		synonyms = DefaultSynonyms
+	}
+	out := make([]string, 0, len(words))
+	var outWeights []float64
+	if weights != nil {
+		outWeights = make([]float64, 0, len(words))
+	}
+	for i, w := range words {
+		expanded, ok := synonyms[Normalize(w)]
+		if !ok {
+			expanded = []string{w}
+		}
+		var tokens []string
+		for _, e := range expanded {
+			tokens = append(tokens, c.tokenizer.Split(e)...)
+		}
+		out = append(out, tokens...)
+		for range tokens {
+			if weights != nil {
+				outWeights = append(outWeights, weights[i])
+			}
+		}
+	}
+	return out, outWeights
+}
+
+// weightOf returns the weight of the i-th query word, 1 when unweighted.
+func weightOf(weights []float64, i int) float64 {
+	if weights == nil {
+		return 1
+	}
+	return weights[i]
+}
+
+// totalWeight returns the sum of the weights of n query words.
+func totalWeight(weights []float64, n int) float64 {
+	if weights == nil {
+		return float64(n)
+	}
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	return total
+}
+
+// Exact returns the CPEs indexed under every one of words, highest rank first.
+func (c *Client) Exact(ctx context.Context, words []string) (_ []Result, err error) {
+	words = c.expand(words)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	ctx, span := tracer.Start(ctx, "guesser.Exact", trace.WithAttributes(attrTerms.Int(len(words))))
+	defer func() { endSpan(span, err) }()
+
+	// Intersect all sets and rank the result, in one round trip when the
+	// store supports it
+	sctx, sspan := tracer.Start(ctx, "store.IntersectRanked")
+	res, err := intersectRanked(sctx, c.store, normalizeAll(words))
+	endSpan(sspan, err)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res {
+		res[i].Coverage = 1
+	}
+	return res, nil
+}
+
+// Anchored returns the CPEs indexed under any one of words, highest rank
+// first. Unlike Partial it only matches whole words, so "win" does not match
+// "darwin".
+func (c *Client) Anchored(ctx context.Context, words []string) ([]Result, error) {
+	return c.anchored(ctx, words, nil)
+}
+
+// anchored is Anchored with optional per-word weights, see SearchOptions.
+func (c *Client) anchored(ctx context.Context, words []string, weights []float64) (_ []Result, err error) {
+	words, weights = c.expandWeighted(words, weights)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	ctx, span := tracer.Start(ctx, "guesser.Anchored", trace.WithAttributes(attrTerms.Int(len(words))))
+	defer func() { endSpan(span, err) }()
+
+	// Fetch each word set separately to know how many words a CPE matched
+	sctx, sspan := tracer.Start(ctx, "store.Members")
+	sets, err := c.store.Members(sctx, normalizeAll(words))
+	endSpan(sspan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make(map[string]float64)
+	for i, set := range sets {
+		for _, cpe := range set {
+			hits[cpe] += weightOf(weights, i)
+		}
+	}
+	return c.rankHits(ctx, hits, totalWeight(weights, len(words)))
+}
+
+// Partial returns the CPEs indexed under any word containing one of words,
+// highest rank first.
+func (c *Client) Partial(ctx context.Context, words []string) ([]Result, error) {
+	return c.partial(ctx, words, nil, time.Time{})
+}
+
+// partial is Partial with optional per-word weights, see SearchOptions. When
+// deadline is set and passes during the scan, it ranks the CPEs found so far
+// and returns them with ErrPartialResults.
+func (c *Client) partial(ctx context.Context, words []string, weights []float64, deadline time.Time) (_ []Result, err error) {
+	words, weights = c.expandWeighted(words, weights)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	if c.MaxPartialWords > 0 && len(words) > c.MaxPartialWords {
+		return nil, fmt.Errorf("%w: %d words, at most %d allowed", ErrTooManyWords, len(words), c.MaxPartialWords)
+	}
+
+	ctx, span := tracer.Start(ctx, "guesser.Partial", trace.WithAttributes(attrTerms.Int(len(words))))
+	defer func() { endSpan(span, err) }()
+
+	// Sum the weights of the query words each matching CPE was found for
+	hits := make(map[string]float64)
+
+	// For each word, find partially matching sets
+	sctx, sspan := tracer.Start(ctx, "store.PartialMatch")
+	err = c.scanWords(sctx, words, weights, deadline, hits)
+	endSpan(sspan, err)
+	if err != nil && err != ErrPartialResults {
+		return nil, err
+	}
+	truncated := err
+
+	res, err := c.rankHits(ctx, hits, totalWeight(weights, len(words)))
+	if err != nil {
+		return nil, err
+	}
+	return res, truncated
+}
+
+// scanWords sums, for every CPE in a word set containing one of words, the
+// weights of the query words it matched. It stops with ErrPartialResults once
+// deadline, when set, has passed; hits then holds the complete words only.
+func (c *Client) scanWords(ctx context.Context, words []string, weights []float64, deadline time.Time, hits map[string]float64) error {
+	for i, w := range words {
+		// A CPE can be in several sets matching the same word
+		matched := make(map[string]struct{})
+		err := c.store.PartialMatch(ctx, Normalize(w), func(cpes []string) error {
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return ErrPartialResults
+			}
+			for _, cpe := range cpes {
+				matched[cpe] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for cpe := range matched {
+			hits[cpe] += weightOf(weights, i)
+		}
+	}
+	return nil
+}
+
+// Unique returns the best CPE for words, trying an exact match before falling
+// back to a partial one. A deprecated best CPE is replaced by the one the
+// dictionary names in its place. It returns an empty string when nothing
+// matches.
+func (c *Client) Unique(ctx context.Context, words []string) (string, error) {
+	res, err := c.Exact(ctx, words)
+	if err == nil && len(res) > 0 {
+		return c.redirect(ctx, res[0].CPE)
+	}
+	res, err = c.Partial(ctx, words)
+	if err != nil {
+		return "", err
+	}
+	if len(res) == 0 {
+		return "", nil
+	}
+	return c.redirect(ctx, res[0].CPE)
+}
+
+// redirect follows the replacements of cpe while it is deprecated, and
+// returns the last one.
+func (c *Client) redirect(ctx context.Context, cpe string) (string, error) {
+	for i := 0; i < maxRedirects; i++ {
+		deprecated, err := c.store.Deprecated(ctx, []string{cpe})
+		if err != nil {
+			return "", err
+		}
+		repl := deprecated[cpe]
+		if repl == "" {
+			break
+		}
+		cpe = repl
+	}
+	return cpe, nil
+}
+
+// Products returns the CPEs indexed under vendor, highest rank first.
+func (c *Client) Products(ctx context.Context, vendor string) (_ []Result, err error) {
+	ctx, span := tracer.Start(ctx, "guesser.Products")
+	defer func() { endSpan(span, err) }()
+
+	cpes, err := c.store.Products(ctx, vendor)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cpes) == 0 {
+		return nil, nil
+	}
+
+	return c.rank(ctx, cpes)
+}
+
+// Related suggests up to limit CPEs sharing the most indexed words with cpe.
+// Each word set is sampled rather than read in full to bound the cost on
+// common words. A result's Coverage is the fraction of cpe's words it shares.
+func (c *Client) Related(ctx context.Context, cpe string, limit int) (_ []Result, err error) {
+	parts := SplitCPE(cpe)
+	if len(parts) < 5 || limit <= 0 {
+		return nil, nil
+	}
+
+	ctx, span := tracer.Start(ctx, "guesser.Related")
+	defer func() { endSpan(span, err) }()
+
+	words := append(c.canonize(parts[3]), c.canonize(parts[4])...)
+	sets, err := c.store.Sample(ctx, words, relatedSample)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make(map[string]float64)
+	for _, set := range sets {
+		for _, member := range set {
+			if member != cpe {
+				hits[member]++
+			}
+		}
+	}
+
+	res, err := c.rankHits(ctx, hits, float64(len(words)))
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Coverage > res[j].Coverage
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// Titles fills in the dictionary title of each result. Results whose CPE has
+// no stored title are left untouched.
+func (c *Client) Titles(ctx context.Context, res []Result) error {
+	if len(res) == 0 {
+		return nil
+	}
+	titles, err := c.store.Titles(ctx, resultCPEs(res))
+	if err != nil {
+		return err
+	}
+	for i, title := range titles {
+		if title != "" {
+			res[i].Title = title
+		}
+	}
+	return nil
+}
+
+// References fills in the reference URLs stored for each result, sorted.
+// They are only present when the import indexed references.
+func (c *Client) References(ctx context.Context, res []Result) error {
+	if len(res) == 0 {
+		return nil
+	}
+	sets, err := c.store.References(ctx, resultCPEs(res))
+	if err != nil {
+		return err
+	}
+	for i, refs := range sets {
+		sort.Strings(refs)
+		res[i].References = refs
+	}
+	return nil
+}
+
+// Deprecations marks the results the dictionary deprecated, with the CPE
+// line replacing them when it names one.
+func (c *Client) Deprecations(ctx context.Context, res []Result) error {
+	if len(res) == 0 {
+		return nil
+	}
+	deprecated, err := c.store.Deprecated(ctx, resultCPEs(res))
+	if err != nil {
+		return err
+	}
+	for i := range res {
+		if repl, ok := deprecated[res[i].CPE]; ok {
+			res[i].Deprecated = true
+			res[i].DeprecatedBy = repl
+		}
+	}
+	return nil
+}
+
+// Lookup returns what the index stores about the CPE line cpe: its rank,
+// title, reference URLs and deprecation. It returns nil when cpe is not
+// indexed.
+func (c *Client) Lookup(ctx context.Context, cpe string) (_ *Result, err error) {
+	ctx, span := tracer.Start(ctx, "guesser.Lookup")
+	defer func() { endSpan(span, err) }()
+
+	res, err := c.rank(ctx, []string{cpe})
+	if err != nil || res[0].Rank == 0 {
+		return nil, err
+	}
+	if err := c.Titles(ctx, res); err != nil {
+		return nil, err
+	}
+	if err := c.References(ctx, res); err != nil {
+		return nil, err
+	}
+	if err := c.Deprecations(ctx, res); err != nil {
+		return nil, err
+	}
+	return &res[0], nil
+}
+
+// ExcludeDeprecated drops the results the dictionary deprecated.
+func (c *Client) ExcludeDeprecated(ctx context.Context, res []Result) ([]Result, error) {
+	if len(res) == 0 {
+		return res, nil
+	}
+	deprecated, err := c.store.Deprecated(ctx, resultCPEs(res))
+	if err != nil || len(deprecated) == 0 {
+		return res, err
+	}
+	kept := res[:0]
+	for _, r := range res {
+		if _, ok := deprecated[r.CPE]; !ok {
+			kept = append(kept, r)
+		}
+	}
+	return kept, nil
+}
+
+// Version returns the full CPE 2.3 formatted string of cpe, a CPE line, at
+// version, with the remaining attributes ANY. It returns an empty string when
+// the import indexed no such version for cpe. Versions are compared
+// unescaped and case-insensitively, and the indexed spelling is used.
+func (c *Client) Version(ctx context.Context, cpe, version string) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "guesser.Version")
+	defer func() { endSpan(span, err) }()
+
+	versions, err := c.store.Versions(ctx, cpe)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range versions {
+		if Normalize(v) == Normalize(version) {
+			return cpe + ":" + v + strings.Repeat(":*", 7), nil
+		}
+	}
+	return "", nil
+}
+
+// UniqueBatch runs Unique for each of queries concurrently. The result for a
+// query that matched nothing or failed is an empty string; failures are also
+// reported through the returned error.
+func (c *Client) UniqueBatch(ctx context.Context, queries [][]string) ([]string, error) {
+	out := make([]string, len(queries))
+	errs := make([]error, len(queries))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchConcurrency)
+	for i, words := range queries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, words []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			out[i], errs[i] = c.Unique(ctx, words)
+		}(i, words)
+	}
+	wg.Wait()
+
+	return out, errors.Join(errs...)
+}
+
+// normalizeAll returns words normalized for a Store.
+func normalizeAll(words []string) []string {
+	out := make([]string, len(words))
+	for i, w := range words {
+		out[i] = Normalize(w)
+	}
+	return out
+}
+
+// resultCPEs returns the CPE of each result.
+func resultCPEs(res []Result) []string {
+	cpes := make([]string, len(res))
+	for i, r := range res {
+		cpes[i] = r.CPE
+	}
+	return cpes
+}
+
+// FilterMinRank returns the results ranked at least min. Results are
+// filtered in place; a min of zero or less keeps everything.
+func FilterMinRank(res []Result, min float64) []Result {
+	if min <= 0 {
+		return res
+	}
+	out := res[:0]
+	for _, r := range res {
+		if r.Rank >= min {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// FilterPart returns the results whose CPE is of the given part: a, o or h.
+// Results are filtered in place; an empty part keeps everything.
+func FilterPart(res []Result, part string) []Result {
+	if part == "" {
+		return res
+	}
+	out := res[:0]
+	for _, r := range res {
+		if parts := SplitCPE(r.CPE); len(parts) > 2 && parts[2] == part {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// DistinctProducts keeps the highest ranked result for each vendor:product
+// pair, so CPEs that differ only in their part collapse into one. res must be
+// sorted highest rank first, as returned by the searches.
+func DistinctProducts(res []Result) []Result {
+	seen := make(map[string]struct{}, len(res))
+	out := res[:0]
+	for _, r := range res {
+		parts := SplitCPE(r.CPE)
+		if len(parts) < 5 {
+			out = append(out, r)
+			continue
+		}
+		key := parts[3] + ":" + parts[4]
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Paginate returns the limit results following the first offset ones. A
+// limit of zero or less returns all of them.
+func Paginate(res []Result, offset, limit int) []Result {
+	if offset > len(res) {
+		offset = len(res)
+	}
+	res = res[offset:]
+	if limit > 0 && limit < len(res) {
+		res = res[:limit]
+	}
+	return res
+}
+
+// ScoreByCoverage scores each result by its rank weighted by the fraction of
+// query words it matched, rank * coverage^weight, and sorts by that score
+// highest first. A weight of zero scores by rank alone.
+func ScoreByCoverage(res []Result, weight float64) {
+	for i := range res {
+		res[i].Score = res[i].Rank * math.Pow(res[i].Coverage, weight)
+		res[i].Scored = true
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Score > res[j].Score
+	})
+}
+
+// MarkSubstringOnly flags the results whose vendor and product contain none
+// of words as a whole word, so they only matched inside longer words, like
+// "win" in "darwin". It is meant for the results of a partial search.
+func (c *Client) MarkSubstringOnly(res []Result, words []string) {
+	words = c.expand(words)
+	for i := range res {
+		parts := SplitCPE(res[i].CPE)
+		if len(parts) < 5 {
+			continue
+		}
+		tokens := make(map[string]bool)
+		for _, t := range append(c.canonize(parts[3]), c.canonize(parts[4])...) {
+			tokens[t] = true
+		}
+		res[i].SubstringOnly = true
+		for _, w := range words {
+			if tokens[Normalize(w)] {
+				res[i].SubstringOnly = false
+				break
+			}
+		}
+	}
+}
+
+// SetVendorProduct fills in the vendor and product of each result from its
+// CPE, which must still be a formatted string.
+func SetVendorProduct(res []Result) {
+	for i := range res {
+		if parts := SplitCPE(res[i].CPE); len(parts) >= 5 {
+			res[i].Vendor = Unescape(parts[3])
+			res[i].Product = Unescape(parts[4])
+		}
+	}
+}
+
+// orderValue is the value results are ordered by: the score when they were
+// scored, even a zero one, the rank otherwise.
+func (r Result) orderValue() float64 {
+	if r.Scored {
+		return r.Score
+	}
+	return r.Rank
+}
+
+// SortByPartPriority breaks ties between equally ranked (or scored) results by
+// their CPE part, in the order given by priority, e.g. "a", "o", "h". Parts
+// missing from priority come last.
+func SortByPartPriority(res []Result, priority []string) {
+	if len(priority) == 0 {
+		return
+	}
+	order := make(map[string]int, len(priority))
+	for i, p := range priority {
+		order[p] = i
+	}
+	partOrder := func(cpe string) int {
+		parts := SplitCPE(cpe)
+		if len(parts) > 2 {
+			if i, ok := order[parts[2]]; ok {
+				return i
+			}
+		}
+		return len(priority)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		vi, vj := res[i].orderValue(), res[j].orderValue()
+		if vi != vj {
+			return vi > vj
+		}
+		return partOrder(res[i].CPE) < partOrder(res[j].CPE)
+	})
+}
+
+// rankHits ranks the CPEs in hits, recording the share of the total query
+// weight each matched as its coverage.
+func (c *Client) rankHits(ctx context.Context, hits map[string]float64, total float64) ([]Result, error) {
+	if len(hits) == 0 {
+		return nil, nil
+	}
+
+	cpes := make([]string, 0, len(hits))
+	for cpe := range hits {
+		cpes = append(cpes, cpe)
+	}
+	res, err := c.rank(ctx, cpes)
+	for i := range res {
+		res[i].Coverage = hits[res[i].CPE] / total
+	}
+	return res, err
+}
+
+// rank looks up the rank of each CPE and sorts them highest first.
+func (c *Client) rank(ctx context.Context, cpes []string) (_ []Result, err error) {
+	ctx, span := tracer.Start(ctx, "guesser.rank", trace.WithAttributes(attrCandidates.Int(len(cpes))))
+	defer func() { endSpan(span, err) }()
+
+	ranks, err := c.store.Ranks(ctx, cpes)
+	if err != nil {
+		return nil, err
+	}
+	return rankedResults(cpes, ranks), nil
+}
+
+// rankedResults returns the results of cpes with their ranks, highest first.
+func rankedResults(cpes []string, ranks []float64) []Result {
+	result := make([]Result, len(cpes))
+	for i, cpe := range cpes {
+		result[i] = Result{Rank: ranks[i], CPE: cpe}
+	}
+
+	// Sort by rank (highest first)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Rank > result[j].Rank
+	})
+
+	return result
+}
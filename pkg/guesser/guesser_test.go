@@ -0,0 +1,64 @@
+package guesser
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// newTestClient returns a Client searching an in-memory index of the CPEs of
+// ranks, indexed under their vendor and product words like the import does.
+func newTestClient(t *testing.T, ranks map[string]float64) *Client {
+	t.Helper()
+	store := NewMemoryStore()
+	batch := store.NewBatch()
+	for cpe, rank := range ranks {
+		parts := SplitCPE(cpe)
+		if len(parts) < 5 {
+			t.Fatalf("invalid test CPE %q", cpe)
+		}
+		words := append(DefaultTokenizer.Split(parts[3]), DefaultTokenizer.Split(parts[4])...)
+		for _, w := range words {
+			batch.AddWord(w, cpe)
+		}
+		batch.AddProduct(parts[3], cpe)
+		batch.SetRank(words, cpe, rank)
+	}
+	if err := batch.Exec(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return NewWithStore(store)
+}
+
+func TestRepeatedWords(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, map[string]float64{
+		"cpe:2.3:a:apache:http_server": 5,
+		"cpe:2.3:a:apache:tomcat":      3,
+		"cpe:2.3:a:nginx:nginx":        2,
+	})
+	if got := c.expand([]string{"apache", "Apache", "http"}); !slices.Equal(got, []string{"apache", "http"}) {
+		t.Errorf("expand = %q, want [apache http]", got)
+	}
+
+	search := func(words []string, strategy Strategy) []Result {
+		t.Helper()
+		res, _, err := c.Search(ctx, words, SearchOptions{Strategy: strategy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ScoreByCoverage(res, 1)
+		return res
+	}
+	for _, strategy := range []Strategy{ExactOnly, PartialOnly} {
+		repeated := search([]string{"apache", "apache", "http"}, strategy)
+		once := search([]string{"apache", "http"}, strategy)
+		if len(once) == 0 {
+			t.Fatalf("%s: no results", strategy)
+		}
+		if !reflect.DeepEqual(repeated, once) {
+			t.Errorf("%s: repeated words give %+v, want %+v", strategy, repeated, once)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package guesser
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ScoreByIDF scores each result by the inverse document frequency of the
+// query words it matches, summed, and sorts by that score highest first,
+// keeping the rank order between equal scores. A word that matches few CPEs
+// so outweighs one found in many, as "struts" does "apache".
+//
+// A word's document frequency is the number of CPEs indexed under it, or,
+// for a word only found inside longer ones, the number of results it
+// matches. A result matches a word when one of its vendor or product words
+// contains it or is within the edits a fuzzy search allows of it.
+// Weights, when not nil, scale the IDF of each query word.
+func (c *Client) ScoreByIDF(ctx context.Context, res []Result, words []string, weights []float64) (err error) {
+	ctx, span := tracer.Start(ctx, "guesser.ScoreByIDF")
+	defer func() { endSpan(span, err) }()
+
+	words, weights = c.expandWeighted(words, weights)
+	words = normalizeAll(words)
+	if len(res) == 0 || len(words) == 0 {
+		return nil
+	}
+	df, total, err := c.store.Frequencies(ctx, words)
+	if err != nil {
+		return err
+	}
+
+	query := make([][]rune, len(words))
+	for i, w := range words {
+		query[i] = []rune(w)
+	}
+	matches := make([][]bool, len(res))
+	found := make([]int, len(words))
+	for i, r := range res {
+		matches[i] = make([]bool, len(words))
+		parts := SplitCPE(r.CPE)
+		if len(parts) < 5 {
+			continue
+		}
+		tokens := append(c.canonize(parts[3]), c.canonize(parts[4])...)
+		for j, w := range words {
+			for _, t := range tokens {
+				if strings.Contains(t, w) || levenshtein(query[j], []rune(t), len(query[j])/fuzzyRunesPerEdit) <= len(query[j])/fuzzyRunesPerEdit {
+					matches[i][j] = true
+					found[j]++
+					break
+				}
+			}
+		}
+	}
+
+	idf := make([]float64, len(words))
+	for j := range words {
+		n := max(df[j], found[j], 1)
+		idf[j] = math.Log(1+float64(max(total, n))/float64(n)) * weightOf(weights, j)
+	}
+	for i := range res {
+		res[i].Score = 0
+		res[i].Scored = true
+		for j, m := range matches[i] {
+			if m {
+				res[i].Score += idf[j]
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Score > res[j].Score
+	})
+	return nil
+}
@@ -0,0 +1,50 @@
+package guesser
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScoreByIDFOrdersUnmatchedLast(t *testing.T) {
+	c := newTestClient(t, map[string]float64{
+		"cpe:2.3:a:apache:struts":  1,
+		"cpe:2.3:a:apache:tomcat":  2,
+		"cpe:2.3:o:vendor:product": 50,
+	})
+	// The unmatched result outranks the others but scores zero
+	res := []Result{
+		{Rank: 50, CPE: "cpe:2.3:o:vendor:product"},
+		{Rank: 2, CPE: "cpe:2.3:a:apache:tomcat"},
+		{Rank: 1, CPE: "cpe:2.3:a:apache:struts"},
+	}
+	if err := c.ScoreByIDF(context.Background(), res, []string{"apache", "struts"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	SortByPartPriority(res, []string{"o", "a", "h"})
+
+	want := []string{"cpe:2.3:a:apache:struts", "cpe:2.3:a:apache:tomcat", "cpe:2.3:o:vendor:product"}
+	for i, cpe := range resultCPEs(res) {
+		if cpe != want[i] {
+			t.Fatalf("order = %v, want %v", resultCPEs(res), want)
+		}
+	}
+	last := res[len(res)-1]
+	if !last.Scored || last.Score != 0 {
+		t.Errorf("unmatched result: Scored = %v, Score = %v, want scored 0", last.Scored, last.Score)
+	}
+}
+
+func TestSortByPartPriorityUnscored(t *testing.T) {
+	res := []Result{
+		{Rank: 1, CPE: "cpe:2.3:a:linux:linux_tools"},
+		{Rank: 1, CPE: "cpe:2.3:o:linux:linux_kernel"},
+		{Rank: 3, CPE: "cpe:2.3:h:cisco:router"},
+	}
+	SortByPartPriority(res, []string{"o", "a", "h"})
+	want := []string{"cpe:2.3:h:cisco:router", "cpe:2.3:o:linux:linux_kernel", "cpe:2.3:a:linux:linux_tools"}
+	for i, cpe := range resultCPEs(res) {
+		if cpe != want[i] {
+			t.Fatalf("order = %v, want %v", resultCPEs(res), want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package guesser
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store keeping the index in process memory, for deployments
+// without Valkey/Redis. Only the overall rank of a CPE is kept; the per-word
+// ranks of the Redis index are not used by searches.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	words      map[string]map[string]struct{}
+	ranks      map[string]float64
+	products   map[string]map[string]struct{}
+	titles     map[string]string
+	refs       map[string]map[string]struct{}
+	versions   map[string]map[string]struct{}
+	deprecated map[string]string
+	imported   time.Time
+	checkpoint map[string]string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		words:      make(map[string]map[string]struct{}),
+		ranks:      make(map[string]float64),
+		products:   make(map[string]map[string]struct{}),
+		titles:     make(map[string]string),
+		refs:       make(map[string]map[string]struct{}),
+		versions:   make(map[string]map[string]struct{}),
+		deprecated: make(map[string]string),
+		checkpoint: make(map[string]string),
+	}
+}
+
+// Len returns the number of indexed CPEs and words.
+func (s *MemoryStore) Len() (cpes, words int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.ranks), len(s.words)
+}
+
+func (s *MemoryStore) Intersect(ctx context.Context, words []string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	// Walk the smallest set, checking the others
+	smallest := s.words[words[0]]
+	for _, w := range words[1:] {
+		if len(s.words[w]) < len(smallest) {
+			smallest = s.words[w]
+		}
+	}
+	var out []string
+	for cpe := range smallest {
+		inAll := true
+		for _, w := range words {
+			if _, ok := s.words[w][cpe]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			out = append(out, cpe)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) Members(ctx context.Context, words []string) ([][]string, error) {
+	return s.Sample(ctx, words, -1)
+}
+
+// Sample returns the first n members of each set in map order, which Go
+// randomizes. A negative n returns every member.
+func (s *MemoryStore) Sample(ctx context.Context, words []string, n int) ([][]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([][]string, len(words))
+	for i, w := range words {
+		out[i] = members(s.words[w], n)
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) PartialMatch(ctx context.Context, sub string, fn func(cpes []string) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for w, set := range s.words {
+		if !strings.Contains(w, sub) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(members(set, -1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Words(ctx context.Context, fn func(words []string) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chunk := make([]string, 0, wordsChunk)
+	for w := range s.words {
+		if chunk = append(chunk, w); len(chunk) == wordsChunk {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(chunk); err != nil {
+				return err
+			}
+			chunk = chunk[:0]
+		}
+	}
+	if len(chunk) > 0 {
+		return fn(chunk)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, prefix string, n int) ([]Completion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Completion
+	for w, set := range s.words {
+		if strings.HasPrefix(w, prefix) {
+			out = append(out, Completion{Word: w, CPEs: len(set)})
+		}
+	}
+	return topCompletions(out, n), nil
+}
+
+func (s *MemoryStore) Frequencies(ctx context.Context, words []string) ([]int, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	df := make([]int, len(words))
+	for i, w := range words {
+		df[i] = len(s.words[w])
+	}
+	return df, len(s.ranks), nil
+}
+
+func (s *MemoryStore) Ranks(ctx context.Context, cpes []string) ([]float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ranks := make([]float64, len(cpes))
+	for i, cpe := range cpes {
+		ranks[i] = s.ranks[cpe]
+	}
+	return ranks, nil
+}
+
+func (s *MemoryStore) Products(ctx context.Context, vendor string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return members(s.products[Normalize(vendor)], -1), nil
+}
+
+func (s *MemoryStore) Titles(ctx context.Context, cpes []string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	titles := make([]string, len(cpes))
+	for i, cpe := range cpes {
+		titles[i] = s.titles[cpe]
+	}
+	return titles, nil
+}
+
+func (s *MemoryStore) References(ctx context.Context, cpes []string) ([][]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([][]string, len(cpes))
+	for i, cpe := range cpes {
+		out[i] = members(s.refs[cpe], -1)
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) Versions(ctx context.Context, cpe string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return members(s.versions[cpe], -1), nil
+}
+
+func (s *MemoryStore) Deprecated(ctx context.Context, cpes []string) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]string)
+	for _, cpe := range cpes {
+		if repl, ok := s.deprecated[cpe]; ok {
+			out[cpe] = repl
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) LastImport(ctx context.Context) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.imported, nil
+}
+
+func (s *MemoryStore) Checkpoint(ctx context.Context) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.checkpoint), nil
+}
+
+func (s *MemoryStore) NewBatch() Batch {
+	return &memoryBatch{s: s}
+}
+
+// members returns up to n members of set, all of them when n is negative.
+func members(set map[string]struct{}, n int) []string {
+	if n < 0 || n > len(set) {
+		n = len(set)
+	}
+	out := make([]string, 0, n)
+	for m := range set {
+		if len(out) == n {
+			break
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// addMember adds m to the set at key of sets.
+func addMember(sets map[string]map[string]struct{}, key, m string) {
+	set, ok := sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		sets[key] = set
+	}
+	set[m] = struct{}{}
+}
+
+// memoryBatch queues writes until Exec applies them under the store lock.
+type memoryBatch struct {
+	s   *MemoryStore
+	ops []func(s *MemoryStore)
+}
+
+func (b *memoryBatch) AddWord(word, cpe string) {
+	b.ops = append(b.ops, func(s *MemoryStore) { addMember(s.words, word, cpe) })
+}
+
+func (b *memoryBatch) AddProduct(vendor, cpe string) {
+	b.ops = append(b.ops, func(s *MemoryStore) { addMember(s.products, Normalize(vendor), cpe) })
+}
+
+func (b *memoryBatch) SetTitle(cpe, title string) {
+	b.ops = append(b.ops, func(s *MemoryStore) { s.titles[cpe] = title })
+}
+
+func (b *memoryBatch) AddReference(cpe, ref string) {
+	b.ops = append(b.ops, func(s *MemoryStore) { addMember(s.refs, cpe, ref) })
+}
+
+func (b *memoryBatch) AddVersion(cpe, version string) {
+	b.ops = append(b.ops, func(s *MemoryStore) { addMember(s.versions, cpe, version) })
+}
+
+func (b *memoryBatch) SetDeprecated(cpe, replacement string) {
+	b.ops = append(b.ops, func(s *MemoryStore) { s.deprecated[cpe] = replacement })
+}
+
+func (b *memoryBatch) ClearDeprecated(cpe string) {
+	b.ops = append(b.ops, func(s *MemoryStore) { delete(s.deprecated, cpe) })
+}
+
+func (b *memoryBatch) SetLastImport(t time.Time) {
+	b.ops = append(b.ops, func(s *MemoryStore) { s.imported = t })
+}
+
+func (b *memoryBatch) SetCheckpoint(field, value string) {
+	b.ops = append(b.ops, func(s *MemoryStore) { s.checkpoint[field] = value })
+}
+
+func (b *memoryBatch) ClearCheckpoint() {
+	b.ops = append(b.ops, func(s *MemoryStore) { clear(s.checkpoint) })
+}
+
+func (b *memoryBatch) IncrRank(words []string, cpe string, delta float64) {
+	b.ops = append(b.ops, func(s *MemoryStore) { s.ranks[cpe] += delta })
+}
+
+func (b *memoryBatch) SetRank(words []string, cpe string, rank float64) {
+	b.ops = append(b.ops, func(s *MemoryStore) { s.ranks[cpe] = rank })
+}
+
+func (b *memoryBatch) Exec(ctx context.Context) error {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	for _, op := range b.ops {
+		op(b.s)
+	}
+	b.ops = b.ops[:0]
+	return nil
+}
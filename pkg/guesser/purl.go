@@ -0,0 +1,231 @@
+package guesser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// PURL is a package URL, pkg:type/namespace/name@version, as used by SBOM
+// tools. Qualifiers and subpaths play no part in CPE guessing and are dropped.
+type PURL struct {
+	Type      string
+	Namespace string
+	Name      string
+	Version   string
+}
+
+// DefaultPURLMappings maps well-known packages, keyed by type/namespace/name
+// or type/name, to the CPE line the NVD files them under when it can't be
+// guessed from the package name.
+var DefaultPURLMappings = map[string]string{
+	"pypi/django":           "cpe:2.3:a:djangoproject:django",
+	"pypi/flask":            "cpe:2.3:a:palletsprojects:flask",
+	"pypi/jinja2":           "cpe:2.3:a:palletsprojects:jinja",
+	"pypi/pillow":           "cpe:2.3:a:python:pillow",
+	"pypi/requests":         "cpe:2.3:a:python:requests",
+	"pypi/urllib3":          "cpe:2.3:a:python:urllib3",
+	"pypi/pyyaml":           "cpe:2.3:a:pyyaml:pyyaml",
+	"npm/lodash":            "cpe:2.3:a:lodash:lodash",
+	"npm/express":           "cpe:2.3:a:expressjs:express",
+	"npm/minimist":          "cpe:2.3:a:substack:minimist",
+	"npm/axios":             "cpe:2.3:a:axios:axios",
+	"npm/jquery":            "cpe:2.3:a:jquery:jquery",
+	"gem/rails":             "cpe:2.3:a:rubyonrails:rails",
+	"nuget/newtonsoft.json": "cpe:2.3:a:newtonsoft:json.net",
+	"maven/org.apache.logging.log4j/log4j-core":         "cpe:2.3:a:apache:log4j",
+	"maven/com.fasterxml.jackson.core/jackson-databind": "cpe:2.3:a:fasterxml:jackson-databind",
+	"maven/org.yaml/snakeyaml":                          "cpe:2.3:a:snakeyaml_project:snakeyaml",
+}
+
+// ParsePURL parses a package URL.
+func ParsePURL(s string) (PURL, error) {
+	var p PURL
+	rest, ok := cutPrefixFold(s, "pkg:")
+	if !ok {
+		return p, fmt.Errorf("invalid package URL %q: missing pkg: scheme", s)
+	}
+	rest = strings.TrimLeft(rest, "/")
+	if i := strings.LastIndexByte(rest, '#'); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.LastIndexByte(rest, '?'); i >= 0 {
+		rest = rest[:i]
+	}
+
+	typ, rest, ok := strings.Cut(rest, "/")
+	if !ok || typ == "" {
+		return p, fmt.Errorf("invalid package URL %q: missing type", s)
+	}
+	p.Type = strings.ToLower(typ)
+
+	// The version follows the last @ of the name; an npm scope in the
+	// namespace starts with one too
+	rest = strings.Trim(rest, "/")
+	if i := strings.LastIndexByte(rest, '@'); i > strings.LastIndexByte(rest, '/') && i > 0 {
+		version, err := url.PathUnescape(rest[i+1:])
+		if err != nil {
+			return p, fmt.Errorf("invalid package URL %q: %w", s, err)
+		}
+		p.Version = version
+		rest = rest[:i]
+	}
+
+	segments := strings.Split(rest, "/")
+	for i, seg := range segments {
+		dec, err := url.PathUnescape(seg)
+		if err != nil {
+			return p, fmt.Errorf("invalid package URL %q: %w", s, err)
+		}
+		segments[i] = dec
+	}
+	p.Name = segments[len(segments)-1]
+	p.Namespace = strings.Join(segments[:len(segments)-1], "/")
+	if p.Name == "" {
+		return p, fmt.Errorf("invalid package URL %q: missing name", s)
+	}
+	return p, nil
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// keys returns the mapping table keys of p, most specific first.
+func (p PURL) keys() []string {
+	name := strings.ToLower(p.Name)
+	if p.Type == "pypi" {
+		// PyPI treats - and _ alike
+		name = strings.ReplaceAll(name, "_", "-")
+	}
+	if p.Namespace == "" {
+		return []string{p.Type + "/" + name}
+	}
+	return []string{p.Type + "/" + strings.ToLower(p.Namespace) + "/" + name, p.Type + "/" + name}
+}
+
+// tokens splits s into its lowercase alphanumeric runs.
+func tokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SetPURLMappings adds mappings to the default package URL mapping table, or
+// overrides its entries. Keys are type/namespace/name or type/name, values
+// CPE lines.
+func (c *Client) SetPURLMappings(mappings map[string]string) {
+	c.purlMappings = make(map[string]string, len(DefaultPURLMappings)+len(mappings))
+	for k, v := range DefaultPURLMappings {
+		c.purlMappings[k] = v
+	}
+	for k, v := range mappings {
+		c.purlMappings[strings.ToLower(k)] = v
+	}
+}
+
+// Base confidences of PURL candidates by how they were found.
+const (
+	confidenceMapping = 1.0
+	confidenceName    = 0.8
+	confidenceExact   = 0.6
+	confidencePartial = 0.4
+	// confidenceVendor is added when the vendor appears in the package URL
+	confidenceVendor = 0.15
+)
+
+// PURL returns up to limit candidate CPE lines for the package p, best
+// first, and how they were found: "mapping" when the package is in the
+// mapping table and the CPE line it maps to is indexed, otherwise "search".
+// The package name is searched split as the index words are, and then as its
+// letter and digit tokens.
+//
+// Candidates are ordered by their Confidence, between 0 and 1: 1 for a
+// mapping, otherwise a base for the search that found them, higher for a
+// whole-name match than for a partial one, raised when the vendor appears in
+// the namespace or name, such as apache for org.apache.commons, and scaled by
+// the candidate's share of the total rank.
+func (c *Client) PURL(ctx context.Context, p PURL, limit int) (_ []Result, source string, err error) {
+	ctx, span := tracer.Start(ctx, "guesser.PURL")
+	defer func() { endSpan(span, err) }()
+
+	mappings := c.purlMappings
+	if mappings == nil {
+		mappings = DefaultPURLMappings
+	}
+	for _, key := range p.keys() {
+		cpe, ok := mappings[key]
+		if !ok {
+			continue
+		}
+		res, err := c.rank(ctx, []string{cpe})
+		if err != nil {
+			return nil, "", err
+		}
+		// A mapping to a CPE line missing from the index, such as one the
+		// dictionary dropped, leaves the package to the search
+		if res[0].Rank > 0 {
+			res[0].Confidence = confidenceMapping
+			return res, "mapping", nil
+		}
+	}
+
+	base := confidenceName
+	res, _, err := c.Search(ctx, []string{p.Name}, SearchOptions{Strategy: ExactOnly})
+	if err == nil && len(res) == 0 {
+		var pass string
+		res, pass, err = c.Search(ctx, tokens(p.Name), SearchOptions{})
+		base = confidenceExact
+		if pass == "partial" {
+			base = confidencePartial
+		}
+	}
+	if errors.Is(err, ErrTooManyWords) {
+		return nil, "search", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	hints := make(map[string]bool)
+	for _, t := range append(tokens(p.Namespace), tokens(p.Name)...) {
+		hints[t] = true
+	}
+	hinted := func(r Result) bool {
+		parts := SplitCPE(r.CPE)
+		return len(parts) >= 5 && hints[Normalize(parts[3])]
+	}
+	var total float64
+	for _, r := range res {
+		total += r.Rank
+	}
+	for i := range res {
+		conf := base
+		if base == confidencePartial {
+			conf *= res[i].Coverage
+		}
+		if hinted(res[i]) {
+			conf += confidenceVendor
+		}
+		share := 1 / float64(len(res))
+		if total > 0 {
+			share = res[i].Rank / total
+		}
+		res[i].Confidence = math.Round(conf*(0.5+0.5*share)*100) / 100
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Confidence > res[j].Confidence
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, "search", nil
+}
@@ -0,0 +1,38 @@
+package guesser
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPURLMapping(t *testing.T) {
+	c := newTestClient(t, map[string]float64{
+		"cpe:2.3:a:fasterxml:jackson-databind": 3,
+		"cpe:2.3:a:lodash_project:lodash":      2,
+	})
+	tests := []struct {
+		purl, cpe, source string
+		mapped            bool
+	}{
+		{"pkg:maven/com.fasterxml.jackson.core/jackson-databind@2.15.0", "cpe:2.3:a:fasterxml:jackson-databind", "mapping", true},
+		// The default mapping names lodash:lodash, which isn't indexed
+		{"pkg:npm/lodash@4.17.21", "cpe:2.3:a:lodash_project:lodash", "search", false},
+	}
+	for _, tt := range tests {
+		p, err := ParsePURL(tt.purl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, source, err := c.PURL(context.Background(), p, 1)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.purl, err)
+		}
+		if source != tt.source || len(res) != 1 || res[0].CPE != tt.cpe {
+			t.Errorf("%s: got %v from %s, want %s from %s", tt.purl, res, source, tt.cpe, tt.source)
+			continue
+		}
+		if mapped := res[0].Confidence == confidenceMapping; mapped != tt.mapped {
+			t.Errorf("%s: confidence %v", tt.purl, res[0].Confidence)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package guesser
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Strategy selects which searches Search runs and in which order.
+type Strategy string
+
+const (
+	// ExactOnly only looks for CPEs matching every query word.
+	ExactOnly Strategy = "exact_only"
+	// PartialOnly only runs the partial search.
+	PartialOnly Strategy = "partial_only"
+	// ExactThenPartial falls back to a partial search when nothing matches
+	// exactly. It is the default.
+	ExactThenPartial Strategy = "exact_then_partial"
+	// PartialThenExact falls back to an exact search when the partial search
+	// finds nothing.
+	PartialThenExact Strategy = "partial_then_exact"
+)
+
+// ParseStrategy validates a strategy name. An empty name is ExactThenPartial.
+func ParseStrategy(name string) (Strategy, error) {
+	switch s := Strategy(name); s {
+	case "":
+		return ExactThenPartial, nil
+	case ExactOnly, PartialOnly, ExactThenPartial, PartialThenExact:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown strategy %q", name)
+	}
+}
+
+// SearchOptions control how Search looks for CPEs.
+type SearchOptions struct {
+	Strategy Strategy
+	// Anchored makes the partial pass match whole words only, see Anchored.
+	Anchored bool
+	// DisablePartial skips the partial pass whatever the strategy.
+	DisablePartial bool
+	// Weights holds a positive weight per query word. Results of the partial
+	// pass then cover the share of the total weight of the words they match,
+	// so matching a heavy word counts for more. Nil weighs every word 1.
+	Weights []float64
+	// Fuzzy is the edit distance up to which a fuzzy pass, see Fuzzy, matches
+	// misspelled words. It runs after the exact pass, before the partial
+	// pass of the default strategy and last otherwise. Zero disables it.
+	Fuzzy int
+	// Budget bounds the time of the search. When the partial pass is still
+	// scanning once it is spent, Search returns the CPEs found so far with
+	// ErrPartialResults. Zero means no budget.
+	Budget time.Duration
+	// Part keeps only the CPEs of this part: a, o or h. A pass finding
+	// none of that part lets the next pass run. Empty keeps every part.
+	Part string
+}
+
+// Search runs the passes selected by opts.Strategy until one finds a CPE. It
+// also returns the name of the last pass run: "exact", "fuzzy", "partial" or
+// "anchored", or an empty string when none ran.
+func (c *Client) Search(ctx context.Context, words []string, opts SearchOptions) ([]Result, string, error) {
+	var deadline time.Time
+	if opts.Budget > 0 {
+		deadline = time.Now().Add(opts.Budget)
+	}
+	if opts.Weights != nil {
+		if len(opts.Weights) != len(words) {
+			return nil, "", fmt.Errorf("%d weights for %d words", len(opts.Weights), len(words))
+		}
+		for i, w := range opts.Weights {
+			if w <= 0 {
+				return nil, "", fmt.Errorf("weight of %q must be positive", words[i])
+			}
+		}
+	}
+
+	var passes []string
+	switch opts.Strategy {
+	case ExactOnly:
+		passes = []string{"exact", "fuzzy"}
+	case PartialOnly:
+		passes = []string{"partial", "fuzzy"}
+	case PartialThenExact:
+		passes = []string{"partial", "exact", "fuzzy"}
+	default:
+		passes = []string{"exact", "fuzzy", "partial"}
+	}
+
+	var res []Result
+	var ran string
+	var err error
+	for _, pass := range passes {
+		switch {
+		case pass == "exact":
+			res, err = c.Exact(ctx, words)
+		case pass == "fuzzy":
+			if opts.Fuzzy <= 0 {
+				continue
+			}
+			res, err = c.Fuzzy(ctx, words, opts.Fuzzy)
+		case opts.DisablePartial:
+			continue
+		case opts.Anchored:
+			pass = "anchored"
+			res, err = c.anchored(ctx, words, opts.Weights)
+		default:
+			res, err = c.partial(ctx, words, opts.Weights, deadline)
+		}
+		ran = pass
+		res = FilterPart(res, opts.Part)
+		if err != nil || len(res) > 0 {
+			break
+		}
+	}
+	return res, ran, err
+}
@@ -0,0 +1,429 @@
+//go:build sqlite_fts5
+
+package guesser
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema creates the index tables. word_fts holds every indexed word
+// once, with a trigram tokenizer so substrings of three characters or more
+// are found through the full-text index.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS words (word TEXT NOT NULL, cpe TEXT NOT NULL, PRIMARY KEY (word, cpe)) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS ranks (cpe TEXT PRIMARY KEY, rank REAL NOT NULL) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS products (vendor TEXT NOT NULL, cpe TEXT NOT NULL, PRIMARY KEY (vendor, cpe)) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS titles (cpe TEXT PRIMARY KEY, title TEXT NOT NULL) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS refs (cpe TEXT NOT NULL, ref TEXT NOT NULL, PRIMARY KEY (cpe, ref)) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS versions (cpe TEXT NOT NULL, version TEXT NOT NULL, PRIMARY KEY (cpe, version)) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS deprecated (cpe TEXT PRIMARY KEY, replacement TEXT NOT NULL) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS meta (key TEXT PRIMARY KEY, value TEXT NOT NULL) WITHOUT ROWID;
+CREATE VIRTUAL TABLE IF NOT EXISTS word_fts USING fts5(word, tokenize = 'trigram');
+`
+
+// sqliteTables are the tables emptied by Reset.
+var sqliteTables = []string{"words", "ranks", "products", "titles", "refs", "versions", "deprecated", "meta", "word_fts"}
+
+// SQLiteStore is a Store keeping the index in a SQLite database file, using
+// FTS5 for partial matches. It is only available in builds with the
+// sqlite_fts5 tag, which needs cgo.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLiteStore opens the index database at path, creating it unless
+// readOnly is set.
+func OpenSQLiteStore(path string, readOnly bool) (*SQLiteStore, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?_busy_timeout=5000"
+	if readOnly {
+		dsn += "&mode=ro"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if !readOnly {
+		if _, err := db.Exec(sqliteSchema); err != nil {
+			db.Close()
+			return nil, err
+		}
+	} else if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// Close closes the index database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Reset empties the index.
+func (s *SQLiteStore) Reset() error {
+	for _, table := range sqliteTables {
+		if _, err := s.db.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of indexed CPEs.
+func (s *SQLiteStore) Len() (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM ranks").Scan(&n)
+	return n, err
+}
+
+func (s *SQLiteStore) Intersect(ctx context.Context, words []string) ([]string, error) {
+	if len(words) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(words))
+	for i, w := range words {
+		args[i] = w
+	}
+	query := "SELECT cpe FROM words WHERE word = ?" +
+		strings.Repeat(" INTERSECT SELECT cpe FROM words WHERE word = ?", len(words)-1)
+	return s.strings(ctx, query, args...)
+}
+
+func (s *SQLiteStore) Members(ctx context.Context, words []string) ([][]string, error) {
+	return s.Sample(ctx, words, -1)
+}
+
+// Sample returns n random members of each set. A negative n returns every
+// member.
+func (s *SQLiteStore) Sample(ctx context.Context, words []string, n int) ([][]string, error) {
+	query := "SELECT cpe FROM words WHERE word = ?"
+	if n >= 0 {
+		query += " ORDER BY random() LIMIT ?"
+	}
+	out := make([][]string, len(words))
+	for i, w := range words {
+		var err error
+		if n >= 0 {
+			out[i], err = s.strings(ctx, query, w, n)
+		} else {
+			out[i], err = s.strings(ctx, query, w)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// PartialMatch looks sub up in the trigram index. Substrings shorter than
+// three characters have no trigrams and scan the word list instead.
+func (s *SQLiteStore) PartialMatch(ctx context.Context, sub string, fn func(cpes []string) error) error {
+	var rows *sql.Rows
+	var err error
+	if len([]rune(sub)) >= 3 {
+		rows, err = s.db.QueryContext(ctx, `SELECT w.word, w.cpe FROM word_fts f JOIN words w ON w.word = f.word
+			WHERE word_fts MATCH ? ORDER BY w.word`, `"`+strings.ReplaceAll(sub, `"`, `""`)+`"`)
+	} else {
+		rows, err = s.db.QueryContext(ctx, "SELECT word, cpe FROM words WHERE instr(word, ?) > 0 ORDER BY word", sub)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var word string
+	var cpes []string
+	for rows.Next() {
+		var w, cpe string
+		if err := rows.Scan(&w, &cpe); err != nil {
+			return err
+		}
+		if w != word && len(cpes) > 0 {
+			if err := fn(cpes); err != nil {
+				return err
+			}
+			cpes = nil
+		}
+		word = w
+		cpes = append(cpes, cpe)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(cpes) > 0 {
+		return fn(cpes)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Words(ctx context.Context, fn func(words []string) error) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT word FROM words")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	chunk := make([]string, 0, wordsChunk)
+	for rows.Next() {
+		var w string
+		if err := rows.Scan(&w); err != nil {
+			return err
+		}
+		if chunk = append(chunk, w); len(chunk) == wordsChunk {
+			if err := fn(chunk); err != nil {
+				return err
+			}
+			chunk = chunk[:0]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(chunk) > 0 {
+		return fn(chunk)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Complete(ctx context.Context, prefix string, n int) ([]Completion, error) {
+	// Words starting with prefix sort between it and it followed by the
+	// highest code point
+	rows, err := s.db.QueryContext(ctx, `SELECT word, COUNT(*) AS n FROM words
+		WHERE word >= ? AND word < ? GROUP BY word ORDER BY n DESC, word LIMIT ?`,
+		prefix, prefix+"\U0010FFFF", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Completion
+	for rows.Next() {
+		var c Completion
+		if err := rows.Scan(&c.Word, &c.CPEs); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteStore) Frequencies(ctx context.Context, words []string) ([]int, int, error) {
+	df := make([]int, len(words))
+	for i, w := range words {
+		if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM words WHERE word = ?", w).Scan(&df[i]); err != nil {
+			return nil, 0, err
+		}
+	}
+	var total int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ranks").Scan(&total)
+	return df, total, err
+}
+
+func (s *SQLiteStore) Ranks(ctx context.Context, cpes []string) ([]float64, error) {
+	ranks := make([]float64, len(cpes))
+	for i, cpe := range cpes {
+		err := s.db.QueryRowContext(ctx, "SELECT rank FROM ranks WHERE cpe = ?", cpe).Scan(&ranks[i])
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return ranks, nil
+}
+
+func (s *SQLiteStore) Products(ctx context.Context, vendor string) ([]string, error) {
+	return s.strings(ctx, "SELECT cpe FROM products WHERE vendor = ?", Normalize(vendor))
+}
+
+func (s *SQLiteStore) Titles(ctx context.Context, cpes []string) ([]string, error) {
+	titles := make([]string, len(cpes))
+	for i, cpe := range cpes {
+		err := s.db.QueryRowContext(ctx, "SELECT title FROM titles WHERE cpe = ?", cpe).Scan(&titles[i])
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return titles, nil
+}
+
+func (s *SQLiteStore) References(ctx context.Context, cpes []string) ([][]string, error) {
+	out := make([][]string, len(cpes))
+	for i, cpe := range cpes {
+		refs, err := s.strings(ctx, "SELECT ref FROM refs WHERE cpe = ?", cpe)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = refs
+	}
+	return out, nil
+}
+
+func (s *SQLiteStore) Versions(ctx context.Context, cpe string) ([]string, error) {
+	return s.strings(ctx, "SELECT version FROM versions WHERE cpe = ?", cpe)
+}
+
+func (s *SQLiteStore) Deprecated(ctx context.Context, cpes []string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, cpe := range cpes {
+		var repl string
+		err := s.db.QueryRowContext(ctx, "SELECT replacement FROM deprecated WHERE cpe = ?", cpe).Scan(&repl)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[cpe] = repl
+	}
+	return out, nil
+}
+
+func (s *SQLiteStore) LastImport(ctx context.Context) (time.Time, error) {
+	var val string
+	err := s.db.QueryRowContext(ctx, "SELECT value FROM meta WHERE key = ?", LastImportKey).Scan(&val)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, val)
+}
+
+func (s *SQLiteStore) Checkpoint(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT key, value FROM meta WHERE substr(key, 1, ?) = ?",
+		len(checkpointPrefix), checkpointPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]string)
+	for rows.Next() {
+		var key, val string
+		if err := rows.Scan(&key, &val); err != nil {
+			return nil, err
+		}
+		out[key[len(checkpointPrefix):]] = val
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteStore) NewBatch() Batch {
+	return &sqliteBatch{db: s.db}
+}
+
+// strings returns the single text column of the rows of query.
+func (s *SQLiteStore) strings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// sqliteBatch queues statements until Exec runs them in one transaction.
+type sqliteBatch struct {
+	db    *sql.DB
+	stmts []sqliteStmt
+}
+
+type sqliteStmt struct {
+	query string
+	args  []interface{}
+}
+
+func (b *sqliteBatch) add(query string, args ...interface{}) {
+	b.stmts = append(b.stmts, sqliteStmt{query: query, args: args})
+}
+
+func (b *sqliteBatch) AddWord(word, cpe string) {
+	// New words go to the full-text index first, while they are still
+	// missing from words
+	b.add("INSERT INTO word_fts (word) SELECT ? WHERE NOT EXISTS (SELECT 1 FROM words WHERE word = ?)", word, word)
+	b.add("INSERT OR IGNORE INTO words (word, cpe) VALUES (?, ?)", word, cpe)
+}
+
+func (b *sqliteBatch) AddProduct(vendor, cpe string) {
+	b.add("INSERT OR IGNORE INTO products (vendor, cpe) VALUES (?, ?)", Normalize(vendor), cpe)
+}
+
+func (b *sqliteBatch) SetTitle(cpe, title string) {
+	b.add("INSERT OR REPLACE INTO titles (cpe, title) VALUES (?, ?)", cpe, title)
+}
+
+func (b *sqliteBatch) AddReference(cpe, ref string) {
+	b.add("INSERT OR IGNORE INTO refs (cpe, ref) VALUES (?, ?)", cpe, ref)
+}
+
+func (b *sqliteBatch) AddVersion(cpe, version string) {
+	b.add("INSERT OR IGNORE INTO versions (cpe, version) VALUES (?, ?)", cpe, version)
+}
+
+func (b *sqliteBatch) SetDeprecated(cpe, replacement string) {
+	b.add("INSERT OR REPLACE INTO deprecated (cpe, replacement) VALUES (?, ?)", cpe, replacement)
+}
+
+func (b *sqliteBatch) ClearDeprecated(cpe string) {
+	b.add("DELETE FROM deprecated WHERE cpe = ?", cpe)
+}
+
+func (b *sqliteBatch) SetLastImport(t time.Time) {
+	b.add("INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)", LastImportKey, t.UTC().Format(time.RFC3339))
+}
+
+func (b *sqliteBatch) SetCheckpoint(field, value string) {
+	b.add("INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)", checkpointPrefix+field, value)
+}
+
+func (b *sqliteBatch) ClearCheckpoint() {
+	b.add("DELETE FROM meta WHERE substr(key, 1, ?) = ?", len(checkpointPrefix), checkpointPrefix)
+}
+
+func (b *sqliteBatch) IncrRank(words []string, cpe string, delta float64) {
+	b.add("INSERT INTO ranks (cpe, rank) VALUES (?, ?) ON CONFLICT (cpe) DO UPDATE SET rank = rank + excluded.rank", cpe, delta)
+}
+
+func (b *sqliteBatch) SetRank(words []string, cpe string, rank float64) {
+	b.add("INSERT INTO ranks (cpe, rank) VALUES (?, ?) ON CONFLICT (cpe) DO UPDATE SET rank = excluded.rank", cpe, rank)
+}
+
+func (b *sqliteBatch) Exec(ctx context.Context) error {
+	if len(b.stmts) == 0 {
+		return nil
+	}
+	defer func() { b.stmts = b.stmts[:0] }()
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	prepared := make(map[string]*sql.Stmt)
+	for _, st := range b.stmts {
+		stmt, ok := prepared[st.query]
+		if !ok {
+			if stmt, err = tx.PrepareContext(ctx, st.query); err != nil {
+				tx.Rollback()
+				return err
+			}
+			prepared[st.query] = stmt
+		}
+		if _, err := stmt.ExecContext(ctx, st.args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,55 @@
+package guesser
+
+// DefaultStopwords are the noise words that appear in too many vendor and
+// product names to tell CPEs apart, such as company suffixes and generic
+// product kinds.
+var DefaultStopwords = []string{
+	"a", "an", "and", "the", "of", "for", "by",
+	"inc", "corp", "corporation", "co", "ltd", "llc", "gmbh",
+	"software", "server", "project", "foundation", "team", "org", "com",
+}
+
+// Stopwords is a set of words left out of the index and of queries. A nil
+// set leaves every word in.
+type Stopwords map[string]bool
+
+// NewStopwords returns the set of words, plus DefaultStopwords when defaults
+// is set.
+func NewStopwords(defaults bool, words []string) Stopwords {
+	s := make(Stopwords)
+	if defaults {
+		for _, w := range DefaultStopwords {
+			s[w] = true
+		}
+	}
+	for _, w := range words {
+		s[Normalize(w)] = true
+	}
+	return s
+}
+
+// Filter returns words without the stopwords, or words unchanged when they
+// are all stopwords so there is something left to look up: the words of
+// "apache_http_server" are apache and http while "server" stays server.
+func (s Stopwords) Filter(words []string) []string {
+	if s.all(words) {
+		return words
+	}
+	out := make([]string, 0, len(words))
+	for _, w := range words {
+		if !s[Normalize(w)] {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// all reports whether every one of words is a stopword.
+func (s Stopwords) all(words []string) bool {
+	for _, w := range words {
+		if !s[Normalize(w)] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,592 @@
+package guesser
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// maxCompletionCandidates caps the words starting with a prefix that
+// RedisStore.Complete counts the CPEs of; the first ones in lexical order are
+// kept.
+const maxCompletionCandidates = 1000
+
+// wordsChunk is the number of words Words hands over at a time.
+const wordsChunk = 1000
+
+// ranksChunk is the number of CPEs RedisStore.Ranks looks up per command.
+const ranksChunk = 1000
+
+// Store is the storage backend holding a CPE index. Words passed to a Store
+// are already normalized.
+type Store interface {
+	// Intersect returns the CPEs indexed under every one of words.
+	Intersect(ctx context.Context, words []string) ([]string, error)
+	// Members returns the CPEs indexed under each of words.
+	Members(ctx context.Context, words []string) ([][]string, error)
+	// Sample returns up to n random CPEs indexed under each of words.
+	Sample(ctx context.Context, words []string, n int) ([][]string, error)
+	// PartialMatch calls fn with the CPEs of every indexed word containing
+	// sub. An error returned by fn stops the scan and is returned.
+	PartialMatch(ctx context.Context, sub string, fn func(cpes []string) error) error
+	// Words calls fn with every indexed word, some at a time. An error
+	// returned by fn stops the scan and is returned.
+	Words(ctx context.Context, fn func(words []string) error) error
+	// Complete returns up to n indexed words starting with prefix, with the
+	// number of CPEs indexed under each, most CPEs first.
+	Complete(ctx context.Context, prefix string, n int) ([]Completion, error)
+	// Frequencies returns the number of CPEs indexed under each of words,
+	// their document frequency, and the number of indexed CPEs.
+	Frequencies(ctx context.Context, words []string) ([]int, int, error)
+	// Ranks returns the rank of each of cpes, zero for unranked ones.
+	Ranks(ctx context.Context, cpes []string) ([]float64, error)
+	// Products returns the CPEs of vendor.
+	Products(ctx context.Context, vendor string) ([]string, error)
+	// Titles returns the title of each of cpes, empty when it has none.
+	Titles(ctx context.Context, cpes []string) ([]string, error)
+	// References returns the reference URLs of each of cpes.
+	References(ctx context.Context, cpes []string) ([][]string, error)
+	// Versions returns the version components indexed for cpe.
+	Versions(ctx context.Context, cpe string) ([]string, error)
+	// Deprecated returns the replacement of each deprecated CPE among cpes,
+	// empty for those deprecated without one.
+	Deprecated(ctx context.Context, cpes []string) (map[string]string, error)
+	// LastImport returns the time recorded by SetLastImport, the zero time
+	// when there is none.
+	LastImport(ctx context.Context) (time.Time, error)
+	// Checkpoint returns the fields recorded by SetCheckpoint since the
+	// last ClearCheckpoint.
+	Checkpoint(ctx context.Context) (map[string]string, error)
+	// NewBatch starts a batch of writes to the index.
+	NewBatch() Batch
+}
+
+// RankedIntersecter is implemented by the Stores that intersect word sets
+// and join the ranks of the result in one step.
+type RankedIntersecter interface {
+	// IntersectRanked returns the CPEs indexed under every one of words,
+	// with their rank set, highest rank first.
+	IntersectRanked(ctx context.Context, words []string) ([]Result, error)
+}
+
+// FuzzyCandidater is implemented by the Stores keeping a trigram index of
+// their words, which the fuzzy search then compares instead of every word.
+type FuzzyCandidater interface {
+	// FuzzyCandidates returns the indexed words holding at least minShared
+	// of trigrams, the trigrams of a query word. It returns false when the
+	// index has no trigrams.
+	FuzzyCandidates(ctx context.Context, trigrams []string, minShared int) ([]string, bool, error)
+}
+
+// intersectRanked returns the CPEs of store indexed under every one of words,
+// highest rank first, in one step when store is a RankedIntersecter.
+func intersectRanked(ctx context.Context, store Store, words []string) ([]Result, error) {
+	if ri, ok := store.(RankedIntersecter); ok {
+		return ri.IntersectRanked(ctx, words)
+	}
+	cpes, err := store.Intersect(ctx, words)
+	if err != nil || len(cpes) == 0 {
+		return nil, err
+	}
+	ranks, err := store.Ranks(ctx, cpes)
+	if err != nil {
+		return nil, err
+	}
+	return rankedResults(cpes, ranks), nil
+}
+
+// Batch collects writes to a Store until Exec applies them.
+type Batch interface {
+	// AddWord indexes cpe under word.
+	AddWord(word, cpe string)
+	// AddProduct lists cpe among the products of vendor.
+	AddProduct(vendor, cpe string)
+	// SetTitle stores the title of cpe.
+	SetTitle(cpe, title string)
+	// AddReference stores a reference URL of cpe.
+	AddReference(cpe, ref string)
+	// AddVersion stores a version component of cpe.
+	AddVersion(cpe, version string)
+	// SetDeprecated marks cpe as deprecated in favor of replacement, which
+	// may be empty.
+	SetDeprecated(cpe, replacement string)
+	// ClearDeprecated removes the deprecation of cpe.
+	ClearDeprecated(cpe string)
+	// SetLastImport records the start time of a completed import.
+	SetLastImport(t time.Time)
+	// SetCheckpoint records field of the checkpoint of an import.
+	SetCheckpoint(field, value string)
+	// ClearCheckpoint drops the checkpoint of an import.
+	ClearCheckpoint()
+	// IncrRank adds delta to the rank of cpe, overall and for each of words.
+	IncrRank(words []string, cpe string, delta float64)
+	// SetRank sets the rank of cpe, overall and for each of words.
+	SetRank(words []string, cpe string, rank float64)
+	// Exec applies the writes collected so far and empties the batch. The
+	// writes are applied together, so a checkpoint recorded in the batch
+	// is only found when the rest of it was applied too.
+	Exec(ctx context.Context) error
+}
+
+// ClusterHashTag prefixes the index keys in a Valkey cluster. Keys sharing a
+// hash tag hash to the same slot, so multi-key commands like SINTER across
+// word sets keep working; the whole index lives on the shard owning the slot.
+const ClusterHashTag = "{cpe}"
+
+// RedisStore is a Store keeping the index in a Valkey/Redis database, in the
+// keys documented in the README.
+type RedisStore struct {
+	rdb redis.UniversalClient
+	// prefix is prepended to every key, ClusterHashTag in a cluster
+	prefix string
+}
+
+// NewRedisStore returns a Store backed by rdb. With a cluster client the
+// keys are prefixed with ClusterHashTag.
+func NewRedisStore(rdb redis.UniversalClient) *RedisStore {
+	return &RedisStore{rdb: rdb, prefix: KeyPrefix(rdb)}
+}
+
+// KeyPrefix returns the prefix of the index keys in rdb: ClusterHashTag for
+// a cluster client, none otherwise.
+func KeyPrefix(rdb redis.UniversalClient) string {
+	if _, ok := rdb.(*redis.ClusterClient); ok {
+		return ClusterHashTag
+	}
+	return ""
+}
+
+// IndexNode returns the client of the node holding the index keys of rdb:
+// the master owning ClusterHashTag for a cluster client, rdb itself
+// otherwise. Commands without keys, such as SCAN, DBSIZE and RANDOMKEY, must
+// be sent to it.
+func IndexNode(ctx context.Context, rdb redis.UniversalClient) (redis.UniversalClient, error) {
+	if cc, ok := rdb.(*redis.ClusterClient); ok {
+		return cc.MasterForKey(ctx, ClusterHashTag)
+	}
+	return rdb, nil
+}
+
+// key returns the full name of the index key k.
+func (s *RedisStore) key(k string) string {
+	return s.prefix + k
+}
+
+// wordKeys returns the index key of each normalized word.
+func (s *RedisStore) wordKeys(words []string) []string {
+	keys := make([]string, len(words))
+	for i, w := range words {
+		keys[i] = s.key("w:" + w)
+	}
+	return keys
+}
+
+func (s *RedisStore) Intersect(ctx context.Context, words []string) ([]string, error) {
+	keys := s.wordKeys(words)
+	if len(keys) == 1 {
+		return s.rdb.SMembers(ctx, keys[0]).Result()
+	}
+	return s.rdb.SInter(ctx, keys...).Result()
+}
+
+// IntersectRanked intersects the word sets and rank:cpe in a single ZINTER,
+// the word sets weighted 0 so the scores are the ranks. CPEs without a rank
+// are left out.
+func (s *RedisStore) IntersectRanked(ctx context.Context, words []string) ([]Result, error) {
+	keys := append(s.wordKeys(words), s.key("rank:cpe"))
+	weights := make([]float64, len(keys))
+	weights[len(keys)-1] = 1
+	zs, err := s.rdb.ZInterWithScores(ctx, &redis.ZStore{Keys: keys, Weights: weights}).Result()
+	if err != nil || len(zs) == 0 {
+		return nil, err
+	}
+	// ZINTER returns the lowest scores first
+	res := make([]Result, len(zs))
+	for i, z := range zs {
+		res[len(zs)-1-i] = Result{Rank: z.Score, CPE: z.Member.(string)}
+	}
+	return res, nil
+}
+
+func (s *RedisStore) Members(ctx context.Context, words []string) ([][]string, error) {
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(words))
+	for i, key := range s.wordKeys(words) {
+		cmds[i] = pipe.SMembers(ctx, key)
+	}
+	return stringSlices(pipe.Exec(ctx))
+}
+
+func (s *RedisStore) Sample(ctx context.Context, words []string, n int) ([][]string, error) {
+	pipe := s.rdb.Pipeline()
+	for _, key := range s.wordKeys(words) {
+		pipe.SRandMemberN(ctx, key, int64(n))
+	}
+	return stringSlices(pipe.Exec(ctx))
+}
+
+// PartialMatch looks the words containing sub up in the trigram index when
+// the import built one, intersecting the word sets of the trigrams of sub. A
+// shorter sub, or an index without trigrams, is matched by scanning the word
+// keys.
+func (s *RedisStore) PartialMatch(ctx context.Context, sub string, fn func(cpes []string) error) error {
+	if trigrams := Trigrams(sub); len(trigrams) > 0 {
+		n, err := s.rdb.Exists(ctx, s.key(TrigramsKey)).Result()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return s.trigramMatch(ctx, sub, trigrams, fn)
+		}
+	}
+
+	node, err := IndexNode(ctx, s.rdb)
+	if err != nil {
+		return err
+	}
+	iter := node.Scan(ctx, 0, s.key("w:*"+escapeGlob(sub)+"*"), 0).Iterator()
+	for iter.Next(ctx) {
+		members, err := s.rdb.SMembers(ctx, iter.Val()).Result()
+		if err != nil {
+			return err
+		}
+		if err := fn(members); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// trigramMatch calls fn with the CPEs of the words containing sub, found
+// among the words indexed under all of its trigrams.
+func (s *RedisStore) trigramMatch(ctx context.Context, sub string, trigrams []string, fn func(cpes []string) error) error {
+	keys := make([]string, len(trigrams))
+	for i, t := range trigrams {
+		keys[i] = s.key(TrigramKey(t))
+	}
+	candidates, err := s.rdb.SInter(ctx, keys...).Result()
+	if err != nil {
+		return err
+	}
+	var words []string
+	for _, w := range candidates {
+		if strings.Contains(w, sub) {
+			words = append(words, w)
+		}
+	}
+	for len(words) > 0 {
+		chunk := words[:min(len(words), wordsChunk)]
+		words = words[len(chunk):]
+		sets, err := s.Members(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		for _, cpes := range sets {
+			if err := fn(cpes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FuzzyCandidates counts the trigrams of each word in the trigram sets, when
+// the import built them.
+func (s *RedisStore) FuzzyCandidates(ctx context.Context, trigrams []string, minShared int) ([]string, bool, error) {
+	n, err := s.rdb.Exists(ctx, s.key(TrigramsKey)).Result()
+	if err != nil || n == 0 {
+		return nil, false, err
+	}
+	pipe := s.rdb.Pipeline()
+	for _, t := range trigrams {
+		pipe.SMembers(ctx, s.key(TrigramKey(t)))
+	}
+	sets, err := stringSlices(pipe.Exec(ctx))
+	if err != nil {
+		return nil, false, err
+	}
+	shared := make(map[string]int)
+	for _, set := range sets {
+		for _, w := range set {
+			shared[w]++
+		}
+	}
+	var out []string
+	for w, n := range shared {
+		if n >= minShared {
+			out = append(out, w)
+		}
+	}
+	return out, true, nil
+}
+
+func (s *RedisStore) Words(ctx context.Context, fn func(words []string) error) error {
+	node, err := IndexNode(ctx, s.rdb)
+	if err != nil {
+		return err
+	}
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, s.key("w:*"), wordsChunk).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			for i := range keys {
+				keys[i] = strings.TrimPrefix(keys[i], s.key("w:"))
+			}
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// Complete looks prefix up in the sorted set of words the import builds, or
+// scans the word keys of an index imported without one.
+func (s *RedisStore) Complete(ctx context.Context, prefix string, n int) ([]Completion, error) {
+	var words []string
+	exists, err := s.rdb.Exists(ctx, s.key(WordsLexKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		words, err = s.rdb.ZRangeByLex(ctx, s.key(WordsLexKey), &redis.ZRangeBy{
+			Min:   "[" + prefix,
+			Max:   "[" + prefix + "\xff",
+			Count: maxCompletionCandidates,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		node, err := IndexNode(ctx, s.rdb)
+		if err != nil {
+			return nil, err
+		}
+		iter := node.Scan(ctx, 0, s.key("w:"+escapeGlob(prefix)+"*"), 0).Iterator()
+		for iter.Next(ctx) && len(words) < maxCompletionCandidates {
+			words = append(words, strings.TrimPrefix(iter.Val(), s.key("w:")))
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, len(words))
+	for i, w := range words {
+		cmds[i] = pipe.SCard(ctx, s.key("w:"+w))
+	}
+	if len(words) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]Completion, len(words))
+	for i, w := range words {
+		out[i] = Completion{Word: w, CPEs: int(cmds[i].Val())}
+	}
+	return topCompletions(out, n), nil
+}
+
+func (s *RedisStore) Frequencies(ctx context.Context, words []string) ([]int, int, error) {
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, len(words))
+	for i, w := range words {
+		cmds[i] = pipe.SCard(ctx, s.key("w:"+w))
+	}
+	total := pipe.ZCard(ctx, s.key("rank:cpe"))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, err
+	}
+	df := make([]int, len(words))
+	for i := range words {
+		df[i] = int(cmds[i].Val())
+	}
+	return df, int(total.Val()), nil
+}
+
+// Ranks looks the ranks up with a ZMSCORE per ranksChunk CPEs, all sent in
+// one pipeline. Unranked CPEs get a nil score, read as zero.
+func (s *RedisStore) Ranks(ctx context.Context, cpes []string) ([]float64, error) {
+	if len(cpes) == 0 {
+		return nil, nil
+	}
+	pipe := s.rdb.Pipeline()
+	var cmds []*redis.FloatSliceCmd
+	for rest := cpes; len(rest) > 0; {
+		chunk := rest[:min(len(rest), ranksChunk)]
+		rest = rest[len(chunk):]
+		cmds = append(cmds, pipe.ZMScore(ctx, s.key("rank:cpe"), chunk...))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	ranks := make([]float64, 0, len(cpes))
+	for _, cmd := range cmds {
+		ranks = append(ranks, cmd.Val()...)
+	}
+	return ranks, nil
+}
+
+func (s *RedisStore) Products(ctx context.Context, vendor string) ([]string, error) {
+	return s.rdb.SMembers(ctx, s.key(VendorKey(vendor))).Result()
+}
+
+func (s *RedisStore) Titles(ctx context.Context, cpes []string) ([]string, error) {
+	vals, err := s.rdb.HMGet(ctx, s.key(TitleKey), cpes...).Result()
+	if err != nil {
+		return nil, err
+	}
+	titles := make([]string, len(vals))
+	for i, v := range vals {
+		titles[i], _ = v.(string)
+	}
+	return titles, nil
+}
+
+func (s *RedisStore) References(ctx context.Context, cpes []string) ([][]string, error) {
+	pipe := s.rdb.Pipeline()
+	for _, cpe := range cpes {
+		pipe.SMembers(ctx, s.key(RefsKey(cpe)))
+	}
+	return stringSlices(pipe.Exec(ctx))
+}
+
+func (s *RedisStore) Versions(ctx context.Context, cpe string) ([]string, error) {
+	return s.rdb.SMembers(ctx, s.key(VersionsKey(cpe))).Result()
+}
+
+func (s *RedisStore) Deprecated(ctx context.Context, cpes []string) (map[string]string, error) {
+	if len(cpes) == 0 {
+		return nil, nil
+	}
+	vals, err := s.rdb.HMGet(ctx, s.key(DeprecatedKey), cpes...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for i, v := range vals {
+		if repl, ok := v.(string); ok {
+			out[cpes[i]] = repl
+		}
+	}
+	return out, nil
+}
+
+func (s *RedisStore) LastImport(ctx context.Context) (time.Time, error) {
+	val, err := s.rdb.Get(ctx, s.key(LastImportKey)).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, val)
+}
+
+func (s *RedisStore) Checkpoint(ctx context.Context) (map[string]string, error) {
+	return s.rdb.HGetAll(ctx, s.key(CheckpointKey)).Result()
+}
+
+func (s *RedisStore) NewBatch() Batch {
+	return &redisBatch{s: s, pipe: s.rdb.TxPipeline(), seen: make(map[string]bool)}
+}
+
+// stringSlices returns the values of a pipeline of string slice commands.
+func stringSlices(cmds []redis.Cmder, err error) ([][]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]string, len(cmds))
+	for i, cmd := range cmds {
+		out[i] = cmd.(*redis.StringSliceCmd).Val()
+	}
+	return out, nil
+}
+
+// redisBatch queues writes in a pipeline.
+type redisBatch struct {
+	s    *RedisStore
+	pipe redis.Pipeliner
+	// seen holds the words whose trigrams and prefix index entry the
+	// batch added
+	seen map[string]bool
+}
+
+// AddWord also indexes the trigrams and the prefix of word, once per batch.
+func (b *redisBatch) AddWord(word, cpe string) {
+	ctx := context.Background()
+	b.pipe.SAdd(ctx, b.s.key("w:"+word), cpe)
+	if !b.seen[word] {
+		b.seen[word] = true
+		b.pipe.ZAdd(ctx, b.s.key(WordsLexKey), &redis.Z{Member: word})
+		for _, t := range Trigrams(word) {
+			b.pipe.SAdd(ctx, b.s.key(TrigramKey(t)), word)
+		}
+	}
+}
+
+func (b *redisBatch) AddProduct(vendor, cpe string) {
+	b.pipe.SAdd(context.Background(), b.s.key(VendorKey(vendor)), cpe)
+}
+
+func (b *redisBatch) SetTitle(cpe, title string) {
+	b.pipe.HSet(context.Background(), b.s.key(TitleKey), cpe, title)
+}
+
+func (b *redisBatch) AddReference(cpe, ref string) {
+	b.pipe.SAdd(context.Background(), b.s.key(RefsKey(cpe)), ref)
+}
+
+func (b *redisBatch) AddVersion(cpe, version string) {
+	b.pipe.SAdd(context.Background(), b.s.key(VersionsKey(cpe)), version)
+}
+
+func (b *redisBatch) SetDeprecated(cpe, replacement string) {
+	b.pipe.HSet(context.Background(), b.s.key(DeprecatedKey), cpe, replacement)
+}
+
+func (b *redisBatch) ClearDeprecated(cpe string) {
+	b.pipe.HDel(context.Background(), b.s.key(DeprecatedKey), cpe)
+}
+
+func (b *redisBatch) SetLastImport(t time.Time) {
+	b.pipe.Set(context.Background(), b.s.key(LastImportKey), t.UTC().Format(time.RFC3339), 0)
+}
+
+func (b *redisBatch) SetCheckpoint(field, value string) {
+	b.pipe.HSet(context.Background(), b.s.key(CheckpointKey), field, value)
+}
+
+func (b *redisBatch) ClearCheckpoint() {
+	b.pipe.Del(context.Background(), b.s.key(CheckpointKey))
+}
+
+func (b *redisBatch) IncrRank(words []string, cpe string, delta float64) {
+	ctx := context.Background()
+	for _, w := range words {
+		b.pipe.ZIncrBy(ctx, b.s.key("s:"+w), delta, cpe)
+	}
+	b.pipe.ZIncrBy(ctx, b.s.key("rank:cpe"), delta, cpe)
+}
+
+func (b *redisBatch) SetRank(words []string, cpe string, rank float64) {
+	ctx := context.Background()
+	for _, w := range words {
+		b.pipe.ZAdd(ctx, b.s.key("s:"+w), &redis.Z{Score: rank, Member: cpe})
+	}
+	b.pipe.ZAdd(ctx, b.s.key("rank:cpe"), &redis.Z{Score: rank, Member: cpe})
+}
+
+func (b *redisBatch) Exec(ctx context.Context) error {
+	_, err := b.pipe.Exec(ctx)
+	b.pipe = b.s.rdb.TxPipeline()
+	return err
+}
@@ -0,0 +1,78 @@
+package guesser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Tokenizer splits vendor and product components, and query words, into the
+// words the index is keyed by. The import and the searches must use the same
+// rules for their words to meet.
+type Tokenizer struct {
+	// Separators are the characters words are split at.
+	Separators string
+	// Digits splits where letters and digits meet, so "log4j" gives log, 4
+	// and j.
+	Digits bool
+	// CamelCase splits before an uppercase letter following a lowercase one
+	// and before the last letter of an uppercase run followed by a lowercase
+	// one, so "JetBrainsIDE" gives jetbrains and ide and "XMLParser" xml and
+	// parser.
+	CamelCase bool
+}
+
+// DefaultSeparators are the separators of DefaultTokenizer.
+const DefaultSeparators = "_-."
+
+// DefaultTokenizer splits at underscores, hyphens and dots, so
+// "OpenSSL-1.1.1" gives openssl, 1, 1 and 1.
+var DefaultTokenizer = Tokenizer{Separators: DefaultSeparators}
+
+// Split returns the lowercase words of val, a component of a CPE 2.3
+// formatted string or a query word. Empty words are dropped.
+func (t Tokenizer) Split(val string) []string {
+	val = Unescape(val)
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	runes := []rune(val)
+	for i, r := range runes {
+		if strings.ContainsRune(t.Separators, r) {
+			flush()
+			continue
+		}
+		if len(word) > 0 {
+			prev := word[len(word)-1]
+			switch {
+			case t.Digits && unicode.IsDigit(prev) != unicode.IsDigit(r) && (unicode.IsLetter(prev) || unicode.IsLetter(r)):
+				flush()
+			case t.CamelCase && unicode.IsLower(prev) && unicode.IsUpper(r):
+				flush()
+			case t.CamelCase && unicode.IsUpper(prev) && unicode.IsUpper(r) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+				flush()
+			}
+		}
+		word = append(word, r)
+	}
+	flush()
+	return words
+}
+
+// SplitText returns the lowercase words of free text, such as a dictionary
+// title: the text is cut at spaces and at punctuation other than the
+// separators, and each piece split like a CPE component.
+func (t Tokenizer) SplitText(text string) []string {
+	var words []string
+	pieces := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(t.Separators, r)
+	})
+	for _, p := range pieces {
+		words = append(words, t.Split(p)...)
+	}
+	return words
+}
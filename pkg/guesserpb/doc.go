@@ -0,0 +1,5 @@
+// Package guesserpb holds the gRPC service and messages generated from
+// proto/guesser.proto, for clients of the server's gRPC API.
+package guesserpb
+
+//go:generate protoc -I ../../proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative guesser.proto
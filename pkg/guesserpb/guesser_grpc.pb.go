@@ -0,0 +1,254 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: guesser.proto
+
+package guesserpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// GuesserClient is the client API for Guesser service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GuesserClient interface {
+	// Search returns the CPEs matching the query words, best first.
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// Unique returns the best CPE for the query words.
+	Unique(ctx context.Context, in *UniqueRequest, opts ...grpc.CallOption) (*UniqueResponse, error)
+	// UniqueStream answers a stream of Unique lookups in order, for bulk use.
+	UniqueStream(ctx context.Context, opts ...grpc.CallOption) (Guesser_UniqueStreamClient, error)
+	// Health checks the connection to the index.
+	Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error)
+}
+
+type guesserClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGuesserClient(cc grpc.ClientConnInterface) GuesserClient {
+	return &guesserClient{cc}
+}
+
+func (c *guesserClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, "/cpeguesser.v1.Guesser/Search", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *guesserClient) Unique(ctx context.Context, in *UniqueRequest, opts ...grpc.CallOption) (*UniqueResponse, error) {
+	out := new(UniqueResponse)
+	err := c.cc.Invoke(ctx, "/cpeguesser.v1.Guesser/Unique", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *guesserClient) UniqueStream(ctx context.Context, opts ...grpc.CallOption) (Guesser_UniqueStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Guesser_ServiceDesc.Streams[0], "/cpeguesser.v1.Guesser/UniqueStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &guesserUniqueStreamClient{stream}
+	return x, nil
+}
+
+type Guesser_UniqueStreamClient interface {
+	Send(*UniqueRequest) error
+	Recv() (*UniqueResponse, error)
+	grpc.ClientStream
+}
+
+type guesserUniqueStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *guesserUniqueStreamClient) Send(m *UniqueRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *guesserUniqueStreamClient) Recv() (*UniqueResponse, error) {
+	m := new(UniqueResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *guesserClient) Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error) {
+	out := new(HealthResponse)
+	err := c.cc.Invoke(ctx, "/cpeguesser.v1.Guesser/Health", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GuesserServer is the server API for Guesser service.
+// All implementations must embed UnimplementedGuesserServer
+// for forward compatibility
+type GuesserServer interface {
+	// Search returns the CPEs matching the query words, best first.
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// Unique returns the best CPE for the query words.
+	Unique(context.Context, *UniqueRequest) (*UniqueResponse, error)
+	// UniqueStream answers a stream of Unique lookups in order, for bulk use.
+	UniqueStream(Guesser_UniqueStreamServer) error
+	// Health checks the connection to the index.
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
+	mustEmbedUnimplementedGuesserServer()
+}
+
+// UnimplementedGuesserServer must be embedded to have forward compatible implementations.
+type UnimplementedGuesserServer struct {
+}
+
+func (UnimplementedGuesserServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedGuesserServer) Unique(context.Context, *UniqueRequest) (*UniqueResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unique not implemented")
+}
+func (UnimplementedGuesserServer) UniqueStream(Guesser_UniqueStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method UniqueStream not implemented")
+}
+func (UnimplementedGuesserServer) Health(context.Context, *HealthRequest) (*HealthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Health not implemented")
+}
+func (UnimplementedGuesserServer) mustEmbedUnimplementedGuesserServer() {}
+
+// UnsafeGuesserServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GuesserServer will
+// result in compilation errors.
+type UnsafeGuesserServer interface {
+	mustEmbedUnimplementedGuesserServer()
+}
+
+func RegisterGuesserServer(s grpc.ServiceRegistrar, srv GuesserServer) {
+	s.RegisterService(&Guesser_ServiceDesc, srv)
+}
+
+func _Guesser_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GuesserServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cpeguesser.v1.Guesser/Search",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GuesserServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Guesser_Unique_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UniqueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GuesserServer).Unique(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cpeguesser.v1.Guesser/Unique",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GuesserServer).Unique(ctx, req.(*UniqueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Guesser_UniqueStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(GuesserServer).UniqueStream(&guesserUniqueStreamServer{stream})
+}
+
+type Guesser_UniqueStreamServer interface {
+	Send(*UniqueResponse) error
+	Recv() (*UniqueRequest, error)
+	grpc.ServerStream
+}
+
+type guesserUniqueStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *guesserUniqueStreamServer) Send(m *UniqueResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *guesserUniqueStreamServer) Recv() (*UniqueRequest, error) {
+	m := new(UniqueRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Guesser_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GuesserServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cpeguesser.v1.Guesser/Health",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GuesserServer).Health(ctx, req.(*HealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Guesser_ServiceDesc is the grpc.ServiceDesc for Guesser service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Guesser_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cpeguesser.v1.Guesser",
+	HandlerType: (*GuesserServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Search",
+			Handler:    _Guesser_Search_Handler,
+		},
+		{
+			MethodName: "Unique",
+			Handler:    _Guesser_Unique_Handler,
+		},
+		{
+			MethodName: "Health",
+			Handler:    _Guesser_Health_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UniqueStream",
+			Handler:       _Guesser_UniqueStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "guesser.proto",
+}